@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// The commands, given before the flags. Without a command the updater runs update cycles.
+const (
+	commandRun        = "run"
+	commandValidate   = "validate-config"
+	commandPlan       = "plan"
+	commandApply      = "apply"
+	commandStatus     = "status"
+	commandCheck      = "check"
+	commandDrain      = "drain"
+	commandUpdate     = "update"
+	commandReactivate = "reactivate"
+	commandReport     = "report"
+)
+
+// commands describes each command in the usage message.
+var commands = []struct {
+	name        string
+	description string
+}{
+	{commandRun, "Run update cycles in the clusters; the default when no command is given."},
+	{commandValidate, "Check the configuration and list every problem found, without updating anything."},
+	{commandPlan, "Write the plan of the instances a run would update to -plan, without updating anything."},
+	{commandApply, "Carry out the plan in -plan, refusing to start in a cluster that drifted from it."},
+	{commandStatus, "Show the Bottlerocket version and update state of every instance."},
+	{commandCheck, "Run the check document and show its output for each instance."},
+	{commandDrain, "Drain the instances given with -instance, leaving them DRAINING."},
+	{commandUpdate, "Update the instances given with -instance, one at a time, as a run would."},
+	{commandReactivate, "Return DRAINING Bottlerocket instances, given with -instance or -all, to ACTIVE."},
+	{commandReport, "Write the version inventory and compliance report of every instance to -report, in -report-format."},
+}
+
+// parseCommand returns the command given before the flags, and the remaining arguments.
+func parseCommand(args []string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return commandRun, args, nil
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return args[0], args[1:], nil
+		}
+	}
+	return "", nil, fmt.Errorf("unknown command %q, run with -help to list the commands", args[0])
+}
+
+// targetsInstances reports whether the command acts on the instances given with -instance.
+func targetsInstances(command string) bool {
+	return command == commandDrain || command == commandUpdate || command == commandReactivate
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", c.name, c.description)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
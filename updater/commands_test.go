@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCommand(t *testing.T) {
+	command, args, err := parseCommand([]string{"-cluster", "test-cluster"})
+	require.NoError(t, err)
+	assert.Equal(t, commandRun, command)
+	assert.Equal(t, []string{"-cluster", "test-cluster"}, args)
+
+	command, args, err = parseCommand(nil)
+	require.NoError(t, err)
+	assert.Equal(t, commandRun, command)
+	assert.Empty(t, args)
+
+	command, args, err = parseCommand([]string{"update", "--instance", "i-1"})
+	require.NoError(t, err)
+	assert.Equal(t, commandUpdate, command)
+	assert.Equal(t, []string{"--instance", "i-1"}, args)
+
+	_, _, err = parseCommand([]string{"upgrade"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// configVersion is the version of the configuration file schema.
+	configVersion = 1
+	// configEnvPrefix prefixes the environment variables that set flags, as in
+	// BOTTLEROCKET_ECS_UPDATER_CHECK_DOCUMENT for -check-document.
+	configEnvPrefix = "BOTTLEROCKET_ECS_UPDATER_"
+	// configFlag is the flag naming the configuration file.
+	configFlag = "config"
+)
+
+// configListSeparators holds the separator used to join a list given in the configuration file
+// into the value of a flag that takes a list.
+var configListSeparators = map[string]string{
+	"cluster":             ",",
+	"targets":             ";",
+	"variant-documents":   ";",
+	"alarms":              ",",
+	"include":             ",",
+	"exclude":             ",",
+	"maintenance-windows": ";",
+	"blackout-dates":      ",",
+}
+
+// configEnv returns the environment variable that sets the flag.
+func configEnv(name string) string {
+	return configEnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// loadConfig sets the flags that were not given on the command line from environment variables
+// and then from the configuration file, so that a flag takes precedence over an environment
+// variable, which takes precedence over the file, which takes precedence over the default. A
+// flag given with an empty value counts as not given, as templates such as the CloudFormation
+// stack pass every flag whether or not it is set. It returns every problem found rather than
+// stopping at the first.
+func loadConfig(fs *flag.FlagSet, getenv func(string) string) []error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = f.Value.String() != "" })
+
+	errs := make([]error, 0)
+	if !explicit[configFlag] {
+		if value := getenv(configEnv(configFlag)); value != "" {
+			if err := fs.Set(configFlag, value); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	values := make(map[string]string)
+	if path := fs.Lookup(configFlag).Value.String(); path != "" {
+		var fileErrs []error
+		values, fileErrs = readConfigFile(fs, path)
+		errs = append(errs, fileErrs...)
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] || f.Name == configFlag {
+			return
+		}
+		if value := getenv(configEnv(f.Name)); value != "" {
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid value %q for %s: %v", value, configEnv(f.Name), err))
+			}
+			return
+		}
+		if value, ok := values[f.Name]; ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid value %q for %q in the configuration file: %v", value, f.Name, err))
+			}
+		}
+	})
+	return errs
+}
+
+// readConfigFile reads a YAML or JSON configuration file holding a version and a value for any
+// flag, keyed by the flag name. Flags that take lists also accept a sequence of values.
+func readConfigFile(fs *flag.FlagSet, path string) (map[string]string, []error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, []error{fmt.Errorf("failed to read configuration file: %w", err)}
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, []error{fmt.Errorf("failed to parse configuration file %s: %w", path, err)}
+	}
+
+	errs := make([]error, 0)
+	switch version, ok := doc["version"]; {
+	case !ok:
+		errs = append(errs, fmt.Errorf("configuration file %s has no version, expected version: %d", path, configVersion))
+	case version != configVersion:
+		errs = append(errs, fmt.Errorf("unsupported configuration file version %v, expected %d", version, configVersion))
+	}
+
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make(map[string]string)
+	for _, key := range keys {
+		if key == "version" {
+			continue
+		}
+		if key == configFlag || fs.Lookup(key) == nil {
+			errs = append(errs, fmt.Errorf("unknown key %q in the configuration file", key))
+			continue
+		}
+		value, err := configValue(key, doc[key])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		values[key] = value
+	}
+	return values, errs
+}
+
+// configValue converts a value from the configuration file to the value of the flag.
+func configValue(key string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		sep, ok := configListSeparators[key]
+		if !ok {
+			return "", fmt.Errorf("invalid value for %q in the configuration file: a list is not accepted", key)
+		}
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configValue(key, item)
+			if err != nil {
+				return "", err
+			}
+			if _, nested := item.([]interface{}); nested {
+				return "", fmt.Errorf("invalid value for %q in the configuration file: lists cannot be nested", key)
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, sep), nil
+	case map[string]interface{}:
+		return "", fmt.Errorf("invalid value for %q in the configuration file: a mapping is not accepted", key)
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
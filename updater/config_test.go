@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testFlags returns a flag set with a few flags of each kind used by the updater.
+func testFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String(configFlag, "", "")
+	fs.String("cluster", "", "")
+	fs.String("region", "", "")
+	fs.String("check-document", "", "")
+	fs.String("alarms", "", "")
+	fs.String("maintenance-windows", "", "")
+	fs.Int("quarantine-after", 3, "")
+	fs.Bool("daemon", false, "")
+	fs.Duration("interval", time.Hour, "")
+	return fs
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestConfigEnv(t *testing.T) {
+	assert.Equal(t, "BOTTLEROCKET_ECS_UPDATER_CHECK_DOCUMENT", configEnv("check-document"))
+	assert.Equal(t, "BOTTLEROCKET_ECS_UPDATER_CONFIG", configEnv(configFlag))
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "updater.yaml", `
+version: 1
+cluster: [prod-a, prod-b]
+region: us-west-2
+check-document: file-check
+alarms:
+  - p99-latency
+  - error-rate
+maintenance-windows:
+  - Mon-Fri 22:00-06:00
+  - Sat 00:00-23:59
+quarantine-after: 5
+daemon: true
+interval: 30m
+`)
+	fs := testFlags()
+	require.NoError(t, fs.Parse([]string{"-config", path, "-region", "eu-west-1"}))
+	env := map[string]string{
+		"BOTTLEROCKET_ECS_UPDATER_REGION":         "us-east-1",
+		"BOTTLEROCKET_ECS_UPDATER_CHECK_DOCUMENT": "env-check",
+	}
+	errs := loadConfig(fs, func(name string) string { return env[name] })
+	assert.Empty(t, errs)
+
+	value := func(name string) string { return fs.Lookup(name).Value.String() }
+	assert.Equal(t, "eu-west-1", value("region"), "a flag should take precedence over the environment")
+	assert.Equal(t, "env-check", value("check-document"), "the environment should take precedence over the file")
+	assert.Equal(t, "prod-a,prod-b", value("cluster"))
+	assert.Equal(t, "p99-latency,error-rate", value("alarms"))
+	assert.Equal(t, "Mon-Fri 22:00-06:00;Sat 00:00-23:59", value("maintenance-windows"))
+	assert.Equal(t, "5", value("quarantine-after"))
+	assert.Equal(t, "true", value("daemon"))
+	assert.Equal(t, "30m0s", value("interval"))
+}
+
+func TestLoadConfigEmptyFlags(t *testing.T) {
+	path := writeConfigFile(t, "updater.yaml", `
+version: 1
+region: us-west-2
+check-document: file-check
+alarms: [error-rate]
+`)
+	fs := testFlags()
+	// Empty flags, as passed by the stack for unset parameters, leave the setting to the
+	// environment and the file.
+	require.NoError(t, fs.Parse([]string{"-config", path, "-region", "", "-check-document", "", "-alarms", "", "-cluster", ""}))
+	env := map[string]string{"BOTTLEROCKET_ECS_UPDATER_REGION": "us-east-1"}
+	errs := loadConfig(fs, func(name string) string { return env[name] })
+	assert.Empty(t, errs)
+
+	value := func(name string) string { return fs.Lookup(name).Value.String() }
+	assert.Equal(t, "us-east-1", value("region"))
+	assert.Equal(t, "file-check", value("check-document"))
+	assert.Equal(t, "error-rate", value("alarms"))
+	assert.Equal(t, "", value("cluster"))
+
+	fs = testFlags()
+	require.NoError(t, fs.Parse([]string{"-config", "", "-region", ""}))
+	env = map[string]string{
+		"BOTTLEROCKET_ECS_UPDATER_CONFIG": path,
+		"BOTTLEROCKET_ECS_UPDATER_REGION": "us-east-1",
+	}
+	errs = loadConfig(fs, func(name string) string { return env[name] })
+	assert.Empty(t, errs)
+	assert.Equal(t, "us-east-1", value("region"))
+	assert.Equal(t, "file-check", value("check-document"), "an empty -config should not hide the environment")
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	path := writeConfigFile(t, "updater.json", `{"version": 1, "cluster": "prod", "quarantine-after": 0}`)
+	fs := testFlags()
+	require.NoError(t, fs.Parse(nil))
+	env := map[string]string{"BOTTLEROCKET_ECS_UPDATER_CONFIG": path}
+	errs := loadConfig(fs, func(name string) string { return env[name] })
+	assert.Empty(t, errs)
+	assert.Equal(t, "prod", fs.Lookup("cluster").Value.String())
+	assert.Equal(t, "0", fs.Lookup("quarantine-after").Value.String())
+	assert.Equal(t, "", fs.Lookup("region").Value.String(), "unset values should keep their default")
+}
+
+func TestLoadConfigErrs(t *testing.T) {
+	path := writeConfigFile(t, "updater.yaml", `
+version: 2
+cluster: prod
+clusters: prod
+daemon: [true]
+quarantine-after: many
+region: {name: us-west-2}
+config: other.yaml
+`)
+	fs := testFlags()
+	require.NoError(t, fs.Parse([]string{"-config", path}))
+	env := map[string]string{"BOTTLEROCKET_ECS_UPDATER_INTERVAL": "soon"}
+	errs := loadConfig(fs, func(name string) string { return env[name] })
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	assert.Len(t, messages, 7, "every problem should be reported: %q", messages)
+	all := strings.Join(messages, "\n")
+	for _, want := range []string{
+		"unsupported configuration file version 2",
+		`unknown key "clusters"`,
+		`unknown key "config"`,
+		`"daemon" in the configuration file: a list is not accepted`,
+		`"region" in the configuration file: a mapping is not accepted`,
+		`invalid value "many" for "quarantine-after" in the configuration file`,
+		`invalid value "soon" for BOTTLEROCKET_ECS_UPDATER_INTERVAL`,
+	} {
+		assert.Contains(t, all, want)
+	}
+	assert.Equal(t, "prod", fs.Lookup("cluster").Value.String(), "valid values should still be applied")
+}
+
+func TestLoadConfigFileErrs(t *testing.T) {
+	for name, content := range map[string]string{
+		"missing":   "",
+		"malformed": "version: 1\ncluster: [prod",
+		"version":   "cluster: prod",
+	} {
+		fs := testFlags()
+		path := filepath.Join(os.TempDir(), "does-not-exist.yaml")
+		if name != "missing" {
+			path = writeConfigFile(t, "updater.yaml", content)
+		}
+		require.NoError(t, fs.Parse([]string{"-config", path}))
+		errs := loadConfig(fs, func(string) string { return "" })
+		assert.Len(t, errs, 1, name)
+	}
+}
@@ -1,150 +1,311 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
+	// Embed the time zone database because the updater image does not include one.
+	_ "time/tzdata"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/ecs"
-	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/bottlerocket-os/bottlerocket-ecs-updater/pkg/updater"
 )
 
 var (
-	flagCluster = flag.String("cluster", "", "The short name or full Amazon Resource Name (ARN) of the cluster in which we will manage Bottlerocket instances.")
-	flagRegion  = flag.String("region", "", "The AWS Region in which cluster is running.")
-	flagCheck   = flag.String("check-document", "", "The SSM document name for checking available updates.")
-	flagApply   = flag.String("apply-document", "", "The SSM document name for applying updates.")
-	flagReboot  = flag.String("reboot-document", "", "The SSM document name to initiate a reboot.")
+	flagConfig          = flag.String(configFlag, "", "Optional path to a YAML or JSON configuration file holding a value for any flag, keyed by the flag name.")
+	flagRemoteConfig    = flag.String("remote-config-path", "", "Optional SSM Parameter Store path (e.g. /bottlerocket-ecs-updater/prod) whose parameters paused, blocked-versions, parallel-clusters and failure-budget override the configuration; they are reloaded at the start of every run and between instances.")
+	flagKillSwitch      = flag.String("kill-switch", "", "Optional comma-separated list of kill switches, as ssm:<parameter>, tag:<cluster tag key> or attribute:<container instance attribute>; setting any of them to true, or to an RFC 3339 time until which to pause, stops the rollout before the next drain and interrupts a drain in progress.")
+	flagApproval        = flag.String("approval", "", "Optional comma-separated list of approval sources, as ssm:<parameter>, file:<path> or http; each run publishes its plan and waits until one of them holds the plan ID before draining any instance.")
+	flagApprovalTimeout = flag.Duration("approval-timeout", time.Hour, "How long a plan waits for approval before the run ends as awaiting approval.")
+	flagApprovalSecret  = flag.String("approval-secret", "", "The key with which HTTP approvals and control requests are signed: the X-Approval-Signature header holds the hex-encoded HMAC-SHA256 of the plan ID, or of \"<method> <path> <X-Approval-Timestamp header>\" for /pause, /resume and /trigger. Required for http approval; without it the control endpoints only accept requests from localhost.")
+	flagInstance        = flag.String("instance", "", "Comma-separated list of EC2 instance IDs the status, check, drain, update and reactivate commands act on.")
+	flagAll             = flag.Bool("all", false, "Make the reactivate command return every DRAINING Bottlerocket instance in the cluster to ACTIVE.")
+	flagPlan            = flag.String("plan", "", "The plan file written by the plan command (standard output if empty) and carried out by the apply command.")
+	flagReport          = flag.String("report", "", "The file the report command writes the inventory to (standard output if empty).")
+	flagReportFormat    = flag.String("report-format", updater.FormatJSON, "The format of the inventory written by the report command: json, csv or markdown.")
+	flagReportThreshold = flag.Int("report-threshold", 2, "The number of releases an instance may be behind the newest available version before the report command flags it.")
+	flagCluster         = flag.String("cluster", "", "Comma-separated list of short names or full Amazon Resource Names (ARNs) of the clusters in which we will manage Bottlerocket instances.")
+	flagClusterPattern  = flag.String("cluster-pattern", "", "Optional glob pattern (e.g. \"prod-*\"); every cluster in the Region whose name matches it is managed, in addition to those named with -cluster.")
+	flagClusterTag      = flag.String("cluster-tag", "", "Optional <key>=<value> tag; every cluster in the Region carrying it (and matching -cluster-pattern, if set) is managed, in addition to those named with -cluster.")
+	flagTargets         = flag.String("targets", "", "Optional semicolon-separated list of clusters in other accounts or Regions, as <role ARN>,<region>,<cluster>; the role is assumed to manage the cluster. An empty role uses the updater's credentials and an empty region uses -region.")
+	flagParallel        = flag.Int("parallel-clusters", 1, "The number of clusters updated at the same time.")
+	flagRegion          = flag.String("region", "", "The AWS Region in which the clusters are running, unless a target names another.")
+	flagCheck           = flag.String("check-document", "", "The SSM document name for checking available updates.")
+	flagApply           = flag.String("apply-document", "", "The SSM document name for applying updates.")
+	flagReboot          = flag.String("reboot-document", "", "The SSM document name to initiate a reboot.")
+	flagVariantDocs     = flag.String("variant-documents", "", "Optional semicolon-separated list of SSM documents by Bottlerocket variant, as <variant>=<check>,<apply>,<reboot> (e.g. \"aws-ecs-1-nvidia=,nvidia-apply,\"); empty documents and other variants use the default documents.")
+	flagAlarms          = flag.String("alarms", "", "Optional comma-separated list of CloudWatch alarm names; the rollout halts if any of them is in ALARM state.")
+	flagBudget          = flag.String("failure-budget", "", "Optional number (e.g. 3) or percentage of candidates (e.g. 20%) of failed instances after which the rollout stops.")
+	flagQuarantine      = flag.Int("quarantine-after", 0, "The number of consecutive failed updates after which an instance is quarantined and skipped until an operator clears it; 0, the default, disables quarantine.")
+	flagOptOutAttr      = flag.String("opt-out-attribute", updater.DefaultOptOutAttribute, "The ECS attribute that excludes a container instance from updates when set to true or to an RFC 3339 expiry time; empty disables the attribute.")
+	flagOptOutTag       = flag.String("opt-out-tag", "", "Optional EC2 tag key that excludes an instance from updates when set to true or to an RFC 3339 expiry time.")
+	flagInclude         = flag.String("include", "", "Optional comma-separated list of selectors; only instances matching at least one are updated. Selectors are attribute:<name>=<pattern>, tag:<key>=<pattern>, capacity-provider=<pattern>, asg=<pattern> or instance-type=<pattern>.")
+	flagExclude         = flag.String("exclude", "", "Optional comma-separated list of selectors, as for -include; instances matching any of them are not updated.")
+	flagWindows         = flag.String("maintenance-windows", "", "Optional semicolon-separated list of maintenance windows during which instances may be updated, as day/time ranges (e.g. \"Mon-Fri 22:00-06:00\") or cron expressions with a duration (e.g. \"cron(0 2 * * SAT) 4h\").")
+	flagZone            = flag.String("maintenance-timezone", "UTC", "The IANA time zone in which maintenance windows and blackout dates are interpreted.")
+	flagBlackout        = flag.String("blackout-dates", "", "Optional comma-separated list of dates (e.g. 2021-12-24) or inclusive date ranges (e.g. 2021-12-24..2022-01-02) during which no instance is updated.")
+	flagDaemon          = flag.Bool("daemon", false, "Run continuously, repeating the update cycle on an interval instead of exiting after a single run.")
+	flagInterval        = flag.Duration("interval", time.Hour, "The time between the start of update cycles in daemon mode.")
+	flagJitter          = flag.Duration("jitter", 5*time.Minute, "The maximum random delay added to the interval between update cycles in daemon mode.")
+	flagBackoff         = flag.Duration("backoff", time.Hour, "In daemon mode, how long an instance is skipped after its first failed update; doubled after each further consecutive failure.")
+	flagMaxBackoff      = flag.Duration("max-backoff", 24*time.Hour, "In daemon mode, the maximum time an instance is skipped after consecutive failed updates.")
+	flagEMF             = flag.Bool("emf", false, "Write the results of each run to stdout as CloudWatch Embedded Metric Format log lines.")
+	flagEMFNamespace    = flag.String("emf-namespace", updater.DefaultEMFNamespace, "The CloudWatch namespace of the metrics written with -emf.")
+	flagEventBus        = flag.String("event-bus", "", "Optional name or ARN of an EventBridge event bus to which instance state transitions are published.")
+	flagSNSTopic        = flag.String("notify-sns-topic", "", "Optional ARN of an SNS topic to which run summaries and fatal errors are published.")
+	flagSNSTemplate     = flag.String("notify-sns-template", "", "Optional Go template for SNS notification messages, executed over the notification data.")
+	flagSNSFilter       = flag.String("notify-sns-filter", "all", "Which notifications are published to SNS: all, failures or summaries.")
+	flagWebhookURL      = flag.String("notify-webhook-url", "", "Optional URL to which run summaries and fatal errors are posted.")
+	flagWebhookTemplate = flag.String("notify-webhook-template", "", "Optional Go template for webhook request bodies, executed over the notification data; the data is posted as JSON by default.")
+	flagWebhookFilter   = flag.String("notify-webhook-filter", "all", "Which notifications are posted to the webhook: all, failures or summaries.")
+	flagHTTP            = flag.String("http-address", "", "Optional address (e.g. :8080) on which to serve the HTTP status, control and metrics endpoints.")
+	flagOTLPEndpoint    = flag.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "Optional OTLP/HTTP endpoint (e.g. http://localhost:4318) to which OpenTelemetry traces of each run are exported; defaults to $OTEL_EXPORTER_OTLP_ENDPOINT.")
 )
 
-type updater struct {
-	cluster        string
-	checkDocument  string
-	applyDocument  string
-	rebootDocument string
-	ecs            ECSAPI
-	ssm            SSMAPI
-	ec2            EC2API
+// Exit statuses of a run that ended without an error but did not roll out. A malformed command
+// line exits with 2 and any other error with 1.
+const (
+	// exitPaused is the exit status of a run paused by the kill switch or remote configuration.
+	exitPaused = 3
+	// exitAwaitingApproval is the exit status of a run whose plan expired before it was approved.
+	exitAwaitingApproval = 4
+)
+
+// exitError is an error ending the updater with a specific exit status.
+type exitError struct {
+	status int
+	err    error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
 }
 
 func main() {
 	if err := _main(); err != nil {
 		log.Println(err.Error())
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.status)
+		}
 		os.Exit(1)
 	}
 }
 
 func _main() error {
-	flag.Parse()
-	switch {
-	case *flagCluster == "":
-		flag.Usage()
-		return errors.New("cluster is required")
-	case *flagRegion == "":
-		flag.Usage()
-		return errors.New("region is required")
-	case *flagCheck == "":
-		flag.Usage()
-		return errors.New("check-document is required")
-	case *flagApply == "":
-		flag.Usage()
-		return errors.New("apply-document is required")
-	case *flagReboot == "":
-		flag.Usage()
-		return errors.New("reboot-document is required")
-	}
-
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(*flagRegion),
-	}))
-
-	u := &updater{
-		cluster:        *flagCluster,
-		checkDocument:  *flagCheck,
-		applyDocument:  *flagApply,
-		rebootDocument: *flagReboot,
-		ecs:            ecs.New(sess, aws.NewConfig()),
-		ssm:            ssm.New(sess, aws.NewConfig()),
-		ec2:            ec2.New(sess, aws.NewConfig()),
-	}
-
-	listedInstances, err := u.listContainerInstances()
+	flag.Usage = usage
+	command, args, err := parseCommand(os.Args[1:])
 	if err != nil {
-		return fmt.Errorf("Failed to get container instances in cluster %q: %w", u.cluster, err)
-	}
-	if len(listedInstances) == 0 {
-		log.Print("Zero instances in the cluster")
-		return nil
+		return err
 	}
-
-	bottlerocketInstances, err := u.filterBottlerocketInstances(listedInstances)
-	if err != nil {
-		return fmt.Errorf("Failed to filter Bottlerocket instances: %w", err)
+	// flag.CommandLine exits on a malformed command line.
+	_ = flag.CommandLine.Parse(args)
+	errs := loadConfig(flag.CommandLine, os.Getenv)
+	opts, optsErrs := options(command)
+	errs = append(errs, optsErrs...)
+	u, err := updater.New(opts)
+	var configErr *updater.ConfigError
+	if errors.As(err, &configErr) {
+		errs = append(errs, configErr.Problems...)
+	} else if err != nil {
+		errs = append(errs, err)
 	}
-
-	if len(bottlerocketInstances) == 0 {
-		log.Printf("No Bottlerocket instances detected")
+	if command == commandValidate {
+		if len(errs) != 0 {
+			return &updater.ConfigError{Problems: errs}
+		}
+		log.Printf("Configuration is valid")
 		return nil
 	}
-	candidates, err := u.filterAvailableUpdates(bottlerocketInstances)
-	if err != nil {
-		return fmt.Errorf("Failed to check updates: %w", err)
-	}
-	if len(candidates) == 0 {
-		log.Printf("No instances to update")
-		return nil
+	if len(errs) != 0 {
+		flag.Usage()
+		return &updater.ConfigError{Problems: errs}
 	}
-	log.Printf("Instances ready for update: %#q", candidates)
 
-	for _, i := range candidates {
-		eligible, err := u.eligible(i.containerInstanceID)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, shutting down after the current instance", sig)
+		cancel()
+	}()
+	go u.Serve(ctx)
+
+	instances := updater.SplitList(*flagInstance)
+	switch command {
+	case commandPlan:
+		plan, err := u.Plan()
 		if err != nil {
-			log.Printf("Failed to determine eligibility for update of instance %#q: %v", i, err)
-			continue
+			return err
 		}
-		if !eligible {
-			log.Printf("Instance %#q is not eligible for updates because it contains non-service task", i)
-			continue
+		if err := updater.WritePlan(plan, *flagPlan, os.Stdout); err != nil {
+			return fmt.Errorf("failed to write plan: %w", err)
 		}
-		log.Printf("Instance %q is eligible for update", i)
-
-		err = u.drainInstance(i.containerInstanceID)
+		if *flagPlan != "" {
+			log.Printf("Plan written to %s", *flagPlan)
+		}
+		return nil
+	case commandReport:
+		inv, err := u.Inventory(*flagReportThreshold)
 		if err != nil {
-			log.Printf("Failed to drain instance %#q: %v", i, err)
-			continue
+			return err
 		}
-		log.Printf("Instance %#q successfully drained!", i)
-
-		updateErr := u.updateInstance(i)
-		activateErr := u.activateInstance(i.containerInstanceID)
-		if updateErr != nil && activateErr != nil {
-			log.Printf("Failed to update instance %#q: %v", i, updateErr)
-			return fmt.Errorf("instance %#q failed to re-activate after failing to update: %w", i, activateErr)
-		} else if updateErr != nil {
-			log.Printf("Failed to update instance %#q: %v", i, updateErr)
-			continue
-		} else if activateErr != nil {
-			return fmt.Errorf("instance %#q failed to re-activate after update: %w", i, activateErr)
+		if err := updater.WriteInventory(inv, *flagReportFormat, *flagReport, os.Stdout); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
 		}
+		log.Printf("%d of %d instances flagged as more than %d releases behind or not checked",
+			inv.Summary.Flagged, inv.Summary.Instances, inv.Threshold)
+		if *flagReport != "" {
+			log.Printf("Report written to %s", *flagReport)
+		}
+		return nil
+	case commandStatus:
+		return u.Status(os.Stdout, instances)
+	case commandCheck:
+		return u.Check(os.Stdout, instances)
+	case commandDrain:
+		return u.Drain(ctx, instances)
+	case commandReactivate:
+		return u.Reactivate(instances)
+	}
 
-		// Reboots are not immediate, and initiating an SSM command races with reboot. Add some
-		// sleep time to allow the reboot to progress before we verify update.
-		time.Sleep(20 * time.Second)
-		ok, err := u.verifyUpdate(i)
+	if *flagDaemon {
+		return u.RunDaemon(ctx)
+	}
+	result, err := u.Run(ctx)
+	if err != nil {
+		return err
+	}
+	// A run awaiting approval or paused is not an error of the updater, but it must not look like
+	// a completed rollout to the scheduler running it.
+	if ids := result.AwaitingApproval(); len(ids) != 0 {
+		return &exitError{status: exitAwaitingApproval, err: fmt.Errorf("rollout awaiting approval of plans %s", strings.Join(ids, ", "))}
+	}
+	if result.Paused() {
+		return &exitError{status: exitPaused, err: errors.New("rollout paused")}
+	}
+	return nil
+}
+
+// options returns the updater options for the command given with the flags, and every problem
+// found with the flags that the updater does not check itself.
+func options(command string) (updater.Options, []error) {
+	errs := make([]error, 0)
+	check := func(err error) {
 		if err != nil {
-			log.Printf("Failed to verify update for instance %#q: %v", i, err)
+			errs = append(errs, err)
 		}
-		if !ok {
-			log.Printf("Update failed for instance %#q", i)
+	}
+	if *flagDaemon && command != commandRun {
+		check(fmt.Errorf("daemon cannot be used with %s", command))
+	}
+	instances := updater.SplitList(*flagInstance)
+	switch {
+	case len(instances) != 0 && command != commandStatus && command != commandCheck && !targetsInstances(command):
+		check(fmt.Errorf("instance cannot be used with %s", command))
+	case *flagAll && command != commandReactivate:
+		check(fmt.Errorf("all cannot be used with %s", command))
+	case command == commandReactivate && len(instances) == 0 && !*flagAll:
+		check(errors.New("instance or all is required with reactivate"))
+	case command == commandReactivate && len(instances) != 0 && *flagAll:
+		check(errors.New("instance and all cannot be used together"))
+	case (command == commandDrain || command == commandUpdate) && len(instances) == 0:
+		check(fmt.Errorf("instance is required with %s", command))
+	}
+	// The updater checks that the instances to update are named in a single cluster.
+	if (command == commandDrain || command == commandReactivate) && !singleCluster() {
+		check(fmt.Errorf("%s acts on a single cluster, given with cluster or targets", command))
+	}
+	if *flagDaemon && *flagInterval <= 0 {
+		check(errors.New("interval must be positive"))
+	}
+	_, err := updater.ParseReportFormat(*flagReportFormat)
+	check(err)
+	if *flagReportThreshold < 0 {
+		check(errors.New("report-threshold must not be negative"))
+	}
+
+	opts := updater.Options{
+		Region:              *flagRegion,
+		Clusters:            updater.SplitList(*flagCluster),
+		ClusterPattern:      *flagClusterPattern,
+		ClusterTag:          *flagClusterTag,
+		Targets:             *flagTargets,
+		ParallelClusters:    *flagParallel,
+		CheckDocument:       *flagCheck,
+		ApplyDocument:       *flagApply,
+		RebootDocument:      *flagReboot,
+		VariantDocuments:    *flagVariantDocs,
+		Alarms:              updater.SplitList(*flagAlarms),
+		FailureBudget:       *flagBudget,
+		QuarantineAfter:     *flagQuarantine,
+		OptOutAttribute:     *flagOptOutAttr,
+		OptOutTag:           *flagOptOutTag,
+		Include:             *flagInclude,
+		Exclude:             *flagExclude,
+		MaintenanceWindows:  *flagWindows,
+		MaintenanceTimezone: *flagZone,
+		BlackoutDates:       *flagBlackout,
+		Interval:            *flagInterval,
+		Jitter:              *flagJitter,
+		EMFNamespace:        *flagEMFNamespace,
+		EventBus:            *flagEventBus,
+		SNSTopic:            *flagSNSTopic,
+		SNSTemplate:         *flagSNSTemplate,
+		SNSFilter:           *flagSNSFilter,
+		WebhookURL:          *flagWebhookURL,
+		WebhookTemplate:     *flagWebhookTemplate,
+		WebhookFilter:       *flagWebhookFilter,
+		HTTPAddress:         *flagHTTP,
+		OTLPEndpoint:        *flagOTLPEndpoint,
+		RemoteConfigPath:    *flagRemoteConfig,
+		KillSwitch:          *flagKillSwitch,
+		Approval:            *flagApproval,
+		ApprovalTimeout:     *flagApprovalTimeout,
+		ApprovalSecret:      *flagApprovalSecret,
+	}
+	if *flagEMF {
+		opts.EMF = os.Stdout
+	}
+	// Failed instances are only skipped by later cycles of the daemon.
+	if *flagDaemon {
+		opts.Backoff = *flagBackoff
+		opts.MaxBackoff = *flagMaxBackoff
+	}
+	if command == commandUpdate {
+		opts.Instances = instances
+	}
+	if command == commandApply {
+		if *flagPlan == "" {
+			check(errors.New("plan is required with apply"))
 		} else {
-			log.Printf("Instance %#q updated successfully!", i)
+			plan, err := updater.ReadPlan(*flagPlan)
+			check(err)
+			opts.Plan = plan
 		}
 	}
-	return nil
+	return opts, errs
+}
+
+// singleCluster reports whether the flags name exactly one cluster, without discovering others.
+func singleCluster() bool {
+	n := len(updater.SplitList(*flagCluster))
+	for _, t := range strings.Split(*flagTargets, ";") {
+		if strings.TrimSpace(t) != "" {
+			n++
+		}
+	}
+	return n == 1 && *flagClusterPattern == "" && *flagClusterTag == ""
 }
@@ -0,0 +1,297 @@
+package updater
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// approvalInterval is how often the approval sources are checked while a plan awaits approval.
+const approvalInterval = 30 * time.Second
+
+// approvalSignatureHeader holds the signature of an HTTP approval: the hex-encoded HMAC-SHA256
+// of the plan ID, keyed with the approval secret.
+const approvalSignatureHeader = "X-Approval-Signature"
+
+var (
+	errApprovalDisabled  = errors.New("HTTP approval is not enabled")
+	errApprovalSignature = errors.New("invalid approval signature")
+	errApprovalUnknown   = errors.New("no such plan is awaiting approval")
+)
+
+// approvalPlan is the set of instances a run intends to update. Its ID is derived from the
+// cluster, the instances and the time the plan was made, so that an approval only applies to the
+// run that asked for it and each run needs its own.
+type approvalPlan struct {
+	ID        string           `json:"id"`
+	Cluster   string           `json:"cluster"`
+	Created   time.Time        `json:"created"`
+	Expires   time.Time        `json:"expires"`
+	Instances []instanceStatus `json:"instances"`
+}
+
+func newApprovalPlan(cluster string, candidates []instance, now time.Time, timeout time.Duration) approvalPlan {
+	plan := approvalPlan{
+		Cluster:   cluster,
+		Created:   now,
+		Expires:   now.Add(timeout),
+		Instances: make([]instanceStatus, 0, len(candidates)),
+	}
+	h := sha256.New()
+	fmt.Fprintln(h, cluster, now.UnixNano())
+	for _, inst := range candidates {
+		fmt.Fprintln(h, inst.instanceID, inst.containerInstanceID, inst.bottlerocketVersion, inst.targetVersion)
+		plan.Instances = append(plan.Instances, newInstanceStatus(inst))
+	}
+	plan.ID = hex.EncodeToString(h.Sum(nil))[:12]
+	return plan
+}
+
+// approvalSource is a place an operator approves a plan by writing its ID: an SSM parameter, a
+// file, or an HTTP callback to the status server.
+type approvalSource struct {
+	kind string
+	name string
+}
+
+func (s approvalSource) String() string {
+	if s.name == "" {
+		return s.kind
+	}
+	return s.kind + ":" + s.name
+}
+
+// parseApprovalSources parses a comma-separated list of approval sources, such as
+// "ssm:/bottlerocket-ecs-updater/approved,http".
+func parseApprovalSources(value string) ([]approvalSource, error) {
+	sources := make([]approvalSource, 0)
+	for _, entry := range SplitList(value) {
+		if entry == "http" {
+			sources = append(sources, approvalSource{kind: "http"})
+			continue
+		}
+		colon := strings.Index(entry, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("invalid approval source %q, expected ssm:<parameter>, file:<path> or http", entry)
+		}
+		s := approvalSource{kind: entry[:colon], name: strings.TrimSpace(entry[colon+1:])}
+		switch {
+		case s.kind != "ssm" && s.kind != "file":
+			return nil, fmt.Errorf("invalid approval source %q, expected ssm:<parameter>, file:<path> or http", entry)
+		case s.name == "":
+			return nil, fmt.Errorf("invalid approval source %q: missing name", entry)
+		}
+		sources = append(sources, s)
+	}
+	return sources, nil
+}
+
+// approvalGate holds each run's plan until an operator approves it, or until it expires. It is
+// shared by the updaters of every cluster. A nil approvalGate approves every plan immediately.
+type approvalGate struct {
+	sources []approvalSource
+	ssm     SSMAPI
+	// secret is the key HTTP approvals are signed with.
+	secret   []byte
+	timeout  time.Duration
+	interval time.Duration
+
+	mu       sync.Mutex
+	pending  map[string]approvalPlan
+	approved map[string]bool
+}
+
+func newApprovalGate(sources []approvalSource, ssmClient SSMAPI, secret string, timeout time.Duration) *approvalGate {
+	if len(sources) == 0 {
+		return nil
+	}
+	return &approvalGate{
+		sources:  sources,
+		ssm:      ssmClient,
+		secret:   []byte(secret),
+		timeout:  timeout,
+		interval: approvalInterval,
+		pending:  make(map[string]approvalPlan),
+		approved: make(map[string]bool),
+	}
+}
+
+// wait publishes the plan as pending and blocks until it is approved, it expires or ctx is
+// cancelled. It returns whether the plan was approved, and by which source.
+func (g *approvalGate) wait(ctx aws.Context, plan approvalPlan) (bool, string) {
+	if g == nil {
+		return true, ""
+	}
+	g.mu.Lock()
+	g.pending[plan.ID] = plan
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		delete(g.pending, plan.ID)
+		delete(g.approved, plan.ID)
+	}()
+
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	expired := time.NewTimer(time.Until(plan.Expires))
+	defer expired.Stop()
+	for {
+		if ok, by := g.approvedBy(plan.ID); ok {
+			return true, by
+		}
+		select {
+		case <-ctx.Done():
+			return false, ""
+		case <-expired.C:
+			return false, ""
+		case <-ticker.C:
+		}
+	}
+}
+
+// approvedBy reports whether the plan was approved, and by which source. Sources that cannot be
+// read are logged and ignored.
+func (g *approvalGate) approvedBy(id string) (bool, string) {
+	for _, s := range g.sources {
+		var ids []string
+		switch s.kind {
+		case "http":
+			g.mu.Lock()
+			ok := g.approved[id]
+			g.mu.Unlock()
+			if ok {
+				return true, s.String()
+			}
+			continue
+		case "ssm":
+			resp, err := g.ssm.GetParameter(&ssm.GetParameterInput{
+				Name:           aws.String(s.name),
+				WithDecryption: aws.Bool(true),
+			})
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+				continue
+			}
+			if err != nil {
+				log.Printf("Failed to read approval source %s: %v", s, err)
+				continue
+			}
+			ids = approvalIDs(aws.StringValue(resp.Parameter.Value))
+		case "file":
+			data, err := ioutil.ReadFile(s.name)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				log.Printf("Failed to read approval source %s: %v", s, err)
+				continue
+			}
+			ids = approvalIDs(string(data))
+		}
+		for _, approved := range ids {
+			if approved == id {
+				return true, s.String()
+			}
+		}
+	}
+	return false, ""
+}
+
+// approvalIDs splits an approval source's value into plan IDs separated by commas or whitespace.
+func approvalIDs(value string) []string {
+	return strings.Fields(strings.Replace(value, ",", " ", -1))
+}
+
+// approve records an approval received over HTTP after checking its signature.
+func (g *approvalGate) approve(id, signature string) error {
+	if g == nil || !g.acceptsHTTP() {
+		return errApprovalDisabled
+	}
+	if !validSignature(g.secret, id, signature) {
+		return errApprovalSignature
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.pending[id]; !ok {
+		return errApprovalUnknown
+	}
+	g.approved[id] = true
+	return nil
+}
+
+// validSignature reports whether signature is the hex-encoded HMAC-SHA256 of message keyed with
+// secret.
+func validSignature(secret []byte, message, signature string) bool {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(message))
+	given, err := hex.DecodeString(signature)
+	return err == nil && hmac.Equal(given, mac.Sum(nil))
+}
+
+func (g *approvalGate) acceptsHTTP() bool {
+	for _, s := range g.sources {
+		if s.kind == "http" {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingPlans returns the plans awaiting approval, oldest first.
+func (g *approvalGate) pendingPlans() []approvalPlan {
+	plans := make([]approvalPlan, 0)
+	if g == nil {
+		return plans
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, plan := range g.pending {
+		plans = append(plans, plan)
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].Created.Before(plans[j].Created) })
+	return plans
+}
+
+// awaitApproval holds the run's candidates until the plan is approved. It returns false, having
+// recorded why in the report, if the run must end without updating anything.
+func (u *clusterUpdater) awaitApproval(ctx context.Context, report *runReport, candidates []instance) bool {
+	if u.approval == nil {
+		return true
+	}
+	plan := newApprovalPlan(u.cluster, candidates, time.Now(), u.approval.timeout)
+	log.Printf("Plan %s for cluster %q awaits approval until %s: %d instances", plan.ID, u.cluster,
+		plan.Expires.Format(time.RFC3339), len(plan.Instances))
+	for _, inst := range plan.Instances {
+		log.Printf("Plan %s: instance %q from version %s to %s", plan.ID, inst.InstanceID, inst.BottlerocketVersion, inst.TargetVersion)
+	}
+	u.status.setActivity(u.cluster, activityApproval)
+	u.notifier.approval(report, plan)
+
+	approved, by := u.approval.wait(ctx, plan)
+	switch {
+	case approved:
+		log.Printf("Plan %s approved by %s", plan.ID, by)
+		return true
+	case ctx.Err() != nil:
+		log.Printf("Shutting down while plan %s awaits approval", plan.ID)
+		report.halt("updater shutting down")
+	default:
+		log.Printf("Plan %s was not approved before %s", plan.ID, plan.Expires.Format(time.RFC3339))
+		report.awaitApproval(plan.ID)
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package updater
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPlanCandidates() []instance {
+	return []instance{
+		{instanceID: "inst-id-1", containerInstanceID: "cont-inst-1", bottlerocketVersion: "1.0.5", targetVersion: "1.0.6"},
+		{instanceID: "inst-id-2", containerInstanceID: "cont-inst-2", bottlerocketVersion: "1.0.5", targetVersion: "1.0.6"},
+	}
+}
+
+func sign(secret, id string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseApprovalSources(t *testing.T) {
+	sources, err := parseApprovalSources("ssm:/updater/approved, file:/run/approved,http")
+	require.NoError(t, err)
+	assert.Equal(t, []approvalSource{
+		{kind: "ssm", name: "/updater/approved"},
+		{kind: "file", name: "/run/approved"},
+		{kind: "http"},
+	}, sources)
+
+	sources, err = parseApprovalSources("")
+	require.NoError(t, err)
+	assert.Nil(t, newApprovalGate(sources, MockSSM{}, "", time.Hour))
+
+	for _, value := range []string{"approved", "s3:bucket", "ssm:", "file: ", "http:/approve"} {
+		_, err := parseApprovalSources(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestApprovalPlanID(t *testing.T) {
+	now := time.Now()
+	plan := newApprovalPlan("test-cluster", testPlanCandidates(), now, time.Hour)
+	assert.Len(t, plan.ID, 12)
+	assert.Equal(t, now.Add(time.Hour), plan.Expires)
+	assert.Equal(t, "1.0.6", plan.Instances[0].TargetVersion)
+
+	assert.Equal(t, plan.ID, newApprovalPlan("test-cluster", testPlanCandidates(), now, time.Hour).ID)
+	later := newApprovalPlan("test-cluster", testPlanCandidates(), now.Add(time.Minute), time.Hour)
+	assert.NotEqual(t, plan.ID, later.ID, "each run's plan has its own ID")
+
+	changed := testPlanCandidates()
+	changed[1].targetVersion = "1.0.7"
+	assert.NotEqual(t, plan.ID, newApprovalPlan("test-cluster", changed, now, time.Hour).ID)
+	assert.NotEqual(t, plan.ID, newApprovalPlan("other-cluster", testPlanCandidates(), now, time.Hour).ID)
+}
+
+func TestApprovalSources(t *testing.T) {
+	plan := newApprovalPlan("test-cluster", testPlanCandidates(), time.Now(), time.Hour)
+	var parameter string
+	var fail bool
+	mockSSM := MockSSM{
+		GetParameterFn: func(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+			assert.Equal(t, "/updater/approved", aws.StringValue(input.Name))
+			switch {
+			case fail:
+				return nil, errors.New("throttled")
+			case parameter == "":
+				return nil, awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil)
+			}
+			return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(parameter)}}, nil
+		},
+	}
+	file := filepath.Join(t.TempDir(), "approved")
+	g := newApprovalGate([]approvalSource{{kind: "ssm", name: "/updater/approved"}, {kind: "file", name: file}},
+		mockSSM, "", time.Hour)
+
+	approved, _ := g.approvedBy(plan.ID)
+	assert.False(t, approved, "missing sources approve nothing")
+
+	parameter = "0123456789ab, " + plan.ID
+	approved, by := g.approvedBy(plan.ID)
+	assert.True(t, approved)
+	assert.Equal(t, "ssm:/updater/approved", by)
+
+	parameter = "0123456789ab"
+	approved, _ = g.approvedBy(plan.ID)
+	assert.False(t, approved)
+
+	fail = true
+	require.NoError(t, ioutil.WriteFile(file, []byte(plan.ID+"\n"), 0600))
+	approved, by = g.approvedBy(plan.ID)
+	assert.True(t, approved, "a source that cannot be read should not hide the others")
+	assert.Equal(t, "file:"+file, by)
+}
+
+func TestApprovalWait(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "approved")
+	g := newApprovalGate([]approvalSource{{kind: "file", name: file}}, MockSSM{}, "", time.Hour)
+	g.interval = time.Millisecond
+
+	plan := newApprovalPlan("test-cluster", testPlanCandidates(), time.Now(), time.Hour)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ioutil.WriteFile(file, []byte(plan.ID), 0600)
+	}()
+	approved, by := g.wait(context.Background(), plan)
+	assert.True(t, approved)
+	assert.Equal(t, "file:"+file, by)
+	assert.Empty(t, g.pendingPlans(), "an approved plan is no longer pending")
+
+	expired := newApprovalPlan("other-cluster", testPlanCandidates(), time.Now(), 10*time.Millisecond)
+	approved, _ = g.wait(context.Background(), expired)
+	assert.False(t, approved)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	approved, _ = g.wait(ctx, newApprovalPlan("third-cluster", testPlanCandidates(), time.Now(), time.Hour))
+	assert.False(t, approved)
+
+	var nilGate *approvalGate
+	approved, _ = nilGate.wait(context.Background(), plan)
+	assert.True(t, approved, "without a gate every plan is approved")
+}
+
+func TestApprovalPerRun(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "approved")
+	g := newApprovalGate([]approvalSource{{kind: "file", name: file}}, MockSSM{}, "", time.Hour)
+	g.interval = time.Millisecond
+
+	first := newApprovalPlan("test-cluster", testPlanCandidates(), time.Now(), time.Hour)
+	require.NoError(t, ioutil.WriteFile(file, []byte(first.ID), 0600))
+	approved, _ := g.wait(context.Background(), first)
+	require.True(t, approved)
+
+	// The next run plans the same instances, and the approval of the first run is still there.
+	second := newApprovalPlan("test-cluster", testPlanCandidates(), time.Now().Add(time.Millisecond), 20*time.Millisecond)
+	approved, _ = g.wait(context.Background(), second)
+	assert.False(t, approved, "a second run needs a fresh approval")
+}
+
+func TestApprovalHTTP(t *testing.T) {
+	g := newApprovalGate([]approvalSource{{kind: "http"}}, MockSSM{}, "secret", time.Hour)
+	g.interval = time.Millisecond
+	handler := newStatusHandler(newStatus(), newMetrics(), g, "secret")
+	approve := func(id, signature string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/approve?plan="+id, nil)
+		req.Header.Set(approvalSignatureHeader, signature)
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	plan := newApprovalPlan("test-cluster", testPlanCandidates(), time.Now(), time.Hour)
+	assert.Equal(t, http.StatusNotFound, approve(plan.ID, sign("secret", plan.ID)), "the plan is not pending yet")
+
+	result := make(chan bool)
+	go func() {
+		approved, _ := g.wait(context.Background(), plan)
+		result <- approved
+	}()
+	require.Eventually(t, func() bool { return len(g.pendingPlans()) == 1 }, time.Second, time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/approvals", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	pending := []approvalPlan{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pending))
+	require.Len(t, pending, 1)
+	assert.Equal(t, plan.ID, pending[0].ID)
+
+	assert.Equal(t, http.StatusForbidden, approve(plan.ID, sign("guess", plan.ID)))
+	assert.Equal(t, http.StatusForbidden, approve(plan.ID, "not hex"))
+	assert.Equal(t, http.StatusOK, approve(plan.ID, sign("secret", plan.ID)))
+	select {
+	case approved := <-result:
+		assert.True(t, approved)
+	case <-time.After(time.Second):
+		t.Fatal("plan was not approved over HTTP")
+	}
+	assert.Empty(t, g.approved, "an approval is dropped once the run that waited for it goes on")
+	assert.Equal(t, http.StatusNotFound, approve(plan.ID, sign("secret", plan.ID)), "the plan is no longer pending")
+
+	disabled := newStatusHandler(newStatus(), newMetrics(), nil, "")
+	rec = httptest.NewRecorder()
+	disabled.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/approve?plan="+plan.ID, nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAwaitApproval(t *testing.T) {
+	published := []*sns.PublishInput{}
+	target, err := newNotificationTarget(&snsBackend{sns: MockSNS{
+		PublishFn: func(input *sns.PublishInput) (*sns.PublishOutput, error) {
+			published = append(published, input)
+			return &sns.PublishOutput{}, nil
+		},
+	}, topicARN: "topic-arn"}, "", filterFailures)
+	require.NoError(t, err)
+	u := &clusterUpdater{
+		cluster:  "test-cluster",
+		notifier: &notifier{cluster: "test-cluster", targets: []*notificationTarget{target}},
+		approval: newApprovalGate([]approvalSource{{kind: "http"}}, MockSSM{}, "secret", 10*time.Millisecond),
+	}
+
+	report := newRunReport("test-cluster")
+	assert.False(t, u.awaitApproval(context.Background(), report, testPlanCandidates()))
+	id := report.summary().AwaitingApproval
+	assert.Len(t, id, 12)
+	assert.Equal(t, "plan "+id+" awaiting approval", report.haltReason)
+	assert.False(t, report.paused)
+
+	require.Len(t, published, 1, "the plan is published before waiting")
+	assert.Equal(t, "Bottlerocket ECS updater approval for cluster test-cluster", aws.StringValue(published[0].Subject))
+	assert.Contains(t, aws.StringValue(published[0].Message), "Bottlerocket ECS updater plan "+id+" for cluster test-cluster awaits approval until ")
+	assert.Contains(t, aws.StringValue(published[0].Message), "\n- inst-id-2: 1.0.5 to 1.0.6\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report = newRunReport("test-cluster")
+	assert.False(t, u.awaitApproval(ctx, report, testPlanCandidates()))
+	assert.Equal(t, "updater shutting down", report.haltReason)
+	assert.Empty(t, report.summary().AwaitingApproval)
+
+	u.approval = nil
+	assert.True(t, u.awaitApproval(context.Background(), newRunReport("test-cluster"), testPlanCandidates()))
+}
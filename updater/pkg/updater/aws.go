@@ -0,0 +1,721 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+const (
+	pageSize             = 50
+	updateStateIdle      = "Idle"
+	updateStateStaged    = "Staged"
+	updateStateAvailable = "Available"
+	updateStateReady     = "Ready"
+	waiterDelay          = time.Duration(15) * time.Second
+	waiterMaxAttempts    = 100
+	// If this time is reached and the ssm command has not already started running, it will not run.
+	deliveryTimeoutSeconds = 600
+	// maxStateUpdateInstances is the maximum number of container instances accepted by a single
+	// UpdateContainerInstancesState call.
+	maxStateUpdateInstances = 10
+	// maxAlarmNames is the maximum number of alarm names accepted by a single DescribeAlarms call.
+	maxAlarmNames = 100
+)
+
+type instance struct {
+	instanceID          string
+	containerInstanceID string
+	bottlerocketVersion string
+	// targetVersion is the version the instance will be updated to, as reported by the update check.
+	targetVersion string
+	// failureCount is the number of consecutive failed updates recorded on the container instance.
+	failureCount int
+	// quarantinedSince is the value of the quarantine marker on the container instance, if any.
+	quarantinedSince string
+	// attributes holds the ECS attributes of the container instance by name.
+	attributes map[string]string
+	// tags holds the EC2 tags of the instance needed by selectors and opt-out, by key.
+	tags             map[string]string
+	capacityProvider string
+	// variant is the Bottlerocket variant, which selects the SSM documents used for the instance.
+	variant string
+	// status is the status of the container instance, such as ACTIVE or DRAINING.
+	status string
+}
+
+type checkOutput struct {
+	UpdateState     string `json:"update_state"`
+	ActivePartition struct {
+		Image struct {
+			Version string `json:"version"`
+		} `json:"image"`
+	} `json:"active_partition"`
+	ChosenUpdate struct {
+		Version string `json:"version"`
+	} `json:"chosen_update"`
+	// AvailableUpdates lists the versions the instance can be updated to.
+	AvailableUpdates []string `json:"available_updates"`
+}
+
+type ECSAPI interface {
+	ListContainerInstancesWithContext(ctx aws.Context, input *ecs.ListContainerInstancesInput, opts ...request.Option) (*ecs.ListContainerInstancesOutput, error)
+	DescribeContainerInstancesWithContext(ctx aws.Context, input *ecs.DescribeContainerInstancesInput, opts ...request.Option) (*ecs.DescribeContainerInstancesOutput, error)
+	UpdateContainerInstancesStateWithContext(ctx aws.Context, input *ecs.UpdateContainerInstancesStateInput, opts ...request.Option) (*ecs.UpdateContainerInstancesStateOutput, error)
+	ListTasksWithContext(ctx aws.Context, input *ecs.ListTasksInput, opts ...request.Option) (*ecs.ListTasksOutput, error)
+	DescribeTasksWithContext(ctx aws.Context, input *ecs.DescribeTasksInput, opts ...request.Option) (*ecs.DescribeTasksOutput, error)
+	WaitUntilTasksStoppedWithContext(ctx aws.Context, input *ecs.DescribeTasksInput, opts ...request.WaiterOption) error
+	PutAttributesWithContext(ctx aws.Context, input *ecs.PutAttributesInput, opts ...request.Option) (*ecs.PutAttributesOutput, error)
+	DeleteAttributesWithContext(ctx aws.Context, input *ecs.DeleteAttributesInput, opts ...request.Option) (*ecs.DeleteAttributesOutput, error)
+	ListClusters(input *ecs.ListClustersInput) (*ecs.ListClustersOutput, error)
+	DescribeClusters(input *ecs.DescribeClustersInput) (*ecs.DescribeClustersOutput, error)
+	ListAttributes(input *ecs.ListAttributesInput) (*ecs.ListAttributesOutput, error)
+}
+
+type SSMAPI interface {
+	WaitUntilCommandExecutedWithContext(ctx aws.Context, input *ssm.GetCommandInvocationInput, opts ...request.WaiterOption) error
+	SendCommandWithContext(ctx aws.Context, input *ssm.SendCommandInput, opts ...request.Option) (*ssm.SendCommandOutput, error)
+	GetCommandInvocationWithContext(ctx aws.Context, input *ssm.GetCommandInvocationInput, opts ...request.Option) (*ssm.GetCommandInvocationOutput, error)
+	GetParametersByPath(input *ssm.GetParametersByPathInput) (*ssm.GetParametersByPathOutput, error)
+	GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error)
+}
+
+type EC2API interface {
+	WaitUntilInstanceStatusOkWithContext(ctx aws.Context, input *ec2.DescribeInstanceStatusInput, opts ...request.WaiterOption) error
+	DescribeTagsWithContext(ctx aws.Context, input *ec2.DescribeTagsInput, opts ...request.Option) (*ec2.DescribeTagsOutput, error)
+}
+
+type CloudWatchAPI interface {
+	DescribeAlarmsWithContext(ctx aws.Context, input *cloudwatch.DescribeAlarmsInput, opts ...request.Option) (*cloudwatch.DescribeAlarmsOutput, error)
+}
+
+type SNSAPI interface {
+	Publish(input *sns.PublishInput) (*sns.PublishOutput, error)
+}
+
+type EventsAPI interface {
+	PutEventsWithContext(ctx aws.Context, input *eventbridge.PutEventsInput, opts ...request.Option) (*eventbridge.PutEventsOutput, error)
+}
+
+func (u *clusterUpdater) listContainerInstances(ctx aws.Context) ([]*string, error) {
+	arns, err := u.listContainerInstancesWithStatus(ctx, ecs.ContainerInstanceStatusActive)
+	if err != nil {
+		return nil, err
+	}
+	u.metrics.instancesDiscovered(len(arns))
+	return arns, nil
+}
+
+// listContainerInstancesWithStatus returns the container instances in the cluster with the given
+// status, such as ACTIVE or DRAINING.
+func (u *clusterUpdater) listContainerInstancesWithStatus(ctx aws.Context, status string) ([]*string, error) {
+	log.Printf("Listing %s container instances in cluster %q", strings.ToLower(status), u.cluster)
+	resp, err := u.ecs.ListContainerInstancesWithContext(ctx, &ecs.ListContainerInstancesInput{
+		Cluster:    &u.cluster,
+		MaxResults: aws.Int64(pageSize),
+		Status:     aws.String(status),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list container instances: %w", err)
+	}
+	log.Printf("Found %d container instances in the cluster", len(resp.ContainerInstanceArns))
+	return resp.ContainerInstanceArns, nil
+}
+
+// filterBottlerocketInstances filters container instances and returns list of
+// instances that are running Bottlerocket OS and are selected for updates, excluding the
+// instances an operator opted out of updates with the opt-out attribute or tag.
+func (u *clusterUpdater) filterBottlerocketInstances(ctx aws.Context, instances []*string) ([]instance, error) {
+	bottlerocketInstances, err := u.describeBottlerocketInstances(ctx, instances)
+	if err != nil {
+		return nil, err
+	}
+	u.metrics.bottlerocketInstances(len(bottlerocketInstances))
+
+	now := time.Now()
+	selected := make([]instance, 0, len(bottlerocketInstances))
+	for _, inst := range bottlerocketInstances {
+		if !u.manages(inst, now) {
+			continue
+		}
+		selected = append(selected, inst)
+	}
+	return selected, nil
+}
+
+// manages reports whether the instance is selected for updates and not opted out of them,
+// logging why it is not.
+func (u *clusterUpdater) manages(inst instance, now time.Time) bool {
+	if u.optedOut(inst, now) {
+		return false
+	}
+	if ok, reason := u.selection.selects(inst); !ok {
+		log.Printf("Excluding instance %q: %s", inst.instanceID, reason)
+		return false
+	}
+	return true
+}
+
+// describeBottlerocketInstances returns the container instances running Bottlerocket OS, with
+// the attributes and tags needed by selectors and opt-out.
+func (u *clusterUpdater) describeBottlerocketInstances(ctx aws.Context, instances []*string) ([]instance, error) {
+	log.Printf("Filtering container instances running Bottlerocket OS")
+	resp, err := u.ecs.DescribeContainerInstancesWithContext(ctx, &ecs.DescribeContainerInstancesInput{
+		Cluster:            &u.cluster,
+		ContainerInstances: instances,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe container instances: %w", err)
+	}
+
+	bottlerocketInstances := make([]instance, 0)
+	// check the DescribeContainerInstances response and add only Bottlerocket instances to the list
+	for _, containerInstance := range resp.ContainerInstances {
+		if containsAttribute(containerInstance.Attributes, variantAttribute) {
+			attrs := make(map[string]string, len(containerInstance.Attributes))
+			for _, attr := range containerInstance.Attributes {
+				attrs[aws.StringValue(attr.Name)] = aws.StringValue(attr.Value)
+			}
+			bottlerocketInstances = append(bottlerocketInstances, instance{
+				instanceID:          aws.StringValue(containerInstance.Ec2InstanceId),
+				containerInstanceID: aws.StringValue(containerInstance.ContainerInstanceArn),
+				failureCount:        failureCount(containerInstance.Attributes),
+				quarantinedSince:    attrs[attributeQuarantined],
+				attributes:          attrs,
+				capacityProvider:    aws.StringValue(containerInstance.CapacityProviderName),
+				variant:             attrs[variantAttribute],
+				status:              aws.StringValue(containerInstance.Status),
+			})
+			log.Printf("Bottlerocket instance %q detected", aws.StringValue(containerInstance.Ec2InstanceId))
+		}
+	}
+
+	tagKeys := u.selection.tagKeys()
+	if u.optOutTag != "" {
+		tagKeys = append(tagKeys, u.optOutTag)
+	}
+	if len(tagKeys) != 0 && len(bottlerocketInstances) != 0 {
+		ids := make([]string, 0, len(bottlerocketInstances))
+		for _, inst := range bottlerocketInstances {
+			ids = append(ids, inst.instanceID)
+		}
+		tags, err := u.instanceTags(ctx, ids, tagKeys)
+		if err != nil {
+			return nil, err
+		}
+		for i := range bottlerocketInstances {
+			bottlerocketInstances[i].tags = tags[bottlerocketInstances[i].instanceID]
+		}
+	}
+	return bottlerocketInstances, nil
+}
+
+// instanceTags returns the EC2 tags with the given keys of the given instances, by instance ID.
+func (u *clusterUpdater) instanceTags(ctx aws.Context, instanceIDs []string, keys []string) (map[string]map[string]string, error) {
+	tags := make(map[string]map[string]string)
+	input := &ec2.DescribeTagsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("resource-type"), Values: aws.StringSlice([]string{ec2.ResourceTypeInstance})},
+			{Name: aws.String("resource-id"), Values: aws.StringSlice(instanceIDs)},
+			{Name: aws.String("key"), Values: aws.StringSlice(keys)},
+		},
+	}
+	for {
+		resp, err := u.ec2.DescribeTagsWithContext(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe tags: %w", err)
+		}
+		for _, tag := range resp.Tags {
+			id := aws.StringValue(tag.ResourceId)
+			if tags[id] == nil {
+				tags[id] = make(map[string]string)
+			}
+			tags[id][aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		if aws.StringValue(resp.NextToken) == "" {
+			return tags, nil
+		}
+		input.NextToken = resp.NextToken
+	}
+}
+
+// containsAttribute checks if a slice of ECS Attributes struct contains a specified name.
+func containsAttribute(attrs []*ecs.Attribute, searchString string) bool {
+	for _, attr := range attrs {
+		if aws.StringValue(attr.Name) == searchString {
+			return true
+		}
+	}
+	return false
+}
+
+// filterAvailableUpdates returns a list of instances that have updates available. The active
+// version reported by the update check is recorded on every instance in bottlerocketInstances.
+func (u *clusterUpdater) filterAvailableUpdates(ctx aws.Context, bottlerocketInstances []instance) ([]instance, error) {
+	log.Printf("Filtering instances with available updates")
+	outputs, err := u.checkUpdates(ctx, bottlerocketInstances)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]instance, 0)
+	for n, inst := range bottlerocketInstances {
+		commandOutput := outputs[inst.instanceID]
+		output, err := parseCommandOutput(commandOutput)
+		if err != nil {
+			// not a fatal error, we can continue checking other instances.
+			log.Printf("Failed to parse command output %q: %v", string(commandOutput), err)
+			continue
+		}
+		bottlerocketInstances[n].bottlerocketVersion = output.ActivePartition.Image.Version
+		if output.UpdateState == updateStateAvailable || output.UpdateState == updateStateReady {
+			inst.bottlerocketVersion = output.ActivePartition.Image.Version
+			inst.targetVersion = output.ChosenUpdate.Version
+			candidates = append(candidates, inst)
+		}
+	}
+	return candidates, nil
+}
+
+// checkUpdates runs the check document on the instances and returns its output by instance ID.
+func (u *clusterUpdater) checkUpdates(ctx aws.Context, bottlerocketInstances []instance) (map[string][]byte, error) {
+	// group Bottlerocket instances by check document so that a single command is sent to each group
+	documents := make([]string, 0)
+	groups := make(map[string][]string)
+	for _, inst := range bottlerocketInstances {
+		doc := u.documents(inst).check
+		if _, ok := groups[doc]; !ok {
+			documents = append(documents, doc)
+		}
+		groups[doc] = append(groups[doc], inst.instanceID)
+	}
+
+	commandIDs := make(map[string]string)
+	for _, doc := range documents {
+		commandID, err := u.sendCommand(ctx, groups[doc], doc)
+		if err != nil {
+			return nil, err
+		}
+		commandIDs[doc] = commandID
+	}
+
+	outputs := make(map[string][]byte, len(bottlerocketInstances))
+	for _, inst := range bottlerocketInstances {
+		commandOutput, err := u.getCommandResult(ctx, commandIDs[u.documents(inst).check], inst.instanceID)
+		if err != nil {
+			return nil, err
+		}
+		outputs[inst.instanceID] = commandOutput
+	}
+	return outputs, nil
+}
+
+// eligible checks the eligibility of container instance for update. It's eligible
+// if all the running tasks were started by a service.
+func (u *clusterUpdater) eligible(ctx aws.Context, containerInstance string) (bool, error) {
+	ctx, span := u.tracer.start(ctx, "eligible", "ecs.container_instance", containerInstance)
+	defer span.finish()
+	log.Printf("Checking eligiblity for update of container instance %q", containerInstance)
+	list, err := u.ecs.ListTasksWithContext(ctx, &ecs.ListTasksInput{
+		Cluster:           &u.cluster,
+		ContainerInstance: aws.String(containerInstance),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	taskARNs := list.TaskArns
+	if len(list.TaskArns) == 0 {
+		return true, nil
+	}
+
+	desc, err := u.ecs.DescribeTasksWithContext(ctx, &ecs.DescribeTasksInput{
+		Cluster: &u.cluster,
+		Tasks:   taskARNs,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to describe tasks: %w", err)
+	}
+	for _, listResult := range desc.Tasks {
+		startedBy := aws.StringValue(listResult.StartedBy)
+		if !strings.HasPrefix(startedBy, "ecs-svc/") {
+			log.Printf("Container instance %q has a non-service task running: %s", containerInstance, aws.StringValue(listResult.TaskArn))
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (u *clusterUpdater) drainInstance(ctx aws.Context, inst instance) error {
+	ctx, span := u.tracer.start(ctx, "drainInstance", "ecs.container_instance", inst.containerInstanceID)
+	defer span.finish()
+	containerInstance := inst.containerInstanceID
+	log.Printf("Starting drain on container instance %q", containerInstance)
+	start := time.Now()
+	u.events.publish(ctx, EventDrainStarted, inst, "")
+	resp, err := u.ecs.UpdateContainerInstancesStateWithContext(ctx, &ecs.UpdateContainerInstancesStateInput{
+		Cluster:            &u.cluster,
+		ContainerInstances: aws.StringSlice([]string{containerInstance}),
+		Status:             aws.String("DRAINING"),
+	})
+	if err != nil {
+		u.events.publish(ctx, EventDrainFailed, inst, err.Error())
+		return fmt.Errorf("failed to change instance state to DRAINING: %w", err)
+	}
+	if len(resp.Failures) != 0 {
+		log.Printf("There are API failures in draining the container instance %q, therefore attempting to"+
+			" re-activate", containerInstance)
+		u.events.publish(ctx, EventDrainFailed, inst, fmt.Sprintf("failures in API call: %v", resp.Failures))
+		err = u.activateInstance(ctx, inst)
+		if err != nil {
+			log.Printf("Instance failed to re-activate after failing to change state to DRAINING: %v", err)
+		}
+		return fmt.Errorf("failures in API call: %v", resp.Failures)
+	}
+	log.Printf("Container instance state changed to DRAINING")
+	u.markDrained(ctx, inst, start)
+
+	err = u.waitUntilDrained(ctx, containerInstance)
+	if err != nil {
+		log.Printf("Container instance %q failed to drain, therefore attempting to re-activate", containerInstance)
+		u.events.publish(ctx, EventDrainFailed, inst, err.Error())
+		err2 := u.activateInstance(ctx, inst)
+		if err2 != nil {
+			log.Printf("Instance failed to re-activate after failing to wait for drain to complete: %v", err2)
+		}
+		return fmt.Errorf("error while waiting to drain: %w", err)
+	}
+	log.Printf("Container instance %q drained successfully!", containerInstance)
+	u.metrics.drained(time.Since(start))
+	u.events.publish(ctx, EventDrainCompleted, inst, "")
+	return nil
+}
+
+// activateInstance returns the instance to ACTIVE. It is not interrupted by the cancellation of
+// ctx, so that an instance is returned to service when a drain is interrupted.
+func (u *clusterUpdater) activateInstance(ctx aws.Context, inst instance) error {
+	ctx = context.WithoutCancel(ctx)
+	containerInstance := inst.containerInstanceID
+	resp, err := u.ecs.UpdateContainerInstancesStateWithContext(ctx, &ecs.UpdateContainerInstancesStateInput{
+		Cluster:            &u.cluster,
+		ContainerInstances: aws.StringSlice([]string{containerInstance}),
+		Status:             aws.String("ACTIVE"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to change state to ACTIVE: %w", err)
+	}
+	if len(resp.Failures) != 0 {
+		return fmt.Errorf("API failures while activating: %v", resp.Failures)
+	}
+	log.Printf("Container instance %q state changed to ACTIVE successfully!", containerInstance)
+	u.clearDrained(ctx, []string{containerInstance})
+	u.events.publish(ctx, EventReactivated, inst, "")
+	return nil
+}
+
+// ensureActive sets the state of all the given container instances to ACTIVE. It is used to
+// make sure no instance drained by the updater is left out of service when a rollout stops, and
+// is not interrupted by the cancellation of ctx.
+func (u *clusterUpdater) ensureActive(ctx aws.Context, containerInstances []string) error {
+	ctx = context.WithoutCancel(ctx)
+	failed := make([]string, 0)
+	for start := 0; start < len(containerInstances); start += maxStateUpdateInstances {
+		end := start + maxStateUpdateInstances
+		if end > len(containerInstances) {
+			end = len(containerInstances)
+		}
+		batch := containerInstances[start:end]
+		resp, err := u.ecs.UpdateContainerInstancesStateWithContext(ctx, &ecs.UpdateContainerInstancesStateInput{
+			Cluster:            &u.cluster,
+			ContainerInstances: aws.StringSlice(batch),
+			Status:             aws.String("ACTIVE"),
+		})
+		if err != nil {
+			log.Printf("Failed to change state to ACTIVE for container instances %q: %v", batch, err)
+			failed = append(failed, batch...)
+			continue
+		}
+		batchFailed := make(map[string]bool, len(resp.Failures))
+		for _, f := range resp.Failures {
+			log.Printf("Failed to change state to ACTIVE for container instance %q: %s", aws.StringValue(f.Arn), aws.StringValue(f.Reason))
+			failed = append(failed, aws.StringValue(f.Arn))
+			batchFailed[aws.StringValue(f.Arn)] = true
+		}
+		activated := make([]string, 0, len(batch))
+		for _, containerInstance := range batch {
+			if !batchFailed[containerInstance] {
+				activated = append(activated, containerInstance)
+			}
+		}
+		u.clearDrained(ctx, activated)
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("failed to re-activate container instances: %q", failed)
+	}
+	return nil
+}
+
+func (u *clusterUpdater) waitUntilDrained(ctx aws.Context, containerInstance string) error {
+	log.Printf("Waiting for container instance %q to drain", containerInstance)
+	list, err := u.ecs.ListTasksWithContext(ctx, &ecs.ListTasksInput{
+		Cluster:           &u.cluster,
+		ContainerInstance: aws.String(containerInstance),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list tasks: %w", err)
+	}
+	taskARNs := list.TaskArns
+
+	if len(taskARNs) == 0 {
+		log.Printf("No tasks to drain")
+		return nil
+	}
+
+	return u.ecs.WaitUntilTasksStoppedWithContext(ctx, &ecs.DescribeTasksInput{
+		Cluster: &u.cluster,
+		Tasks:   taskARNs,
+	},
+		request.WithWaiterMaxAttempts(waiterMaxAttempts),
+		request.WithWaiterDelay(request.ConstantWaiterDelay(waiterDelay)),
+	)
+}
+
+// updateInstance starts an update process on an instance.
+func (u *clusterUpdater) updateInstance(ctx aws.Context, inst instance) error {
+	ctx, span := u.tracer.start(ctx, "updateInstance", "ec2.instance_id", inst.instanceID)
+	defer span.finish()
+	log.Printf("Starting update on instance %q", inst.instanceID)
+	ec2IDs := []string{inst.instanceID}
+	docs := u.documents(inst)
+	log.Printf("Checking current update state of instance %q", inst.instanceID)
+
+	commandID, err := u.sendCommand(ctx, ec2IDs, docs.check)
+	if err != nil {
+		return fmt.Errorf("failed to send check command: %w", err)
+	}
+	output, err := u.getCommandResult(ctx, commandID, inst.instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to get check command output: %w", err)
+	}
+	check, err := parseCommandOutput(output)
+	if err != nil {
+		return fmt.Errorf("failed to parse command output %q: %w", string(output), err)
+	}
+
+	switch check.UpdateState {
+	case updateStateIdle:
+		log.Printf("No new update available for instance %q", inst.instanceID)
+		return nil
+	case updateStateStaged:
+		return fmt.Errorf("unexpected update state %q; skipping instance", check.UpdateState)
+	case updateStateAvailable:
+		log.Printf("Starting update apply on instance %q", inst.instanceID)
+		_, err := u.sendCommand(ctx, ec2IDs, docs.apply)
+		if err != nil {
+			return fmt.Errorf("failed to send update apply command: %w", err)
+		}
+		u.events.publish(ctx, EventUpdateApplied, inst, "")
+	case updateStateReady:
+		log.Printf("Update is previously applied on instance %q", inst.instanceID)
+		u.events.publish(ctx, EventUpdateApplied, inst, "update was previously applied")
+	default:
+		return fmt.Errorf("unknown update state %q", check.UpdateState)
+	}
+
+	// occasionally instance goes into reboot before reporting command output, therefore
+	// we do not poll for command output. Instead we rely on verifyUpdate to confirm update
+	// success or failure.
+	log.Printf("Sending SSM document %q on instance %q", docs.reboot, inst.instanceID)
+	// SendCommand is directly called here because we do not want to wait on command complete.
+	resp, err := u.ssm.SendCommandWithContext(ctx, &ssm.SendCommandInput{
+		DocumentName:    aws.String(docs.reboot),
+		DocumentVersion: aws.String("$DEFAULT"),
+		InstanceIds:     aws.StringSlice(ec2IDs),
+		TimeoutSeconds:  aws.Int64(deliveryTimeoutSeconds),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send reboot command: %w", err)
+	}
+	rebootStart := time.Now()
+	rebootID := *resp.Command.CommandId
+	log.Printf("SSM document %q posted with command ID %q", docs.reboot, rebootID)
+	u.events.publish(ctx, EventRebootSent, inst, "")
+
+	// added some sleep time for reboot to start before we check instance state
+	if err := aws.SleepWithContext(ctx, 15*time.Second); err != nil {
+		return fmt.Errorf("interrupted while waiting for reboot: %w", err)
+	}
+	err = u.waitUntilOk(ctx, inst.instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to reach Ok status after reboot: %w", err)
+	}
+	u.metrics.rebooted(time.Since(rebootStart))
+	return nil
+}
+
+// verifyUpdate verifies if instance was properly updated
+func (u *clusterUpdater) verifyUpdate(ctx aws.Context, inst instance) (bool, error) {
+	ctx, span := u.tracer.start(ctx, "verifyUpdate", "ec2.instance_id", inst.instanceID)
+	defer span.finish()
+	log.Println("Verifying update by checking there is no new version available to update" +
+		" and validate the active version")
+	ec2IDs := []string{inst.instanceID}
+	updateStatus, err := u.sendCommand(ctx, ec2IDs, u.documents(inst).check)
+	if err != nil {
+		return false, fmt.Errorf("failed to send update check command: %w", err)
+	}
+
+	updateResult, err := u.getCommandResult(ctx, updateStatus, inst.instanceID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get check command output: %w", err)
+	}
+	output, err := parseCommandOutput(updateResult)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse command output %q, manual verification required: %w", string(updateResult), err)
+	}
+	updatedVersion := output.ActivePartition.Image.Version
+	if updatedVersion == inst.bottlerocketVersion {
+		log.Printf("Container instance %q did not update, its current "+
+			"version %s and updated version %s are the same", inst.containerInstanceID, inst.bottlerocketVersion, updatedVersion)
+		u.events.publish(ctx, EventRolledBack, inst, fmt.Sprintf("instance is still running version %s", updatedVersion))
+		return false, nil
+	}
+	verified := inst
+	verified.targetVersion = updatedVersion
+	u.events.publish(ctx, EventVerified, verified, "")
+	if output.UpdateState == updateStateAvailable {
+		log.Printf("Container instance %q was updated to version %q successfully, however another newer version was recently released;"+
+			" Instance will be updated to newer version in next iteration.", inst.containerInstanceID, updatedVersion)
+		return true, nil
+	}
+	log.Printf("Container instance %q updated to version %q", inst.containerInstanceID, updatedVersion)
+	return true, nil
+}
+
+// sendCommand sends the SSM document to the instances and waits for it to complete on each of
+// them, or for ctx to be cancelled.
+func (u *clusterUpdater) sendCommand(ctx aws.Context, instanceIDs []string, ssmDocument string) (string, error) {
+	log.Printf("Sending SSM document %q", ssmDocument)
+	start := time.Now()
+	resp, err := u.ssm.SendCommandWithContext(ctx, &ssm.SendCommandInput{
+		DocumentName:    aws.String(ssmDocument),
+		DocumentVersion: aws.String("$DEFAULT"),
+		InstanceIds:     aws.StringSlice(instanceIDs),
+		TimeoutSeconds:  aws.Int64(deliveryTimeoutSeconds),
+	})
+	if err != nil {
+		return "", fmt.Errorf("send command failed: %w", err)
+	}
+	commandID := *resp.Command.CommandId
+	log.Printf("SSM document %q posted with command id %q", ssmDocument, commandID)
+
+	// Wait for the sent commands to complete.
+	errCount := 0
+	for _, v := range instanceIDs {
+		log.Printf("Waiting for command %q to complete for instance %q", commandID, v)
+		err = u.ssm.WaitUntilCommandExecutedWithContext(ctx, &ssm.GetCommandInvocationInput{
+			CommandId:  &commandID,
+			InstanceId: &v,
+		},
+			request.WithWaiterMaxAttempts(waiterMaxAttempts),
+			request.WithWaiterDelay(request.ConstantWaiterDelay(waiterDelay)))
+		if err != nil {
+			errCount++
+			log.Printf("Error encountered while awaiting document %q execution for instance: %q: %s", ssmDocument, v, err)
+			u.logCommmandOutput(ctx, commandID, v)
+		}
+	}
+	u.metrics.ssmCommand(ssmDocument, time.Since(start))
+	// TODO return a list of instanceIDs which ecnountered no waiter errors.
+	if errCount == len(instanceIDs) {
+		return "", fmt.Errorf("too many failures while awaiting document execution: %w", err)
+	}
+	return commandID, nil
+}
+
+func (u *clusterUpdater) getCommandResult(ctx aws.Context, commandID string, instanceID string) ([]byte, error) {
+	resp, err := u.ssm.GetCommandInvocationWithContext(ctx, &ssm.GetCommandInvocationInput{
+		CommandId:  aws.String(commandID),
+		InstanceId: aws.String(instanceID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve command invocation output: %w", err)
+	}
+	commandResults := []byte(aws.StringValue(resp.StandardOutputContent))
+	return commandResults, nil
+}
+
+// logCommmandOutput logs the ssm command invocation response
+func (u *clusterUpdater) logCommmandOutput(ctx aws.Context, commandID string, instanceID string) {
+	resp, err := u.ssm.GetCommandInvocationWithContext(ctx, &ssm.GetCommandInvocationInput{
+		CommandId:  aws.String(commandID),
+		InstanceId: aws.String(instanceID),
+	})
+	if err != nil {
+		log.Printf("Failed to get invocation output for instance %q: %v", instanceID, err)
+	}
+	log.Printf("Invocation output for instance %q: %#q", instanceID, resp)
+}
+
+// waitUntilOk takes an EC2 ID as a parameter and waits until the specified EC2 instance is in an
+// Ok status, or until ctx is cancelled.
+func (u *clusterUpdater) waitUntilOk(ctx aws.Context, ec2ID string) error {
+	log.Printf("Waiting for instance %q to reach Ok status", ec2ID)
+	return u.ec2.WaitUntilInstanceStatusOkWithContext(ctx, &ec2.DescribeInstanceStatusInput{
+		InstanceIds: []*string{aws.String(ec2ID)},
+	})
+}
+
+// firingAlarms returns the names of the configured CloudWatch alarms that are in ALARM state.
+func (u *clusterUpdater) firingAlarms(ctx aws.Context) ([]string, error) {
+	if len(u.alarms) == 0 {
+		return nil, nil
+	}
+	firing := make([]string, 0)
+	input := &cloudwatch.DescribeAlarmsInput{
+		AlarmNames: aws.StringSlice(u.alarms),
+		AlarmTypes: aws.StringSlice([]string{cloudwatch.AlarmTypeMetricAlarm, cloudwatch.AlarmTypeCompositeAlarm}),
+		StateValue: aws.String(cloudwatch.StateValueAlarm),
+	}
+	for {
+		resp, err := u.cloudwatch.DescribeAlarmsWithContext(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe alarms: %w", err)
+		}
+		for _, alarm := range resp.MetricAlarms {
+			firing = append(firing, aws.StringValue(alarm.AlarmName))
+		}
+		for _, alarm := range resp.CompositeAlarms {
+			firing = append(firing, aws.StringValue(alarm.AlarmName))
+		}
+		if aws.StringValue(resp.NextToken) == "" {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+	return firing, nil
+}
+
+// parseCommandOutput takes raw bytes of ssm command output and converts it into a struct
+func parseCommandOutput(commandOutput []byte) (checkOutput, error) {
+	output := checkOutput{}
+	err := json.Unmarshal(commandOutput, &output)
+	if err != nil {
+		return output, fmt.Errorf("failed to unmarshal json: %w", err)
+	}
+	if output.UpdateState == "" || output.ActivePartition.Image.Version == "" {
+		return output, fmt.Errorf("mandatory fields are not available")
+	}
+	return output, nil
+}
@@ -1,12 +1,15 @@
-package main
+package updater
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ecs"
 	"github.com/aws/aws-sdk-go/service/ssm"
@@ -30,8 +33,8 @@ func TestSendCommandSuccess(t *testing.T) {
 			return nil
 		},
 	}
-	u := updater{ssm: mockSSM}
-	commandID, err := u.sendCommand(instances, "test-doc")
+	u := clusterUpdater{ssm: mockSSM}
+	commandID, err := u.sendCommand(context.Background(), instances, "test-doc")
 	require.NoError(t, err)
 	assert.EqualValues(t, "command-id", commandID)
 	assert.Equal(t, instances, waitInstanceIDs)
@@ -48,8 +51,8 @@ func TestSendCommandErr(t *testing.T) {
 			return nil, sendError
 		},
 	}
-	u := updater{ssm: mockSSM}
-	commandID, err := u.sendCommand(instances, "test-doc")
+	u := clusterUpdater{ssm: mockSSM}
+	commandID, err := u.sendCommand(context.Background(), instances, "test-doc")
 	require.Error(t, err)
 	assert.Equal(t, "", commandID)
 	assert.ErrorIs(t, err, sendError)
@@ -92,8 +95,8 @@ func TestSendCommandWaitErr(t *testing.T) {
 					return &ssm.GetCommandInvocationOutput{}, nil
 				},
 			}
-			u := updater{ssm: mockSSM}
-			commandID, err := u.sendCommand(tc.instances, "test-doc")
+			u := clusterUpdater{ssm: mockSSM}
+			commandID, err := u.sendCommand(context.Background(), tc.instances, "test-doc")
 			require.Error(t, err)
 			assert.ErrorIs(t, err, waitError)
 			assert.Equal(t, "", commandID)
@@ -129,8 +132,8 @@ func TestSendCommandWaitSuccess(t *testing.T) {
 				return &ssm.GetCommandInvocationOutput{}, nil
 			},
 		}
-		u := updater{ssm: mockSSM}
-		commandID, err := u.sendCommand(instances, "test-doc")
+		u := clusterUpdater{ssm: mockSSM}
+		commandID, err := u.sendCommand(context.Background(), instances, "test-doc")
 		require.NoError(t, err)
 		assert.Equal(t, "command-id", commandID)
 		assert.Equal(t, expectedFailInstances, failedInstanceIDs, "should match instances for which wait fail")
@@ -146,8 +149,8 @@ func TestSendCommandWaitSuccess(t *testing.T) {
 				return nil
 			},
 		}
-		u := updater{ssm: mockSSM}
-		commandID, err := u.sendCommand(instances, "test-doc")
+		u := clusterUpdater{ssm: mockSSM}
+		commandID, err := u.sendCommand(context.Background(), instances, "test-doc")
 		require.NoError(t, err)
 		assert.Equal(t, "command-id", commandID)
 		assert.Equal(t, instances, waitInstanceIDs)
@@ -200,8 +203,8 @@ func TestListContainerInstances(t *testing.T) {
 					return tc.listOutput, tc.listError
 				},
 			}
-			u := updater{ecs: mockECS}
-			actual, err := u.listContainerInstances()
+			u := clusterUpdater{ecs: mockECS}
+			actual, err := u.listContainerInstances(aws.BackgroundContext())
 			if tc.expectedOut != nil {
 				assert.EqualValues(t, tc.expectedOut, actual)
 				assert.NoError(t, err)
@@ -222,10 +225,12 @@ func TestFilterBottlerocketInstances(t *testing.T) {
 			ContainerInstanceArn: aws.String("cont-inst-br1"),
 			Ec2InstanceId:        aws.String("ec2-id-br1"),
 		}, {
-			// Bottlerocket with extra attribute
+			// Bottlerocket with extra attributes, including update history
 			Attributes: []*ecs.Attribute{
 				{Name: aws.String("different-attribute")},
 				{Name: aws.String("bottlerocket.variant")},
+				{Name: aws.String("bottlerocket.updater.failure-count"), Value: aws.String("2")},
+				{Name: aws.String("bottlerocket.updater.quarantined"), Value: aws.String("2021-03-04T05:06:07Z")},
 			},
 			ContainerInstanceArn: aws.String("cont-inst-br2"),
 			Ec2InstanceId:        aws.String("ec2-id-br2"),
@@ -246,10 +251,19 @@ func TestFilterBottlerocketInstances(t *testing.T) {
 		{
 			instanceID:          "ec2-id-br1",
 			containerInstanceID: "cont-inst-br1",
+			attributes:          map[string]string{"bottlerocket.variant": ""},
 		},
 		{
 			instanceID:          "ec2-id-br2",
 			containerInstanceID: "cont-inst-br2",
+			failureCount:        2,
+			quarantinedSince:    "2021-03-04T05:06:07Z",
+			attributes: map[string]string{
+				"different-attribute":                "",
+				"bottlerocket.variant":               "",
+				"bottlerocket.updater.failure-count": "2",
+				"bottlerocket.updater.quarantined":   "2021-03-04T05:06:07Z",
+			},
 		},
 	}
 
@@ -258,9 +272,9 @@ func TestFilterBottlerocketInstances(t *testing.T) {
 			return output, nil
 		},
 	}
-	u := updater{ecs: mockECS}
+	u := clusterUpdater{ecs: mockECS}
 
-	actual, err := u.filterBottlerocketInstances([]*string{
+	actual, err := u.filterBottlerocketInstances(aws.BackgroundContext(), []*string{
 		aws.String("ec2-id-br1"),
 		aws.String("ec2-id-br2"),
 		aws.String("ec2-id-not1"),
@@ -270,6 +284,42 @@ func TestFilterBottlerocketInstances(t *testing.T) {
 	assert.EqualValues(t, expected, actual)
 }
 
+func TestInstanceTags(t *testing.T) {
+	calls := 0
+	mockEC2 := MockEC2{
+		DescribeTagsFn: func(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
+			calls++
+			// Every page is requested with the same filters.
+			require.Len(t, input.Filters, 3)
+			assert.Equal(t, "resource-type", aws.StringValue(input.Filters[0].Name))
+			assert.Equal(t, []string{ec2.ResourceTypeInstance}, aws.StringValueSlice(input.Filters[0].Values))
+			assert.Equal(t, "resource-id", aws.StringValue(input.Filters[1].Name))
+			assert.Equal(t, []string{"ec2-id-1", "ec2-id-2"}, aws.StringValueSlice(input.Filters[1].Values))
+			assert.Equal(t, "key", aws.StringValue(input.Filters[2].Name))
+			assert.Equal(t, []string{"updater-skip", "team"}, aws.StringValueSlice(input.Filters[2].Values))
+			if calls == 1 {
+				assert.Nil(t, input.NextToken)
+				return &ec2.DescribeTagsOutput{
+					Tags:      []*ec2.TagDescription{{ResourceId: aws.String("ec2-id-1"), Key: aws.String("updater-skip"), Value: aws.String("true")}},
+					NextToken: aws.String("token"),
+				}, nil
+			}
+			assert.Equal(t, "token", aws.StringValue(input.NextToken))
+			return &ec2.DescribeTagsOutput{
+				Tags: []*ec2.TagDescription{{ResourceId: aws.String("ec2-id-2"), Key: aws.String("updater-skip"), Value: aws.String("false")}},
+			}, nil
+		},
+	}
+	u := clusterUpdater{ec2: mockEC2}
+	tags, err := u.instanceTags(aws.BackgroundContext(), []string{"ec2-id-1", "ec2-id-2"}, []string{"updater-skip", "team"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[string]string{
+		"ec2-id-1": {"updater-skip": "true"},
+		"ec2-id-2": {"updater-skip": "false"},
+	}, tags)
+	assert.Equal(t, 2, calls)
+}
+
 func TestEligible(t *testing.T) {
 	cases := []struct {
 		name        string
@@ -358,8 +408,8 @@ func TestEligible(t *testing.T) {
 					return tc.describeOut, nil
 				},
 			}
-			u := updater{ecs: mockECS, cluster: "test-cluster"}
-			ok, err := u.eligible("cont-inst-id")
+			u := clusterUpdater{ecs: mockECS, cluster: "test-cluster"}
+			ok, err := u.eligible(aws.BackgroundContext(), "cont-inst-id")
 			require.NoError(t, err)
 			assert.Equal(t, ok, tc.expectedOk)
 		})
@@ -376,8 +426,8 @@ func TestEligibleErr(t *testing.T) {
 				return nil, listErr
 			},
 		}
-		u := updater{ecs: mockECS, cluster: "test-cluster"}
-		ok, err := u.eligible("cont-inst-id")
+		u := clusterUpdater{ecs: mockECS, cluster: "test-cluster"}
+		ok, err := u.eligible(aws.BackgroundContext(), "cont-inst-id")
 		require.Error(t, err)
 		assert.ErrorIs(t, err, listErr)
 		assert.False(t, ok)
@@ -403,8 +453,8 @@ func TestEligibleErr(t *testing.T) {
 				return nil, describeErr
 			},
 		}
-		u := updater{ecs: mockECS, cluster: "test-cluster"}
-		ok, err := u.eligible("cont-inst-id")
+		u := clusterUpdater{ecs: mockECS, cluster: "test-cluster"}
+		ok, err := u.eligible(aws.BackgroundContext(), "cont-inst-id")
 		require.Error(t, err)
 		assert.ErrorIs(t, err, describeErr)
 		assert.False(t, ok)
@@ -430,14 +480,35 @@ func TestDrainInstance(t *testing.T) {
 			},
 		}, nil
 	}
+	// markerCalls records the changes to the drain marker: "mark <time>" or "clear".
+	markerCalls := []string{}
+	mockMark := func(input *ecs.PutAttributesInput) (*ecs.PutAttributesOutput, error) {
+		require.Len(t, input.Attributes, 1)
+		assert.Equal(t, attributeDrained, aws.StringValue(input.Attributes[0].Name))
+		assert.Equal(t, "cont-inst-id", aws.StringValue(input.Attributes[0].TargetId))
+		_, err := time.Parse(historyTimeFormat, aws.StringValue(input.Attributes[0].Value))
+		assert.NoError(t, err)
+		markerCalls = append(markerCalls, "mark")
+		return &ecs.PutAttributesOutput{}, nil
+	}
+	mockClear := func(input *ecs.DeleteAttributesInput) (*ecs.DeleteAttributesOutput, error) {
+		require.Len(t, input.Attributes, 1)
+		assert.Equal(t, attributeDrained, aws.StringValue(input.Attributes[0].Name))
+		assert.Equal(t, "cont-inst-id", aws.StringValue(input.Attributes[0].TargetId))
+		markerCalls = append(markerCalls, "clear")
+		return &ecs.DeleteAttributesOutput{}, nil
+	}
 	cleanup := func() {
 		stateChangeCalls = []string{}
+		markerCalls = []string{}
 	}
 
 	t.Run("no tasks success", func(t *testing.T) {
 		defer cleanup()
 		listTaskCount := 0
 		mockECS := MockECS{
+			PutAttributesFn:                 mockMark,
+			DeleteAttributesFn:              mockClear,
 			UpdateContainerInstancesStateFn: mockStateChange,
 			ListTasksFn: func(input *ecs.ListTasksInput) (*ecs.ListTasksOutput, error) {
 				assert.Equal(t, "test-cluster", aws.StringValue(input.Cluster))
@@ -448,17 +519,20 @@ func TestDrainInstance(t *testing.T) {
 				}, nil
 			},
 		}
-		u := updater{ecs: mockECS, cluster: "test-cluster"}
-		err := u.drainInstance("cont-inst-id")
+		u := clusterUpdater{ecs: mockECS, cluster: "test-cluster"}
+		err := u.drainInstance(aws.BackgroundContext(), instance{containerInstanceID: "cont-inst-id"})
 		require.NoError(t, err)
 		assert.Equal(t, 1, listTaskCount)
 		assert.Equal(t, []string{"DRAINING"}, stateChangeCalls)
+		assert.Equal(t, []string{"mark"}, markerCalls, "a drained instance is marked until it is reactivated")
 	})
 
 	t.Run("with tasks success", func(t *testing.T) {
 		defer cleanup()
 		waitCount := 0
 		mockECS := MockECS{
+			PutAttributesFn:                 mockMark,
+			DeleteAttributesFn:              mockClear,
 			UpdateContainerInstancesStateFn: mockStateChange,
 			ListTasksFn:                     mockListTasks,
 			WaitUntilTasksStoppedWithContextFn: func(ctx aws.Context, input *ecs.DescribeTasksInput, opts ...request.WaiterOption) error {
@@ -470,8 +544,8 @@ func TestDrainInstance(t *testing.T) {
 				return nil
 			},
 		}
-		u := updater{ecs: mockECS, cluster: "test-cluster"}
-		err := u.drainInstance("cont-inst-id")
+		u := clusterUpdater{ecs: mockECS, cluster: "test-cluster"}
+		err := u.drainInstance(aws.BackgroundContext(), instance{containerInstanceID: "cont-inst-id"})
 		require.NoError(t, err)
 		assert.Equal(t, []string{"DRAINING"}, stateChangeCalls)
 		assert.Equal(t, 1, waitCount)
@@ -481,14 +555,16 @@ func TestDrainInstance(t *testing.T) {
 		defer cleanup()
 		stateOutErr := errors.New("failed to change state")
 		mockECS := MockECS{
+			PutAttributesFn:    mockMark,
+			DeleteAttributesFn: mockClear,
 			UpdateContainerInstancesStateFn: func(input *ecs.UpdateContainerInstancesStateInput) (*ecs.UpdateContainerInstancesStateOutput, error) {
 				assert.Equal(t, "test-cluster", aws.StringValue(input.Cluster))
 				assert.Equal(t, []*string{aws.String("cont-inst-id")}, input.ContainerInstances)
 				return nil, stateOutErr
 			},
 		}
-		u := updater{ecs: mockECS, cluster: "test-cluster"}
-		err := u.drainInstance("cont-inst-id")
+		u := clusterUpdater{ecs: mockECS, cluster: "test-cluster"}
+		err := u.drainInstance(aws.BackgroundContext(), instance{containerInstanceID: "cont-inst-id"})
 		require.Error(t, err)
 		assert.ErrorIs(t, err, stateOutErr)
 	})
@@ -503,6 +579,8 @@ func TestDrainInstance(t *testing.T) {
 			},
 		}
 		mockECS := MockECS{
+			PutAttributesFn:    mockMark,
+			DeleteAttributesFn: mockClear,
 			UpdateContainerInstancesStateFn: func(input *ecs.UpdateContainerInstancesStateInput) (*ecs.UpdateContainerInstancesStateOutput, error) {
 				stateChangeCalls = append(stateChangeCalls, aws.StringValue(input.Status))
 				assert.Equal(t, "test-cluster", aws.StringValue(input.Cluster))
@@ -510,8 +588,8 @@ func TestDrainInstance(t *testing.T) {
 				return stateOutAPIFailure, nil
 			},
 		}
-		u := updater{ecs: mockECS, cluster: "test-cluster"}
-		err := u.drainInstance("cont-inst-id")
+		u := clusterUpdater{ecs: mockECS, cluster: "test-cluster"}
+		err := u.drainInstance(aws.BackgroundContext(), instance{containerInstanceID: "cont-inst-id"})
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), fmt.Sprintf("%v", stateOutAPIFailure.Failures))
 		assert.Equal(t, []string{"DRAINING", "ACTIVE"}, stateChangeCalls)
@@ -521,6 +599,8 @@ func TestDrainInstance(t *testing.T) {
 		defer cleanup()
 		listTaskErr := errors.New("failed to list tasks")
 		mockECS := MockECS{
+			PutAttributesFn:                 mockMark,
+			DeleteAttributesFn:              mockClear,
 			UpdateContainerInstancesStateFn: mockStateChange,
 			ListTasksFn: func(input *ecs.ListTasksInput) (*ecs.ListTasksOutput, error) {
 				assert.Equal(t, "test-cluster", aws.StringValue(input.Cluster))
@@ -528,8 +608,8 @@ func TestDrainInstance(t *testing.T) {
 				return nil, listTaskErr
 			},
 		}
-		u := updater{ecs: mockECS, cluster: "test-cluster"}
-		err := u.drainInstance("cont-inst-id")
+		u := clusterUpdater{ecs: mockECS, cluster: "test-cluster"}
+		err := u.drainInstance(aws.BackgroundContext(), instance{containerInstanceID: "cont-inst-id"})
 		require.Error(t, err)
 		assert.ErrorIs(t, err, listTaskErr)
 		assert.Equal(t, []string{"DRAINING", "ACTIVE"}, stateChangeCalls)
@@ -539,6 +619,8 @@ func TestDrainInstance(t *testing.T) {
 		defer cleanup()
 		waitTaskErr := errors.New("failed to wait for tasks to stop")
 		mockECS := MockECS{
+			PutAttributesFn:                 mockMark,
+			DeleteAttributesFn:              mockClear,
 			UpdateContainerInstancesStateFn: mockStateChange,
 			ListTasksFn:                     mockListTasks,
 			WaitUntilTasksStoppedWithContextFn: func(ctx aws.Context, input *ecs.DescribeTasksInput, opts ...request.WaiterOption) error {
@@ -549,11 +631,12 @@ func TestDrainInstance(t *testing.T) {
 				return waitTaskErr
 			},
 		}
-		u := updater{ecs: mockECS, cluster: "test-cluster"}
-		err := u.drainInstance("cont-inst-id")
+		u := clusterUpdater{ecs: mockECS, cluster: "test-cluster"}
+		err := u.drainInstance(aws.BackgroundContext(), instance{containerInstanceID: "cont-inst-id"})
 		require.Error(t, err)
 		assert.ErrorIs(t, err, waitTaskErr)
 		assert.Equal(t, []string{"DRAINING", "ACTIVE"}, stateChangeCalls)
+		assert.Equal(t, []string{"mark", "clear"}, markerCalls)
 	})
 }
 
@@ -617,13 +700,13 @@ func TestUpdateInstance(t *testing.T) {
 				},
 			}
 			mockEC2 := MockEC2{
-				WaitUntilInstanceStatusOkFn: func(input *ec2.DescribeInstanceStatusInput) error {
+				WaitUntilInstanceStatusOkWithContextFn: func(ctx aws.Context, input *ec2.DescribeInstanceStatusInput, opts ...request.WaiterOption) error {
 					assert.Equal(t, []*string{aws.String("instance-id")}, input.InstanceIds)
 					return nil
 				},
 			}
-			u := updater{ssm: mockSSM, ec2: mockEC2, checkDocument: "check-document", applyDocument: "apply-document", rebootDocument: "reboot-document"}
-			err := u.updateInstance(instance{
+			u := clusterUpdater{ssm: mockSSM, ec2: mockEC2, checkDocument: "check-document", applyDocument: "apply-document", rebootDocument: "reboot-document"}
+			err := u.updateInstance(context.Background(), instance{
 				instanceID:          "instance-id",
 				containerInstanceID: "cont-inst-id",
 				bottlerocketVersion: "v0.1.0",
@@ -671,8 +754,8 @@ func TestUpdateInstanceErr(t *testing.T) {
 				return nil, checkErr
 			},
 		}
-		u := updater{ssm: mockSSM, checkDocument: "check-document"}
-		err := u.updateInstance(instance{
+		u := clusterUpdater{ssm: mockSSM, checkDocument: "check-document"}
+		err := u.updateInstance(context.Background(), instance{
 			instanceID:          "instance-id",
 			containerInstanceID: "cont-inst-id",
 		})
@@ -692,8 +775,8 @@ func TestUpdateInstanceErr(t *testing.T) {
 			GetCommandInvocationFn:                mockGetCommandInvocation,
 			WaitUntilCommandExecutedWithContextFn: mockWaitCommandExecution,
 		}
-		u := updater{ssm: mockSSM, checkDocument: "check-document", applyDocument: "apply-document"}
-		err := u.updateInstance(instance{
+		u := clusterUpdater{ssm: mockSSM, checkDocument: "check-document", applyDocument: "apply-document"}
+		err := u.updateInstance(context.Background(), instance{
 			instanceID:          "instance-id",
 			containerInstanceID: "cont-inst-id",
 		})
@@ -713,8 +796,8 @@ func TestUpdateInstanceErr(t *testing.T) {
 			GetCommandInvocationFn:                mockGetCommandInvocation,
 			WaitUntilCommandExecutedWithContextFn: mockWaitCommandExecution,
 		}
-		u := updater{ssm: mockSSM, checkDocument: "check-document", applyDocument: "apply-document", rebootDocument: "reboot-document"}
-		err := u.updateInstance(instance{
+		u := clusterUpdater{ssm: mockSSM, checkDocument: "check-document", applyDocument: "apply-document", rebootDocument: "reboot-document"}
+		err := u.updateInstance(context.Background(), instance{
 			instanceID:          "instance-id",
 			containerInstanceID: "cont-inst-id",
 		})
@@ -732,8 +815,8 @@ func TestUpdateInstanceErr(t *testing.T) {
 			},
 			WaitUntilCommandExecutedWithContextFn: mockWaitCommandExecution,
 		}
-		u := updater{ssm: mockSSM, checkDocument: "check-document"}
-		err := u.updateInstance(instance{
+		u := clusterUpdater{ssm: mockSSM, checkDocument: "check-document"}
+		err := u.updateInstance(context.Background(), instance{
 			instanceID:          "instance-id",
 			containerInstanceID: "cont-inst-id",
 		})
@@ -755,8 +838,8 @@ func TestUpdateInstanceErr(t *testing.T) {
 				return &ssm.GetCommandInvocationOutput{}, nil
 			},
 		}
-		u := updater{ssm: mockSSM, checkDocument: "check-document"}
-		err := u.updateInstance(instance{
+		u := clusterUpdater{ssm: mockSSM, checkDocument: "check-document"}
+		err := u.updateInstance(context.Background(), instance{
 			instanceID:          "instance-id",
 			containerInstanceID: "cont-inst-id",
 		})
@@ -772,19 +855,65 @@ func TestUpdateInstanceErr(t *testing.T) {
 		}
 
 		mockEC2 := MockEC2{
-			WaitUntilInstanceStatusOkFn: func(input *ec2.DescribeInstanceStatusInput) error {
+			WaitUntilInstanceStatusOkWithContextFn: func(ctx aws.Context, input *ec2.DescribeInstanceStatusInput, opts ...request.WaiterOption) error {
 				assert.Equal(t, []*string{aws.String("instance-id")}, input.InstanceIds)
 				return waitErr
 			},
 		}
-		u := updater{ssm: mockSSM, ec2: mockEC2, checkDocument: "check-document", applyDocument: "apply-document", rebootDocument: "reboot-document"}
-		err := u.updateInstance(instance{
+		u := clusterUpdater{ssm: mockSSM, ec2: mockEC2, checkDocument: "check-document", applyDocument: "apply-document", rebootDocument: "reboot-document"}
+		err := u.updateInstance(context.Background(), instance{
 			instanceID:          "instance-id",
 			containerInstanceID: "cont-inst-id",
 		})
 		require.Error(t, err)
 		assert.ErrorIs(t, err, waitErr)
 	})
+	t.Run("shutdown", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		mockSSM := MockSSM{
+			SendCommandFn: func(input *ssm.SendCommandInput) (*ssm.SendCommandOutput, error) {
+				if aws.StringValue(input.DocumentName) == "reboot-document" {
+					// The updater is stopped while the instance reboots.
+					cancel()
+				}
+				return commandOutput, nil
+			},
+			GetCommandInvocationFn: mockGetCommandInvocation,
+			WaitUntilCommandExecutedWithContextFn: func(waitCtx aws.Context, input *ssm.GetCommandInvocationInput, opts ...request.WaiterOption) error {
+				assert.Equal(t, ctx, waitCtx, "the command waiter should be cancelled with the run")
+				return nil
+			},
+		}
+		mockEC2 := MockEC2{
+			WaitUntilInstanceStatusOkWithContextFn: func(ctx aws.Context, input *ec2.DescribeInstanceStatusInput, opts ...request.WaiterOption) error {
+				t.Error("unexpected wait for Ok status after shutdown")
+				return nil
+			},
+		}
+		u := clusterUpdater{ssm: mockSSM, ec2: mockEC2, checkDocument: "check-document", applyDocument: "apply-document", rebootDocument: "reboot-document"}
+		start := time.Now()
+		err := u.updateInstance(ctx, instance{
+			instanceID:          "instance-id",
+			containerInstanceID: "cont-inst-id",
+		})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Less(t, int64(time.Since(start)), int64(time.Second), "the wait for the reboot should stop on shutdown")
+	})
+}
+
+func TestWaitUntilOk(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	u := clusterUpdater{ec2: MockEC2{
+		WaitUntilInstanceStatusOkWithContextFn: func(waitCtx aws.Context, input *ec2.DescribeInstanceStatusInput, opts ...request.WaiterOption) error {
+			assert.Equal(t, ctx, waitCtx)
+			assert.Equal(t, []string{"instance-id"}, aws.StringValueSlice(input.InstanceIds))
+			return nil
+		},
+	}}
+	require.NoError(t, u.waitUntilOk(ctx, "instance-id"))
 }
 
 func TestVerifyUpdate(t *testing.T) {
@@ -839,8 +968,8 @@ func TestVerifyUpdate(t *testing.T) {
 					return nil
 				},
 			}
-			u := updater{ssm: mockSSM, checkDocument: "check-document"}
-			ok, err := u.verifyUpdate(instance{
+			u := clusterUpdater{ssm: mockSSM, checkDocument: "check-document"}
+			ok, err := u.verifyUpdate(context.Background(), instance{
 				instanceID:          "instance-id",
 				containerInstanceID: "cont-inst-id",
 				bottlerocketVersion: "0.0.0",
@@ -882,8 +1011,8 @@ func TestVerifyUpdateErr(t *testing.T) {
 				return nil, ssmCheckErr
 			},
 		}
-		u := updater{ssm: mockSSM, checkDocument: "check-document"}
-		ok, err := u.verifyUpdate(instance{
+		u := clusterUpdater{ssm: mockSSM, checkDocument: "check-document"}
+		ok, err := u.verifyUpdate(context.Background(), instance{
 			instanceID:          "instance-id",
 			containerInstanceID: "cont-inst-id",
 			bottlerocketVersion: "0.0.0",
@@ -907,8 +1036,8 @@ func TestVerifyUpdateErr(t *testing.T) {
 				return &ssm.GetCommandInvocationOutput{}, nil
 			},
 		}
-		u := updater{ssm: mockSSM, checkDocument: "check-document"}
-		ok, err := u.verifyUpdate(instance{
+		u := clusterUpdater{ssm: mockSSM, checkDocument: "check-document"}
+		ok, err := u.verifyUpdate(context.Background(), instance{
 			instanceID:          "instance-id",
 			containerInstanceID: "cont-inst-id",
 			bottlerocketVersion: "0.0.0",
@@ -928,8 +1057,8 @@ func TestVerifyUpdateErr(t *testing.T) {
 				return nil, ssmGetInvocationErr
 			},
 		}
-		u := updater{ssm: mockSSM, checkDocument: "check-document"}
-		ok, err := u.verifyUpdate(instance{
+		u := clusterUpdater{ssm: mockSSM, checkDocument: "check-document"}
+		ok, err := u.verifyUpdate(context.Background(), instance{
 			instanceID:          "instance-id",
 			containerInstanceID: "cont-inst-id",
 			bottlerocketVersion: "0.0.0",
@@ -945,8 +1074,8 @@ func TestVerifyUpdateErr(t *testing.T) {
 			WaitUntilCommandExecutedWithContextFn: mockWaitCommandExecution,
 			GetCommandInvocationFn:                mockGetCommandInvocation,
 		}
-		u := updater{ssm: mockSSM, checkDocument: "check-document"}
-		ok, err := u.verifyUpdate(instance{
+		u := clusterUpdater{ssm: mockSSM, checkDocument: "check-document"}
+		ok, err := u.verifyUpdate(context.Background(), instance{
 			instanceID:          "instance-id",
 			containerInstanceID: "cont-inst-id",
 			bottlerocketVersion: "0.0.0",
@@ -956,3 +1085,135 @@ func TestVerifyUpdateErr(t *testing.T) {
 		assert.False(t, ok)
 	})
 }
+
+func TestFiringAlarms(t *testing.T) {
+	cases := []struct {
+		name          string
+		alarms        []string
+		pages         []*cloudwatch.DescribeAlarmsOutput
+		describeErr   error
+		expectedError string
+		expectedOut   []string
+	}{
+		{
+			name:        "no alarms configured",
+			expectedOut: nil,
+		},
+		{
+			name:   "none firing",
+			alarms: []string{"alarm-1", "alarm-2"},
+			pages: []*cloudwatch.DescribeAlarmsOutput{
+				{},
+			},
+			expectedOut: []string{},
+		},
+		{
+			name:   "metric and composite firing",
+			alarms: []string{"alarm-1", "alarm-2", "composite-1"},
+			pages: []*cloudwatch.DescribeAlarmsOutput{
+				{
+					MetricAlarms: []*cloudwatch.MetricAlarm{{AlarmName: aws.String("alarm-2")}},
+					NextToken:    aws.String("token"),
+				},
+				{
+					CompositeAlarms: []*cloudwatch.CompositeAlarm{{AlarmName: aws.String("composite-1")}},
+				},
+			},
+			expectedOut: []string{"alarm-2", "composite-1"},
+		},
+		{
+			name:          "describe fail",
+			alarms:        []string{"alarm-1"},
+			describeErr:   errors.New("failed to describe"),
+			expectedError: "failed to describe alarms",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			mockCW := MockCloudWatch{
+				DescribeAlarmsFn: func(input *cloudwatch.DescribeAlarmsInput) (*cloudwatch.DescribeAlarmsOutput, error) {
+					assert.Equal(t, tc.alarms, aws.StringValueSlice(input.AlarmNames))
+					assert.Equal(t, cloudwatch.StateValueAlarm, aws.StringValue(input.StateValue))
+					if tc.describeErr != nil {
+						return nil, tc.describeErr
+					}
+					if calls > 0 {
+						assert.Equal(t, "token", aws.StringValue(input.NextToken))
+					}
+					resp := tc.pages[calls]
+					calls++
+					return resp, nil
+				},
+			}
+			u := clusterUpdater{alarms: tc.alarms, cloudwatch: mockCW}
+			firing, err := u.firingAlarms(aws.BackgroundContext())
+			if tc.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedError)
+				assert.ErrorIs(t, err, tc.describeErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedOut, firing)
+			assert.Equal(t, len(tc.pages), calls)
+		})
+	}
+}
+
+func TestEnsureActive(t *testing.T) {
+	instances := make([]string, 0)
+	for i := 0; i < 12; i++ {
+		instances = append(instances, fmt.Sprintf("cont-inst-%d", i))
+	}
+	// cleared records the container instances whose drain marker was deleted.
+	cleared := []string{}
+	mockClear := func(input *ecs.DeleteAttributesInput) (*ecs.DeleteAttributesOutput, error) {
+		for _, attr := range input.Attributes {
+			assert.Equal(t, attributeDrained, aws.StringValue(attr.Name))
+			cleared = append(cleared, aws.StringValue(attr.TargetId))
+		}
+		return &ecs.DeleteAttributesOutput{}, nil
+	}
+	t.Run("batches", func(t *testing.T) {
+		cleared = []string{}
+		batches := [][]string{}
+		mockECS := MockECS{
+			DeleteAttributesFn: mockClear,
+			UpdateContainerInstancesStateFn: func(input *ecs.UpdateContainerInstancesStateInput) (*ecs.UpdateContainerInstancesStateOutput, error) {
+				assert.Equal(t, "ACTIVE", aws.StringValue(input.Status))
+				batches = append(batches, aws.StringValueSlice(input.ContainerInstances))
+				return &ecs.UpdateContainerInstancesStateOutput{}, nil
+			},
+		}
+		u := clusterUpdater{ecs: mockECS}
+		err := u.ensureActive(aws.BackgroundContext(), instances)
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{instances[:10], instances[10:]}, batches)
+		assert.Equal(t, instances, cleared)
+	})
+	t.Run("failures", func(t *testing.T) {
+		cleared = []string{}
+		mockECS := MockECS{
+			DeleteAttributesFn: mockClear,
+			UpdateContainerInstancesStateFn: func(input *ecs.UpdateContainerInstancesStateInput) (*ecs.UpdateContainerInstancesStateOutput, error) {
+				if len(input.ContainerInstances) == 2 {
+					return nil, errors.New("failed to update state")
+				}
+				return &ecs.UpdateContainerInstancesStateOutput{
+					Failures: []*ecs.Failure{{Arn: aws.String("cont-inst-3"), Reason: aws.String("MISSING")}},
+				}, nil
+			},
+		}
+		u := clusterUpdater{ecs: mockECS}
+		err := u.ensureActive(aws.BackgroundContext(), instances)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `"cont-inst-3" "cont-inst-10" "cont-inst-11"`)
+		assert.NotContains(t, cleared, "cont-inst-3", "instances left draining keep their marker")
+		assert.Len(t, cleared, 9)
+	})
+	t.Run("nothing drained", func(t *testing.T) {
+		u := clusterUpdater{ecs: MockECS{}}
+		assert.NoError(t, u.ensureActive(aws.BackgroundContext(), []string{}))
+	})
+}
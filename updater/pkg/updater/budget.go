@@ -0,0 +1,78 @@
+package updater
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// failureBudget is the number of failed instances tolerated in a single run,
+// expressed either as an absolute count or as a percentage of the candidates.
+type failureBudget struct {
+	count   int
+	percent float64
+}
+
+// parseFailureBudget parses a failure budget such as "3" or "25%". An empty
+// value means the budget is unlimited.
+func parseFailureBudget(value string) (failureBudget, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return failureBudget{}, nil
+	}
+	if strings.HasSuffix(value, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil {
+			return failureBudget{}, fmt.Errorf("invalid failure budget percentage %q: %w", value, err)
+		}
+		if p <= 0 || p > 100 {
+			return failureBudget{}, fmt.Errorf("failure budget percentage %q must be greater than 0%% and at most 100%%", value)
+		}
+		return failureBudget{percent: p}, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return failureBudget{}, fmt.Errorf("invalid failure budget %q: %w", value, err)
+	}
+	if n <= 0 {
+		return failureBudget{}, fmt.Errorf("failure budget %q must be greater than 0", value)
+	}
+	return failureBudget{count: n}, nil
+}
+
+// unlimited reports whether no failure budget was configured.
+func (b failureBudget) unlimited() bool {
+	return b.count == 0 && b.percent == 0
+}
+
+// limit returns the number of failures that exhausts the budget for a run with
+// the given number of candidates. A percentage budget always allows at least one failure.
+func (b failureBudget) limit(candidates int) int {
+	if b.percent == 0 {
+		return b.count
+	}
+	n := int(math.Ceil(b.percent * float64(candidates) / 100))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// exhausted reports whether the number of failures has used up the budget.
+func (b failureBudget) exhausted(failures, candidates int) bool {
+	if b.unlimited() {
+		return false
+	}
+	return failures >= b.limit(candidates)
+}
+
+func (b failureBudget) String() string {
+	if b.unlimited() {
+		return "unlimited"
+	}
+	if b.percent != 0 {
+		return strconv.FormatFloat(b.percent, 'f', -1, 64) + "%"
+	}
+	return strconv.Itoa(b.count)
+}
@@ -0,0 +1,118 @@
+package updater
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFailureBudget(t *testing.T) {
+	cases := []struct {
+		name          string
+		value         string
+		expected      failureBudget
+		expectedError string
+	}{
+		{
+			name:     "empty",
+			value:    "",
+			expected: failureBudget{},
+		},
+		{
+			name:     "count",
+			value:    "3",
+			expected: failureBudget{count: 3},
+		},
+		{
+			name:     "percentage",
+			value:    "12.5%",
+			expected: failureBudget{percent: 12.5},
+		},
+		{
+			name:          "zero count",
+			value:         "0",
+			expectedError: "must be greater than 0",
+		},
+		{
+			name:          "percentage over 100",
+			value:         "150%",
+			expectedError: "must be greater than 0% and at most 100%",
+		},
+		{
+			name:          "not a number",
+			value:         "many",
+			expectedError: "invalid failure budget",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			budget, err := parseFailureBudget(tc.value)
+			if tc.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, budget)
+		})
+	}
+}
+
+func TestFailureBudgetExhausted(t *testing.T) {
+	cases := []struct {
+		name       string
+		budget     failureBudget
+		failures   int
+		candidates int
+		expected   bool
+	}{
+		{
+			name:       "unlimited",
+			budget:     failureBudget{},
+			failures:   10,
+			candidates: 10,
+			expected:   false,
+		},
+		{
+			name:       "count not reached",
+			budget:     failureBudget{count: 2},
+			failures:   1,
+			candidates: 10,
+			expected:   false,
+		},
+		{
+			name:       "count reached",
+			budget:     failureBudget{count: 2},
+			failures:   2,
+			candidates: 10,
+			expected:   true,
+		},
+		{
+			name:       "percentage rounds up",
+			budget:     failureBudget{percent: 25},
+			failures:   1,
+			candidates: 5,
+			expected:   false,
+		},
+		{
+			name:       "percentage reached",
+			budget:     failureBudget{percent: 25},
+			failures:   2,
+			candidates: 8,
+			expected:   true,
+		},
+		{
+			name:       "percentage allows at least one failure",
+			budget:     failureBudget{percent: 1},
+			failures:   1,
+			candidates: 3,
+			expected:   true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.budget.exhausted(tc.failures, tc.candidates))
+		})
+	}
+}
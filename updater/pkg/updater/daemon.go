@@ -0,0 +1,129 @@
+package updater
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// runDaemon repeats the update cycle every interval, plus a random delay of up to jitter, until
+// ctx is cancelled. Errors from a cycle are logged and do not stop the daemon. Cycles are skipped
+// while s is paused and can be started early on request through s.
+func runDaemon(ctx aws.Context, cycle func(aws.Context) error, s *status, interval, jitter time.Duration) error {
+	log.Printf("Starting daemon mode with an interval of %s and jitter of up to %s", interval, jitter)
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	s.setReady()
+	for {
+		start := time.Now()
+		if s.isPaused() {
+			log.Printf("Paused by operator, skipping update cycle")
+		} else if err := cycle(ctx); err != nil {
+			log.Printf("Update cycle failed: %v", err)
+		}
+		if ctx.Err() != nil {
+			log.Printf("Daemon stopped")
+			return nil
+		}
+
+		wait := time.Until(start.Add(interval))
+		if jitter > 0 {
+			wait += time.Duration(rng.Int63n(int64(jitter)))
+		}
+		if wait < 0 {
+			wait = 0
+		}
+		log.Printf("Next update cycle in %s", wait.Round(time.Second))
+		s.waitUntil(time.Now().Add(wait))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Printf("Daemon stopped")
+			return nil
+		case <-s.triggered():
+			timer.Stop()
+			log.Printf("Starting update cycle on request")
+		case <-timer.C:
+		}
+	}
+}
+
+// instanceBackoff is the retry state of an instance that failed to update.
+type instanceBackoff struct {
+	failures int
+	until    time.Time
+}
+
+// backoffTracker keeps per-instance retry state between update cycles so an instance that keeps
+// failing is not drained on every cycle. The delay doubles with each consecutive failure, starting
+// at base and capped at max. A nil tracker never backs off.
+type backoffTracker struct {
+	mu        sync.Mutex
+	base      time.Duration
+	max       time.Duration
+	instances map[string]*instanceBackoff
+}
+
+func newBackoffTracker(base, max time.Duration) *backoffTracker {
+	return &backoffTracker{
+		base:      base,
+		max:       max,
+		instances: make(map[string]*instanceBackoff),
+	}
+}
+
+// remaining returns how much longer the instance should be skipped.
+func (b *backoffTracker) remaining(instanceID string, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state, ok := b.instances[instanceID]
+	if !ok || !now.Before(state.until) {
+		return 0
+	}
+	return state.until.Sub(now)
+}
+
+// update records the results of a cycle: failed instances back off for longer and updated
+// instances are forgotten.
+func (b *backoffTracker) update(report *runReport) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for _, res := range report.results {
+		id := res.instance.instanceID
+		switch res.outcome {
+		case OutcomeUpdated:
+			delete(b.instances, id)
+		case OutcomeFailed:
+			state, ok := b.instances[id]
+			if !ok {
+				state = &instanceBackoff{}
+				b.instances[id] = state
+			}
+			state.failures++
+			state.until = now.Add(b.delay(state.failures))
+			log.Printf("Instance %q failed %d consecutive times, skipping it until %s", id, state.failures, state.until.Format(time.RFC3339))
+		}
+	}
+}
+
+// delay returns the backoff after the given number of consecutive failures.
+func (b *backoffTracker) delay(failures int) time.Duration {
+	d := b.base
+	for i := 1; i < failures && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	return d
+}
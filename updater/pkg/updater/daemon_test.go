@@ -0,0 +1,71 @@
+package updater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffTracker(t *testing.T) {
+	b := newBackoffTracker(time.Hour, 3*time.Hour)
+	failed := instance{instanceID: "inst-failed"}
+	updated := instance{instanceID: "inst-updated"}
+
+	report := newRunReport("test-cluster")
+	report.record(failed, OutcomeFailed, PhaseDrain, "drain timed out")
+	report.record(updated, OutcomeFailed, PhaseUpdate, "apply failed")
+	b.update(report)
+	now := time.Now()
+	assert.InDelta(t, time.Hour, b.remaining(failed.instanceID, now), float64(time.Minute))
+	assert.Equal(t, time.Duration(0), b.remaining("inst-unknown", now))
+
+	report = newRunReport("test-cluster")
+	report.record(failed, OutcomeFailed, PhaseDrain, "drain timed out")
+	report.record(updated, OutcomeUpdated, "", "")
+	b.update(report)
+	now = time.Now()
+	assert.InDelta(t, 2*time.Hour, b.remaining(failed.instanceID, now), float64(time.Minute))
+	assert.Equal(t, time.Duration(0), b.remaining(updated.instanceID, now), "updated instance should be forgotten")
+	assert.Equal(t, time.Duration(0), b.remaining(failed.instanceID, now.Add(3*time.Hour)))
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := newBackoffTracker(time.Hour, 5*time.Hour)
+	assert.Equal(t, time.Hour, b.delay(1))
+	assert.Equal(t, 2*time.Hour, b.delay(2))
+	assert.Equal(t, 4*time.Hour, b.delay(3))
+	assert.Equal(t, 5*time.Hour, b.delay(4))
+	assert.Equal(t, 5*time.Hour, b.delay(100))
+}
+
+func TestNilBackoffTracker(t *testing.T) {
+	var b *backoffTracker
+	report := newRunReport("test-cluster")
+	report.record(instance{instanceID: "inst-failed"}, OutcomeFailed, PhaseDrain, "drain timed out")
+	b.update(report)
+	assert.Equal(t, time.Duration(0), b.remaining("inst-failed", time.Now()))
+}
+
+func TestRunDaemonStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	u := &clusterUpdater{cluster: "test-cluster"}
+	// The maintenance schedule is closed so the cycle ends without calling AWS.
+	u.maintenance, _ = parseSchedule("", time.Now().UTC().Format(dateLayout), "")
+	done := make(chan error)
+	go func() {
+		done <- runDaemon(ctx, func(ctx aws.Context) error {
+			_, err := u.run(ctx)
+			return err
+		}, u.status, time.Hour, time.Minute)
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not stop after cancellation")
+	}
+}
@@ -0,0 +1,63 @@
+package updater
+
+import (
+	"fmt"
+	"strings"
+)
+
+// variantAttribute is the ECS attribute holding the Bottlerocket variant of a container instance.
+const variantAttribute = "bottlerocket.variant"
+
+// documentSet is the SSM documents used to check for, apply and reboot into an update.
+type documentSet struct {
+	check  string
+	apply  string
+	reboot string
+}
+
+// parseVariantDocuments parses a semicolon-separated list of document sets by variant, such as
+// "aws-ecs-1-nvidia=nvidia-check,nvidia-apply,nvidia-reboot". A document left empty, as in
+// "aws-ecs-1-nvidia=,nvidia-apply,", falls back to the default document.
+func parseVariantDocuments(value string) (map[string]documentSet, error) {
+	sets := make(map[string]documentSet)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		eq := strings.Index(entry, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("invalid variant documents %q, expected <variant>=<check>,<apply>,<reboot>", entry)
+		}
+		variant := strings.TrimSpace(entry[:eq])
+		docs := strings.Split(entry[eq+1:], ",")
+		if variant == "" || len(docs) != 3 {
+			return nil, fmt.Errorf("invalid variant documents %q, expected <variant>=<check>,<apply>,<reboot>", entry)
+		}
+		if _, ok := sets[variant]; ok {
+			return nil, fmt.Errorf("duplicate documents for variant %q", variant)
+		}
+		sets[variant] = documentSet{
+			check:  strings.TrimSpace(docs[0]),
+			apply:  strings.TrimSpace(docs[1]),
+			reboot: strings.TrimSpace(docs[2]),
+		}
+	}
+	return sets, nil
+}
+
+// documents returns the SSM documents for the instance: those configured for its variant, with
+// the default documents in place of any the variant does not override.
+func (u *clusterUpdater) documents(inst instance) documentSet {
+	docs := u.variantDocuments[inst.variant]
+	if docs.check == "" {
+		docs.check = u.checkDocument
+	}
+	if docs.apply == "" {
+		docs.apply = u.applyDocument
+	}
+	if docs.reboot == "" {
+		docs.reboot = u.rebootDocument
+	}
+	return docs
+}
@@ -0,0 +1,98 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVariantDocuments(t *testing.T) {
+	sets, err := parseVariantDocuments(" aws-ecs-1-nvidia = nvidia-check, nvidia-apply, nvidia-reboot ; aws-ecs-2=,ecs2-apply,;")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]documentSet{
+		"aws-ecs-1-nvidia": {check: "nvidia-check", apply: "nvidia-apply", reboot: "nvidia-reboot"},
+		"aws-ecs-2":        {apply: "ecs2-apply"},
+	}, sets)
+
+	sets, err = parseVariantDocuments("")
+	require.NoError(t, err)
+	assert.Empty(t, sets)
+
+	for _, value := range []string{
+		"aws-ecs-1-nvidia",
+		"aws-ecs-1-nvidia=check,apply",
+		"=check,apply,reboot",
+		"aws-ecs-1=a,b,c;aws-ecs-1=d,e,f",
+	} {
+		t.Run(value, func(t *testing.T) {
+			_, err := parseVariantDocuments(value)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDocuments(t *testing.T) {
+	u := clusterUpdater{
+		checkDocument:  "check",
+		applyDocument:  "apply",
+		rebootDocument: "reboot",
+		variantDocuments: map[string]documentSet{
+			"aws-ecs-1-nvidia": {apply: "nvidia-apply"},
+		},
+	}
+	assert.Equal(t, documentSet{check: "check", apply: "nvidia-apply", reboot: "reboot"}, u.documents(instance{variant: "aws-ecs-1-nvidia"}))
+	assert.Equal(t, documentSet{check: "check", apply: "apply", reboot: "reboot"}, u.documents(instance{variant: "aws-ecs-1"}))
+	assert.Equal(t, documentSet{check: "check", apply: "apply", reboot: "reboot"}, u.documents(instance{}))
+}
+
+func TestFilterAvailableUpdatesVariantDocuments(t *testing.T) {
+	sent := map[string][]string{}
+	mockSSM := MockSSM{
+		SendCommandFn: func(input *ssm.SendCommandInput) (*ssm.SendCommandOutput, error) {
+			doc := aws.StringValue(input.DocumentName)
+			sent[doc] = aws.StringValueSlice(input.InstanceIds)
+			return &ssm.SendCommandOutput{Command: &ssm.Command{CommandId: aws.String("command-" + doc)}}, nil
+		},
+		WaitUntilCommandExecutedWithContextFn: func(ctx aws.Context, input *ssm.GetCommandInvocationInput, opts ...request.WaiterOption) error {
+			return nil
+		},
+		GetCommandInvocationFn: func(input *ssm.GetCommandInvocationInput) (*ssm.GetCommandInvocationOutput, error) {
+			// Only the instances checked with the nvidia document have an update available.
+			state := "Idle"
+			if aws.StringValue(input.CommandId) == "command-nvidia-check" {
+				state = "Available"
+			}
+			return &ssm.GetCommandInvocationOutput{
+				Status:                aws.String("Success"),
+				StandardOutputContent: aws.String(fmt.Sprintf(`{"update_state": %q, "active_partition": {"image": {"version": "1.0.5"}}}`, state)),
+			}, nil
+		},
+	}
+	u := clusterUpdater{
+		ssm:           mockSSM,
+		checkDocument: "check",
+		variantDocuments: map[string]documentSet{
+			"aws-ecs-1-nvidia": {check: "nvidia-check"},
+		},
+	}
+	candidates, err := u.filterAvailableUpdates(context.Background(), []instance{
+		{instanceID: "inst-id-1", variant: "aws-ecs-1"},
+		{instanceID: "inst-id-2", variant: "aws-ecs-1-nvidia"},
+		{instanceID: "inst-id-3", variant: "aws-ecs-1"},
+		{instanceID: "inst-id-4", variant: "aws-ecs-1-nvidia"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"check":        {"inst-id-1", "inst-id-3"},
+		"nvidia-check": {"inst-id-2", "inst-id-4"},
+	}, sent)
+	require.Len(t, candidates, 2)
+	assert.Equal(t, "inst-id-2", candidates[0].instanceID)
+	assert.Equal(t, "inst-id-4", candidates[1].instanceID)
+}
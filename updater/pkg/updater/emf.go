@@ -0,0 +1,151 @@
+package updater
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultEMFNamespace is the CloudWatch namespace metrics are published to by default.
+const DefaultEMFNamespace = "BottlerocketECSUpdater"
+
+// emfEmitter writes the results of each run as CloudWatch Embedded Metric Format (EMF) log
+// lines. When the updater runs with the awslogs log driver, CloudWatch Logs extracts the metrics
+// from these lines without a metrics agent. A nil emitter writes nothing.
+type emfEmitter struct {
+	// mu serializes writes from updaters running in parallel so that lines are not interleaved.
+	mu        sync.Mutex
+	w         io.Writer
+	namespace string
+}
+
+func newEMFEmitter(w io.Writer, namespace string) *emfEmitter {
+	if namespace == "" {
+		namespace = DefaultEMFNamespace
+	}
+	return &emfEmitter{w: w, namespace: namespace}
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// emfVersionStats aggregates the results for instances that started on the same version.
+type emfVersionStats struct {
+	updated, skipped, failed int
+	drainTimes               []float64
+	updateTimes              []float64
+}
+
+// emit writes one line with the totals for the cluster, and one line per Bottlerocket version
+// the candidates were running with the results and durations for that version.
+func (e *emfEmitter) emit(report *runReport) {
+	if e == nil {
+		return
+	}
+	timestamp := report.end
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	counts := []emfMetric{
+		{Name: "InstancesUpdated", Unit: "Count"},
+		{Name: "InstancesSkipped", Unit: "Count"},
+		{Name: "InstancesFailed", Unit: "Count"},
+	}
+	e.write(timestamp, [][]string{{"Cluster"}},
+		append([]emfMetric{{Name: "Candidates", Unit: "Count"}}, counts...),
+		map[string]interface{}{
+			"Cluster":          report.cluster,
+			"Candidates":       report.candidates,
+			"InstancesUpdated": report.count(OutcomeUpdated),
+			"InstancesSkipped": report.count(OutcomeSkipped),
+			"InstancesFailed":  report.count(OutcomeFailed),
+			"HaltReason":       report.haltReason,
+		})
+
+	stats := make(map[string]*emfVersionStats)
+	for _, res := range report.results {
+		version := res.instance.bottlerocketVersion
+		s, ok := stats[version]
+		if !ok {
+			s = &emfVersionStats{}
+			stats[version] = s
+		}
+		switch res.outcome {
+		case OutcomeUpdated:
+			s.updated++
+		case OutcomeSkipped:
+			s.skipped++
+		case OutcomeFailed:
+			s.failed++
+		}
+		if res.drainTime != 0 {
+			s.drainTimes = append(s.drainTimes, res.drainTime.Seconds())
+		}
+		if res.updateTime != 0 {
+			s.updateTimes = append(s.updateTimes, res.updateTime.Seconds())
+		}
+	}
+	versions := make([]string, 0, len(stats))
+	for version := range stats {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	for _, version := range versions {
+		s := stats[version]
+		metrics := append([]emfMetric(nil), counts...)
+		values := map[string]interface{}{
+			"Cluster":             report.cluster,
+			"BottlerocketVersion": version,
+			"InstancesUpdated":    s.updated,
+			"InstancesSkipped":    s.skipped,
+			"InstancesFailed":     s.failed,
+		}
+		// EMF accepts an array of values for a metric, which keeps one data point per instance.
+		if len(s.drainTimes) != 0 {
+			metrics = append(metrics, emfMetric{Name: "DrainDuration", Unit: "Seconds"})
+			values["DrainDuration"] = s.drainTimes
+		}
+		if len(s.updateTimes) != 0 {
+			metrics = append(metrics, emfMetric{Name: "UpdateDuration", Unit: "Seconds"})
+			values["UpdateDuration"] = s.updateTimes
+		}
+		e.write(timestamp, [][]string{{"Cluster", "BottlerocketVersion"}}, metrics, values)
+	}
+}
+
+func (e *emfEmitter) write(timestamp time.Time, dimensions [][]string, metrics []emfMetric, values map[string]interface{}) {
+	values["_aws"] = emfMetadata{
+		Timestamp: timestamp.UnixNano() / int64(time.Millisecond),
+		CloudWatchMetrics: []emfDirective{{
+			Namespace:  e.namespace,
+			Dimensions: dimensions,
+			Metrics:    metrics,
+		}},
+	}
+	line, err := json.Marshal(values)
+	if err != nil {
+		log.Printf("Failed to encode EMF metrics: %v", err)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.w.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write EMF metrics: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEMFEmit(t *testing.T) {
+	report := newRunReport("test-cluster")
+	report.end = time.Unix(1600000000, 0)
+	report.candidates = 3
+	old := instance{instanceID: "inst-1", bottlerocketVersion: "1.0.5"}
+	res := report.record(old, OutcomeUpdated, "", "")
+	res.drainTime = 30 * time.Second
+	res.updateTime = 5 * time.Minute
+	report.record(instance{instanceID: "inst-2", bottlerocketVersion: "1.0.5"}, OutcomeFailed, PhaseDrain, "drain timed out")
+	report.record(instance{instanceID: "inst-3", bottlerocketVersion: "1.0.4"}, OutcomeSkipped, PhaseEligibility, skipNonServiceTask)
+
+	buf := &bytes.Buffer{}
+	newEMFEmitter(buf, "").emit(report)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+
+	decode := func(line string) map[string]interface{} {
+		out := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &out))
+		return out
+	}
+
+	total := decode(lines[0])
+	assert.Equal(t, "test-cluster", total["Cluster"])
+	assert.EqualValues(t, 3, total["Candidates"])
+	assert.EqualValues(t, 1, total["InstancesUpdated"])
+	assert.EqualValues(t, 1, total["InstancesSkipped"])
+	assert.EqualValues(t, 1, total["InstancesFailed"])
+	meta := total["_aws"].(map[string]interface{})
+	assert.EqualValues(t, 1600000000000, meta["Timestamp"])
+	directive := meta["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, DefaultEMFNamespace, directive["Namespace"])
+	assert.Equal(t, []interface{}{[]interface{}{"Cluster"}}, directive["Dimensions"])
+
+	v104 := decode(lines[1])
+	assert.Equal(t, "1.0.4", v104["BottlerocketVersion"])
+	assert.EqualValues(t, 1, v104["InstancesSkipped"])
+	assert.NotContains(t, v104, "DrainDuration")
+
+	v105 := decode(lines[2])
+	assert.Equal(t, "1.0.5", v105["BottlerocketVersion"])
+	assert.EqualValues(t, 1, v105["InstancesUpdated"])
+	assert.EqualValues(t, 1, v105["InstancesFailed"])
+	assert.Equal(t, []interface{}{30.0}, v105["DrainDuration"])
+	assert.Equal(t, []interface{}{300.0}, v105["UpdateDuration"])
+	directive = v105["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, []interface{}{[]interface{}{"Cluster", "BottlerocketVersion"}}, directive["Dimensions"])
+	assert.Len(t, directive["Metrics"], 5)
+}
+
+func TestNilEMFEmitter(t *testing.T) {
+	var e *emfEmitter
+	assert.NotPanics(t, func() { e.emit(newRunReport("test-cluster")) })
+}
@@ -0,0 +1,115 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+)
+
+const (
+	// eventSource is the source of every event published by the updater.
+	eventSource = "bottlerocket.ecs-updater"
+	// eventSchemaVersion is the version of the EventDetail schema. It changes whenever a field
+	// is removed or its meaning changes; new fields may be added without changing it.
+	eventSchemaVersion = "1"
+)
+
+// EventType is the detail-type of an event, describing the transition that happened.
+type EventType string
+
+const (
+	EventCandidateFound EventType = "Bottlerocket Update Candidate Found"
+	EventDrainStarted   EventType = "Bottlerocket Instance Drain Started"
+	EventDrainCompleted EventType = "Bottlerocket Instance Drain Completed"
+	EventDrainFailed    EventType = "Bottlerocket Instance Drain Failed"
+	EventUpdateApplied  EventType = "Bottlerocket Instance Update Applied"
+	EventRebootSent     EventType = "Bottlerocket Instance Reboot Sent"
+	EventVerified       EventType = "Bottlerocket Instance Update Verified"
+	EventRolledBack     EventType = "Bottlerocket Instance Update Rolled Back"
+	EventReactivated    EventType = "Bottlerocket Instance Reactivated"
+	EventQuarantined    EventType = "Bottlerocket Instance Quarantined"
+)
+
+// EventDetail is the detail of every event published by the updater.
+type EventDetail struct {
+	SchemaVersion        string `json:"schemaVersion"`
+	Cluster              string `json:"cluster"`
+	InstanceID           string `json:"instanceId"`
+	ContainerInstanceARN string `json:"containerInstanceArn"`
+	FromVersion          string `json:"fromVersion,omitempty"`
+	ToVersion            string `json:"toVersion,omitempty"`
+	Reason               string `json:"reason,omitempty"`
+}
+
+// eventPublisher publishes instance state transitions to an EventBridge event bus, if events is
+// set, and to the transition hook, if set. Publishing is best effort: failures are logged and
+// never interrupt an update. A nil publisher publishes nothing.
+type eventPublisher struct {
+	events  EventsAPI
+	busName string
+	cluster string
+	hook    func(EventType, EventDetail)
+}
+
+// publish sends an event of the given type about the instance. The event is sent even if ctx
+// is cancelled, so that transitions made while the updater shuts down are published.
+func (p *eventPublisher) publish(ctx aws.Context, t EventType, inst instance, reason string) {
+	if p == nil {
+		return
+	}
+	d := EventDetail{
+		SchemaVersion:        eventSchemaVersion,
+		Cluster:              p.cluster,
+		InstanceID:           inst.instanceID,
+		ContainerInstanceARN: inst.containerInstanceID,
+		FromVersion:          inst.bottlerocketVersion,
+		ToVersion:            inst.targetVersion,
+		Reason:               reason,
+	}
+	if p.hook != nil {
+		p.hook(t, d)
+	}
+	if p.events == nil {
+		return
+	}
+	detail, err := json.Marshal(d)
+	if err != nil {
+		log.Printf("Failed to encode %q event for instance %q: %v", t, inst.instanceID, err)
+		return
+	}
+	resp, err := p.events.PutEventsWithContext(context.WithoutCancel(ctx), &eventbridge.PutEventsInput{
+		Entries: []*eventbridge.PutEventsRequestEntry{{
+			EventBusName: aws.String(p.busName),
+			Source:       aws.String(eventSource),
+			DetailType:   aws.String(string(t)),
+			Detail:       aws.String(string(detail)),
+			Resources:    aws.StringSlice(eventResources(inst)),
+			Time:         aws.Time(time.Now()),
+		}},
+	})
+	if err != nil {
+		log.Printf("Failed to publish %q event for instance %q: %v", t, inst.instanceID, err)
+		return
+	}
+	if aws.Int64Value(resp.FailedEntryCount) != 0 {
+		for _, entry := range resp.Entries {
+			if entry.ErrorCode != nil {
+				log.Printf("Failed to publish %q event for instance %q: %s: %s", t, inst.instanceID,
+					aws.StringValue(entry.ErrorCode), aws.StringValue(entry.ErrorMessage))
+			}
+		}
+	}
+}
+
+// eventResources returns the resources an event is about.
+func eventResources(inst instance) []string {
+	resources := make([]string, 0, 1)
+	if inst.containerInstanceID != "" {
+		resources = append(resources, inst.containerInstanceID)
+	}
+	return resources
+}
@@ -0,0 +1,99 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventPublisher(t *testing.T) {
+	inst := instance{
+		instanceID:          "inst-id-1",
+		containerInstanceID: "cont-inst-arn-1",
+		bottlerocketVersion: "1.0.5",
+		targetVersion:       "1.0.6",
+	}
+	var entries []*eventbridge.PutEventsRequestEntry
+	mockEvents := MockEvents{
+		PutEventsFn: func(input *eventbridge.PutEventsInput) (*eventbridge.PutEventsOutput, error) {
+			entries = append(entries, input.Entries...)
+			return &eventbridge.PutEventsOutput{FailedEntryCount: aws.Int64(0)}, nil
+		},
+	}
+	p := &eventPublisher{events: mockEvents, busName: "test-bus", cluster: "test-cluster"}
+	p.publish(aws.BackgroundContext(), EventDrainFailed, inst, "drain timed out")
+
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	assert.Equal(t, "test-bus", aws.StringValue(entry.EventBusName))
+	assert.Equal(t, eventSource, aws.StringValue(entry.Source))
+	assert.Equal(t, string(EventDrainFailed), aws.StringValue(entry.DetailType))
+	assert.Equal(t, []string{"cont-inst-arn-1"}, aws.StringValueSlice(entry.Resources))
+	detail := EventDetail{}
+	require.NoError(t, json.Unmarshal([]byte(aws.StringValue(entry.Detail)), &detail))
+	assert.Equal(t, EventDetail{
+		SchemaVersion:        eventSchemaVersion,
+		Cluster:              "test-cluster",
+		InstanceID:           "inst-id-1",
+		ContainerInstanceARN: "cont-inst-arn-1",
+		FromVersion:          "1.0.5",
+		ToVersion:            "1.0.6",
+		Reason:               "drain timed out",
+	}, detail)
+}
+
+func TestEventPublisherErr(t *testing.T) {
+	mockEvents := MockEvents{
+		PutEventsFn: func(input *eventbridge.PutEventsInput) (*eventbridge.PutEventsOutput, error) {
+			return nil, errors.New("failed to put events")
+		},
+	}
+	p := &eventPublisher{events: mockEvents, busName: "test-bus", cluster: "test-cluster"}
+	assert.NotPanics(t, func() { p.publish(aws.BackgroundContext(), EventRebootSent, instance{instanceID: "inst-id-1"}, "") })
+
+	var nilPublisher *eventPublisher
+	assert.NotPanics(t, func() {
+		nilPublisher.publish(aws.BackgroundContext(), EventRebootSent, instance{instanceID: "inst-id-1"}, "")
+	})
+}
+
+func TestDrainInstanceEvents(t *testing.T) {
+	published := []string{}
+	mockEvents := MockEvents{
+		PutEventsFn: func(input *eventbridge.PutEventsInput) (*eventbridge.PutEventsOutput, error) {
+			published = append(published, aws.StringValue(input.Entries[0].DetailType))
+			return &eventbridge.PutEventsOutput{FailedEntryCount: aws.Int64(0)}, nil
+		},
+	}
+	mockECS := MockECS{
+		UpdateContainerInstancesStateFn: func(input *ecs.UpdateContainerInstancesStateInput) (*ecs.UpdateContainerInstancesStateOutput, error) {
+			return &ecs.UpdateContainerInstancesStateOutput{}, nil
+		},
+		ListTasksFn: func(input *ecs.ListTasksInput) (*ecs.ListTasksOutput, error) {
+			return &ecs.ListTasksOutput{TaskArns: []*string{aws.String("task-arn-1")}}, nil
+		},
+		WaitUntilTasksStoppedWithContextFn: func(ctx aws.Context, input *ecs.DescribeTasksInput, opts ...request.WaiterOption) error {
+			return errors.New("exceeded max attempts")
+		},
+		PutAttributesFn: func(input *ecs.PutAttributesInput) (*ecs.PutAttributesOutput, error) {
+			return &ecs.PutAttributesOutput{}, nil
+		},
+		DeleteAttributesFn: func(input *ecs.DeleteAttributesInput) (*ecs.DeleteAttributesOutput, error) {
+			return &ecs.DeleteAttributesOutput{}, nil
+		},
+	}
+	u := clusterUpdater{
+		ecs:    mockECS,
+		events: &eventPublisher{events: mockEvents, busName: "test-bus", cluster: "test-cluster"},
+	}
+	err := u.drainInstance(aws.BackgroundContext(), instance{instanceID: "inst-id-1", containerInstanceID: "cont-inst-arn-1"})
+	require.Error(t, err)
+	assert.Equal(t, []string{string(EventDrainStarted), string(EventDrainFailed), string(EventReactivated)}, published)
+}
@@ -0,0 +1,475 @@
+package updater
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// maxDescribeClusters is the maximum number of clusters accepted by a single DescribeClusters call.
+const maxDescribeClusters = 100
+
+// target is a cluster managed by a fleet. A target without a role or region is in the account and
+// region of the updater's own credentials.
+type target struct {
+	// roleARN is the IAM role assumed to manage the cluster.
+	roleARN string
+	region  string
+	// cluster is the cluster name, or its ARN when the role names the cluster's account.
+	cluster string
+}
+
+// String returns the cluster with the account and region it is in, when they are not those of the
+// updater's own credentials, so that clusters with the same name are told apart in logs.
+func (t target) String() string {
+	if arn.IsARN(t.cluster) {
+		return t.cluster
+	}
+	var where []string
+	if role, err := arn.Parse(t.roleARN); err == nil {
+		where = append(where, "account "+role.AccountID)
+	}
+	if t.region != "" {
+		where = append(where, "region "+t.region)
+	}
+	if len(where) == 0 {
+		return t.cluster
+	}
+	return fmt.Sprintf("%s (%s)", t.cluster, strings.Join(where, ", "))
+}
+
+// parseTargets parses a semicolon-separated list of <role ARN>,<region>,<cluster> targets, such as
+// "arn:aws:iam::111122223333:role/updater,us-west-2,prod". The role may be left empty to use the
+// updater's own credentials, and the region to use the updater's region.
+func parseTargets(value string) ([]target, error) {
+	targets := make([]target, 0)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, ",")
+		if len(fields) != 3 || strings.TrimSpace(fields[2]) == "" {
+			return nil, fmt.Errorf("invalid target %q, expected <role ARN>,<region>,<cluster>", entry)
+		}
+		t := target{
+			roleARN: strings.TrimSpace(fields[0]),
+			region:  strings.TrimSpace(fields[1]),
+			cluster: strings.TrimSpace(fields[2]),
+		}
+		if t.roleARN != "" {
+			role, err := arn.Parse(t.roleARN)
+			if err != nil || role.Service != "iam" || !strings.HasPrefix(role.Resource, "role/") {
+				return nil, fmt.Errorf("invalid target %q: %q is not an IAM role ARN", entry, t.roleARN)
+			}
+			// The cluster is referred to by its ARN so that clusters with the same name in
+			// different accounts and regions are told apart.
+			if !arn.IsARN(t.cluster) && t.region != "" {
+				t.cluster = arn.ARN{
+					Partition: role.Partition,
+					Service:   "ecs",
+					Region:    t.region,
+					AccountID: role.AccountID,
+					Resource:  "cluster/" + t.cluster,
+				}.String()
+			}
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// clusterSelector chooses the clusters managed by a fleet: the targets and clusters named
+// explicitly, plus the clusters in the updater's account and region whose name matches pattern
+// and that carry the tag.
+type clusterSelector struct {
+	targets  []target
+	pattern  string
+	tagKey   string
+	tagValue string
+}
+
+// parseClusterSelector parses the cluster names and the cluster-pattern, cluster-tag and targets
+// settings.
+func parseClusterSelector(names []string, pattern, tag, targets string) (clusterSelector, error) {
+	s := clusterSelector{pattern: strings.TrimSpace(pattern)}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		s.targets = append(s.targets, target{cluster: name})
+	}
+	parsed, err := parseTargets(targets)
+	if err != nil {
+		return clusterSelector{}, err
+	}
+	s.targets = append(s.targets, parsed...)
+	if s.pattern != "" {
+		if _, err := path.Match(s.pattern, ""); err != nil {
+			return clusterSelector{}, fmt.Errorf("invalid cluster pattern %q: %w", s.pattern, err)
+		}
+	}
+	if tag = strings.TrimSpace(tag); tag != "" {
+		eq := strings.Index(tag, "=")
+		if eq <= 0 {
+			return clusterSelector{}, fmt.Errorf("invalid cluster tag %q, expected <key>=<value>", tag)
+		}
+		s.tagKey, s.tagValue = strings.TrimSpace(tag[:eq]), strings.TrimSpace(tag[eq+1:])
+	}
+	if len(s.targets) == 0 && s.pattern == "" && s.tagKey == "" {
+		return clusterSelector{}, fmt.Errorf("cluster, cluster-pattern, cluster-tag or targets is required")
+	}
+	return s, nil
+}
+
+// discovers reports whether clusters are discovered through the ECS API.
+func (s clusterSelector) discovers() bool {
+	return s.pattern != "" || s.tagKey != ""
+}
+
+func (s clusterSelector) matches(cluster *ecs.Cluster) bool {
+	if s.pattern != "" {
+		if ok, _ := path.Match(s.pattern, aws.StringValue(cluster.ClusterName)); !ok {
+			return false
+		}
+	}
+	if s.tagKey == "" {
+		return true
+	}
+	for _, tag := range cluster.Tags {
+		if aws.StringValue(tag.Key) == s.tagKey && aws.StringValue(tag.Value) == s.tagValue {
+			return true
+		}
+	}
+	return false
+}
+
+// fleet manages several clusters, possibly in several accounts and regions, each with its own
+// updater, processing up to parallel clusters at a time.
+type fleet struct {
+	ecs      ECSAPI
+	selector clusterSelector
+	parallel int
+	// newUpdater returns the updater for a target. It is called once per target and the
+	// updater is kept across cycles so that per-instance state such as backoff is preserved.
+	newUpdater func(t target) (*clusterUpdater, error)
+	// remote holds the settings loaded from SSM Parameter Store, which may override parallel.
+	remote *remoteConfig
+
+	mu       sync.Mutex
+	updaters map[target]*clusterUpdater
+}
+
+func newFleet(ecsClient ECSAPI, selector clusterSelector, parallel int, newUpdater func(target) (*clusterUpdater, error)) *fleet {
+	if parallel < 1 {
+		parallel = 1
+	}
+	return &fleet{
+		ecs:        ecsClient,
+		selector:   selector,
+		parallel:   parallel,
+		newUpdater: newUpdater,
+		updaters:   make(map[target]*clusterUpdater),
+	}
+}
+
+// clusters returns the clusters to update, resolving the pattern and tag through the ECS API.
+func (f *fleet) clusters() ([]target, error) {
+	clusters := make([]target, 0, len(f.selector.targets))
+	seen := make(map[target]bool)
+	for _, t := range f.selector.targets {
+		if !seen[t] {
+			seen[t] = true
+			clusters = append(clusters, t)
+		}
+	}
+	if !f.selector.discovers() {
+		return clusters, nil
+	}
+
+	arns := make([]*string, 0)
+	input := &ecs.ListClustersInput{}
+	for {
+		resp, err := f.ecs.ListClusters(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list clusters: %w", err)
+		}
+		arns = append(arns, resp.ClusterArns...)
+		if aws.StringValue(resp.NextToken) == "" {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	discovered := make([]target, 0)
+	for start := 0; start < len(arns); start += maxDescribeClusters {
+		end := start + maxDescribeClusters
+		if end > len(arns) {
+			end = len(arns)
+		}
+		describeInput := &ecs.DescribeClustersInput{Clusters: arns[start:end]}
+		if f.selector.tagKey != "" {
+			describeInput.Include = aws.StringSlice([]string{ecs.ClusterFieldTags})
+		}
+		resp, err := f.ecs.DescribeClusters(describeInput)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe clusters: %w", err)
+		}
+		for _, cluster := range resp.Clusters {
+			t := target{cluster: aws.StringValue(cluster.ClusterName)}
+			if f.selector.matches(cluster) && !seen[t] {
+				seen[t] = true
+				discovered = append(discovered, t)
+			}
+		}
+	}
+	sort.Slice(discovered, func(i, j int) bool { return discovered[i].cluster < discovered[j].cluster })
+	return append(clusters, discovered...), nil
+}
+
+// updater returns the updater for the target, creating it on first use.
+func (f *fleet) updater(t target) (*clusterUpdater, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if u, ok := f.updaters[t]; ok {
+		return u, nil
+	}
+	u, err := f.newUpdater(t)
+	if err != nil {
+		return nil, err
+	}
+	f.updaters[t] = u
+	return u, nil
+}
+
+// run performs an update cycle in every cluster and returns the combined report. The error
+// summarizes the clusters whose cycle failed.
+func (f *fleet) run(ctx aws.Context) (*fleetReport, error) {
+	report := &fleetReport{start: time.Now()}
+	parallel := f.parallel
+	if remote := f.remote.load(); remote.parallel > 0 {
+		parallel = remote.parallel
+	}
+	clusters, err := f.clusters()
+	if err != nil {
+		return report, err
+	}
+	if len(clusters) == 0 {
+		log.Printf("No clusters matched the cluster selectors")
+		return report, nil
+	}
+	if len(clusters) > 1 {
+		log.Printf("Updating %d clusters, up to %d at a time: %q", len(clusters), parallel, clusters)
+	}
+
+	report.results = make([]clusterResult, len(clusters))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, t := range clusters {
+		report.results[i].target = t
+		if ctx.Err() != nil {
+			report.results[i].err = fmt.Errorf("not started: %w", ctx.Err())
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(res *clusterResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			u, err := f.updater(res.target)
+			if err != nil {
+				res.err = err
+				return
+			}
+			res.report, res.err = u.run(ctx)
+		}(&report.results[i])
+	}
+	wg.Wait()
+	report.end = time.Now()
+	if len(clusters) > 1 {
+		report.log()
+	}
+	return report, report.err()
+}
+
+// each calls fn with the updater of every cluster, one cluster at a time, and returns an error
+// naming the clusters for which it failed.
+func (f *fleet) each(fn func(u *clusterUpdater) error) error {
+	clusters, err := f.clusters()
+	if err != nil {
+		return err
+	}
+	failed := make([]string, 0)
+	for _, t := range clusters {
+		u, err := f.updater(t)
+		if err == nil {
+			err = fn(u)
+		}
+		if err != nil {
+			if len(clusters) == 1 {
+				return err
+			}
+			log.Printf("Cluster %q: %v", t, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", t, err))
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("failed in %d of %d clusters: %s", len(failed), len(clusters), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// clusterResult is the outcome of an update cycle in one cluster. A failure in one cluster,
+// including a failure to assume its role, does not affect the others.
+type clusterResult struct {
+	target target
+	report *runReport
+	err    error
+}
+
+// fleetReport combines the reports of an update cycle across clusters.
+type fleetReport struct {
+	start   time.Time
+	end     time.Time
+	results []clusterResult
+}
+
+// count returns the number of instances with the given outcome across clusters.
+func (r *fleetReport) count(o Outcome) int {
+	n := 0
+	for _, res := range r.results {
+		if res.report != nil {
+			n += res.report.count(o)
+		}
+	}
+	return n
+}
+
+func (r *fleetReport) candidates() int {
+	n := 0
+	for _, res := range r.results {
+		if res.report != nil {
+			n += res.report.candidates
+		}
+	}
+	return n
+}
+
+// err returns an error naming the clusters whose cycle failed, if any.
+func (r *fleetReport) err() error {
+	failed := make([]string, 0)
+	for _, res := range r.results {
+		if res.err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", res.target, res.err))
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		if len(r.results) == 1 {
+			return r.results[0].err
+		}
+	}
+	return fmt.Errorf("update failed in %d of %d clusters: %s", len(failed), len(r.results), strings.Join(failed, "; "))
+}
+
+// Result is the outcome of an update cycle across clusters.
+type Result struct {
+	Start    time.Time
+	End      time.Time
+	Clusters []ClusterResult
+}
+
+// ClusterResult is the outcome of an update cycle in one cluster.
+type ClusterResult struct {
+	Cluster string
+	// Summary is nil if the cycle did not start, for example because the cluster's role could
+	// not be assumed.
+	Summary *RunSummary
+	Err     error
+}
+
+// Count returns the number of instances with the given outcome across clusters.
+func (r *Result) Count(o Outcome) int {
+	n := 0
+	for _, c := range r.Clusters {
+		if c.Summary == nil {
+			continue
+		}
+		for _, res := range c.Summary.Results {
+			if res.Outcome == o {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Paused reports whether the cycle was paused in any cluster, by the kill switch or by remote
+// configuration.
+func (r *Result) Paused() bool {
+	for _, c := range r.Clusters {
+		if c.Summary != nil && c.Summary.Paused {
+			return true
+		}
+	}
+	return false
+}
+
+// AwaitingApproval returns the IDs of the plans that expired before they were approved.
+func (r *Result) AwaitingApproval() []string {
+	var ids []string
+	for _, c := range r.Clusters {
+		if c.Summary != nil && c.Summary.AwaitingApproval != "" {
+			ids = append(ids, c.Summary.AwaitingApproval)
+		}
+	}
+	return ids
+}
+
+// result returns the exported form of the report.
+func (r *fleetReport) result() *Result {
+	res := &Result{
+		Start:    r.start,
+		End:      r.end,
+		Clusters: make([]ClusterResult, 0, len(r.results)),
+	}
+	for _, c := range r.results {
+		cr := ClusterResult{Cluster: c.target.cluster, Err: c.err}
+		if c.report != nil {
+			summary := c.report.summary()
+			cr.Summary = &summary
+		}
+		res.Clusters = append(res.Clusters, cr)
+	}
+	return res
+}
+
+// log writes a summary of the cycle across clusters to the log.
+func (r *fleetReport) log() {
+	log.Printf("Combined summary for %d clusters: %d candidates, %d updated, %d skipped, %d failed in %s",
+		len(r.results), r.candidates(), r.count(OutcomeUpdated), r.count(OutcomeSkipped), r.count(OutcomeFailed),
+		r.end.Sub(r.start).Round(time.Second))
+	for _, res := range r.results {
+		switch {
+		case res.report == nil:
+			log.Printf("Cluster %s: %v", res.target, res.err)
+		case res.err != nil:
+			log.Printf("Cluster %s: %d candidates, %d updated, %d skipped, %d failed; error: %v", res.target,
+				res.report.candidates, res.report.count(OutcomeUpdated), res.report.count(OutcomeSkipped), res.report.count(OutcomeFailed), res.err)
+		default:
+			log.Printf("Cluster %s: %d candidates, %d updated, %d skipped, %d failed", res.target,
+				res.report.candidates, res.report.count(OutcomeUpdated), res.report.count(OutcomeSkipped), res.report.count(OutcomeFailed))
+		}
+	}
+}
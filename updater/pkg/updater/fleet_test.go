@@ -0,0 +1,196 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClusterSelector(t *testing.T) {
+	s, err := parseClusterSelector(SplitList(" prod-a, prod-b ,"), "prod-*", "team = payments", "")
+	require.NoError(t, err)
+	assert.Equal(t, clusterSelector{
+		targets:  []target{{cluster: "prod-a"}, {cluster: "prod-b"}},
+		pattern:  "prod-*",
+		tagKey:   "team",
+		tagValue: "payments",
+	}, s)
+	assert.True(t, s.discovers())
+
+	s, err = parseClusterSelector(SplitList("prod-a"), "", "", "")
+	require.NoError(t, err)
+	assert.False(t, s.discovers())
+
+	s, err = parseClusterSelector(SplitList(""), "", "", ",us-east-1,staging")
+	require.NoError(t, err)
+	assert.Equal(t, []target{{region: "us-east-1", cluster: "staging"}}, s.targets)
+
+	for _, c := range []struct{ names, pattern, tag, targets string }{
+		{"", "", "", ""},
+		{"", "[", "", ""},
+		{"", "", "team", ""},
+		{"", "", "=payments", ""},
+		{"", "", "", "us-east-1,staging"},
+	} {
+		_, err := parseClusterSelector(SplitList(c.names), c.pattern, c.tag, c.targets)
+		assert.Error(t, err, "%+v", c)
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	targets, err := parseTargets(" arn:aws:iam::111122223333:role/updater , us-west-2 , prod ;" +
+		"arn:aws:iam::444455556666:role/updater,eu-west-1,arn:aws:ecs:eu-west-1:444455556666:cluster/prod;" +
+		",,local;")
+	require.NoError(t, err)
+	assert.Equal(t, []target{
+		{
+			roleARN: "arn:aws:iam::111122223333:role/updater",
+			region:  "us-west-2",
+			cluster: "arn:aws:ecs:us-west-2:111122223333:cluster/prod",
+		},
+		{
+			roleARN: "arn:aws:iam::444455556666:role/updater",
+			region:  "eu-west-1",
+			cluster: "arn:aws:ecs:eu-west-1:444455556666:cluster/prod",
+		},
+		{cluster: "local"},
+	}, targets)
+
+	for _, value := range []string{
+		"arn:aws:iam::111122223333:role/updater,us-west-2",
+		"arn:aws:iam::111122223333:role/updater,us-west-2,",
+		"updater,us-west-2,prod",
+		"arn:aws:iam::111122223333:user/updater,us-west-2,prod",
+	} {
+		_, err := parseTargets(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestTargetString(t *testing.T) {
+	cases := []struct {
+		target   target
+		expected string
+	}{
+		{target: target{cluster: "prod"}, expected: "prod"},
+		{target: target{region: "eu-west-1", cluster: "prod"}, expected: "prod (region eu-west-1)"},
+		{target: target{roleARN: "arn:aws:iam::111122223333:role/updater", cluster: "prod"}, expected: "prod (account 111122223333)"},
+		{
+			target:   target{roleARN: "arn:aws:iam::111122223333:role/updater", region: "us-west-2", cluster: "arn:aws:ecs:us-west-2:111122223333:cluster/prod"},
+			expected: "arn:aws:ecs:us-west-2:111122223333:cluster/prod",
+		},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, tc.target.String())
+	}
+}
+
+func TestFleetClusters(t *testing.T) {
+	arns := make([]string, 0)
+	for i := 0; i < 150; i++ {
+		arns = append(arns, fmt.Sprintf("arn:aws:ecs:us-west-2:123456789012:cluster/prod-%03d", i))
+	}
+	arns = append(arns, "arn:aws:ecs:us-west-2:123456789012:cluster/dev-000")
+	describeCalls := 0
+	mockECS := MockECS{
+		ListClustersFn: func(input *ecs.ListClustersInput) (*ecs.ListClustersOutput, error) {
+			if input.NextToken == nil {
+				return &ecs.ListClustersOutput{ClusterArns: aws.StringSlice(arns[:100]), NextToken: aws.String("next")}, nil
+			}
+			assert.Equal(t, "next", aws.StringValue(input.NextToken))
+			return &ecs.ListClustersOutput{ClusterArns: aws.StringSlice(arns[100:])}, nil
+		},
+		DescribeClustersFn: func(input *ecs.DescribeClustersInput) (*ecs.DescribeClustersOutput, error) {
+			describeCalls++
+			assert.LessOrEqual(t, len(input.Clusters), maxDescribeClusters)
+			assert.Equal(t, []string{ecs.ClusterFieldTags}, aws.StringValueSlice(input.Include))
+			output := &ecs.DescribeClustersOutput{}
+			for _, arn := range aws.StringValueSlice(input.Clusters) {
+				name := arn[len("arn:aws:ecs:us-west-2:123456789012:cluster/"):]
+				cluster := &ecs.Cluster{ClusterArn: aws.String(arn), ClusterName: aws.String(name)}
+				if name == "prod-007" || name == "prod-120" || name == "dev-000" {
+					cluster.Tags = []*ecs.Tag{{Key: aws.String("updater"), Value: aws.String("enabled")}}
+				}
+				output.Clusters = append(output.Clusters, cluster)
+			}
+			return output, nil
+		},
+	}
+
+	selector, err := parseClusterSelector(SplitList("explicit,prod-120"), "prod-*", "updater=enabled", "")
+	require.NoError(t, err)
+	f := newFleet(mockECS, selector, 1, nil)
+	clusters, err := f.clusters()
+	require.NoError(t, err)
+	assert.Equal(t, []target{{cluster: "explicit"}, {cluster: "prod-120"}, {cluster: "prod-007"}}, clusters)
+	assert.Equal(t, 2, describeCalls)
+
+	selector, err = parseClusterSelector(SplitList("explicit"), "", "", "")
+	require.NoError(t, err)
+	f = newFleet(MockECS{}, selector, 1, nil)
+	clusters, err = f.clusters()
+	require.NoError(t, err)
+	assert.Equal(t, []target{{cluster: "explicit"}}, clusters)
+}
+
+func TestFleetClustersErr(t *testing.T) {
+	selector, err := parseClusterSelector(SplitList(""), "prod-*", "", "")
+	require.NoError(t, err)
+	f := newFleet(MockECS{
+		ListClustersFn: func(input *ecs.ListClustersInput) (*ecs.ListClustersOutput, error) {
+			return nil, errors.New("failed to list clusters")
+		},
+	}, selector, 1, nil)
+	_, err = f.clusters()
+	assert.Error(t, err)
+}
+
+func TestFleetRun(t *testing.T) {
+	selector, err := parseClusterSelector(SplitList("cluster-a,cluster-b,cluster-c"), "", "", "")
+	require.NoError(t, err)
+	// The maintenance schedule is closed so each cycle ends without calling AWS.
+	closed, err := parseSchedule("", time.Now().UTC().Format(dateLayout), "")
+	require.NoError(t, err)
+	var mu sync.Mutex
+	created := make(map[string]int)
+	f := newFleet(MockECS{}, selector, 2, func(tgt target) (*clusterUpdater, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		created[tgt.cluster]++
+		if tgt.cluster == "cluster-b" {
+			return nil, errors.New("no credentials")
+		}
+		return &clusterUpdater{cluster: tgt.cluster, maintenance: closed}, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		report, err := f.run(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "update failed in 1 of 3 clusters")
+		assert.Contains(t, err.Error(), "cluster-b: no credentials")
+		require.Len(t, report.results, 3)
+		assert.Equal(t, "cluster-a", report.results[0].target.cluster)
+		assert.NotNil(t, report.results[0].report)
+		assert.NoError(t, report.results[0].err)
+		assert.Nil(t, report.results[1].report)
+		assert.NotNil(t, report.results[2].report)
+	}
+	assert.Equal(t, map[string]int{"cluster-a": 1, "cluster-b": 2, "cluster-c": 1}, created, "updaters should be reused across cycles")
+}
+
+func TestFleetReportErr(t *testing.T) {
+	failure := errors.New("failed to list container instances")
+	single := &fleetReport{results: []clusterResult{{target: target{cluster: "cluster-a"}, err: failure}}}
+	assert.Equal(t, failure, single.err(), "a single cluster's error should be returned unchanged")
+
+	ok := &fleetReport{results: []clusterResult{{target: target{cluster: "cluster-a"}}, {target: target{cluster: "cluster-b"}}}}
+	assert.NoError(t, ok.err())
+}
@@ -0,0 +1,168 @@
+package updater
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// ECS attributes recording the update history of a container instance. They can be used in task
+// placement constraints, e.g. "attribute:bottlerocket.updater.last-result == updated".
+const (
+	attributePrefix = "bottlerocket.updater."
+	// attributeLastRun is the start time of the last run that updated or failed to update the
+	// instance, which identifies the run in logs and reports.
+	attributeLastRun = attributePrefix + "last-run"
+	// attributeLastResult is the outcome of the last update attempt: updated or failed.
+	attributeLastResult = attributePrefix + "last-result"
+	// attributeLastUpdated is the start time of the run that last updated the instance.
+	attributeLastUpdated = attributePrefix + "last-updated"
+	// attributePreviousVersion is the Bottlerocket version the instance ran before its last
+	// successful update.
+	attributePreviousVersion = attributePrefix + "previous-version"
+	// attributeFailureCount is the number of consecutive failed update attempts.
+	attributeFailureCount = attributePrefix + "failure-count"
+	// attributeQuarantined marks an instance that failed too many consecutive updates, with the
+	// time it was quarantined as value. The updater skips the instance until an operator deletes
+	// the attribute.
+	attributeQuarantined = attributePrefix + "quarantined"
+	// attributeDrained marks an instance the updater set to DRAINING, with the time the drain
+	// started as value. It is deleted when the updater returns the instance to ACTIVE, so that
+	// the reactivate command only touches instances the updater drained itself.
+	attributeDrained = attributePrefix + "drained"
+
+	// historyTimeFormat is RFC 3339 in UTC, which only uses characters allowed in attribute values.
+	historyTimeFormat = "2006-01-02T15:04:05Z"
+)
+
+// attributeValue returns the value of the named attribute.
+func attributeValue(attrs []*ecs.Attribute, name string) (string, bool) {
+	for _, attr := range attrs {
+		if aws.StringValue(attr.Name) == name {
+			return aws.StringValue(attr.Value), true
+		}
+	}
+	return "", false
+}
+
+// failureCount returns the number of consecutive failures recorded on a container instance.
+func failureCount(attrs []*ecs.Attribute) int {
+	value, ok := attributeValue(attrs, attributeFailureCount)
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("Ignoring invalid %s attribute %q", attributeFailureCount, value)
+		return 0
+	}
+	return n
+}
+
+// historyAttributes returns the attributes recording the result of an update attempt, or nil if
+// the result is not an update attempt. A failed instance is quarantined once it has failed
+// quarantineAfter consecutive times; zero disables quarantine. An instance that reached the
+// threshold but is no longer quarantined was cleared by an operator, and its count starts over.
+func historyAttributes(res instanceResult, runStart time.Time, quarantineAfter int) map[string]string {
+	runTime := runStart.UTC().Format(historyTimeFormat)
+	attrs := map[string]string{
+		attributeLastRun:    runTime,
+		attributeLastResult: string(res.outcome),
+	}
+	switch res.outcome {
+	case OutcomeUpdated:
+		attrs[attributeLastUpdated] = runTime
+		attrs[attributeFailureCount] = "0"
+		if res.instance.bottlerocketVersion != "" {
+			attrs[attributePreviousVersion] = res.instance.bottlerocketVersion
+		}
+	case OutcomeFailed:
+		failures := res.instance.failureCount
+		if quarantineAfter > 0 && failures >= quarantineAfter && res.instance.quarantinedSince == "" {
+			failures = 0
+		}
+		failures++
+		attrs[attributeFailureCount] = strconv.Itoa(failures)
+		if quarantineAfter > 0 && failures >= quarantineAfter {
+			attrs[attributeQuarantined] = runTime
+		}
+	default:
+		return nil
+	}
+	return attrs
+}
+
+// writeHistory records the result of an update attempt on the container instance and
+// quarantines the instance if it failed too many times. Skipped instances are left untouched.
+// Failures are logged and do not change the outcome. The history is written even if ctx is
+// cancelled.
+func (u *clusterUpdater) writeHistory(ctx aws.Context, res instanceResult, runStart time.Time) {
+	ctx = context.WithoutCancel(ctx)
+	attrs := historyAttributes(res, runStart, u.quarantineAfter)
+	if attrs == nil {
+		return
+	}
+	if _, ok := attrs[attributeQuarantined]; ok {
+		log.Printf("QUARANTINE: instance %q failed %s consecutive updates and is quarantined; it will be skipped until the %s attribute is deleted from container instance %q",
+			res.instance.instanceID, attrs[attributeFailureCount], attributeQuarantined, res.instance.containerInstanceID)
+		u.events.publish(ctx, EventQuarantined, res.instance, res.reason)
+	}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	input := &ecs.PutAttributesInput{Cluster: aws.String(u.cluster)}
+	for _, name := range names {
+		input.Attributes = append(input.Attributes, &ecs.Attribute{
+			Name:       aws.String(name),
+			Value:      aws.String(attrs[name]),
+			TargetType: aws.String(ecs.TargetTypeContainerInstance),
+			TargetId:   aws.String(res.instance.containerInstanceID),
+		})
+	}
+	if _, err := u.ecs.PutAttributesWithContext(ctx, input); err != nil {
+		log.Printf("Failed to record update history on container instance %q: %v", res.instance.containerInstanceID, err)
+	}
+}
+
+// markDrained records on the container instance that the updater drained it. Failures are
+// logged: the marker only restricts which instances the reactivate command returns to service.
+func (u *clusterUpdater) markDrained(ctx aws.Context, inst instance, start time.Time) {
+	_, err := u.ecs.PutAttributesWithContext(context.WithoutCancel(ctx), &ecs.PutAttributesInput{
+		Cluster: aws.String(u.cluster),
+		Attributes: []*ecs.Attribute{{
+			Name:       aws.String(attributeDrained),
+			Value:      aws.String(start.UTC().Format(historyTimeFormat)),
+			TargetType: aws.String(ecs.TargetTypeContainerInstance),
+			TargetId:   aws.String(inst.containerInstanceID),
+		}},
+	})
+	if err != nil {
+		log.Printf("Failed to mark container instance %q as drained by the updater: %v", inst.containerInstanceID, err)
+	}
+}
+
+// clearDrained deletes the drain marker from the container instances, at most
+// maxStateUpdateInstances at a time. Failures are logged.
+func (u *clusterUpdater) clearDrained(ctx aws.Context, containerInstances []string) {
+	if len(containerInstances) == 0 {
+		return
+	}
+	input := &ecs.DeleteAttributesInput{Cluster: aws.String(u.cluster)}
+	for _, containerInstance := range containerInstances {
+		input.Attributes = append(input.Attributes, &ecs.Attribute{
+			Name:       aws.String(attributeDrained),
+			TargetType: aws.String(ecs.TargetTypeContainerInstance),
+			TargetId:   aws.String(containerInstance),
+		})
+	}
+	if _, err := u.ecs.DeleteAttributesWithContext(context.WithoutCancel(ctx), input); err != nil {
+		log.Printf("Failed to clear the drain marker of container instances %q: %v", containerInstances, err)
+	}
+}
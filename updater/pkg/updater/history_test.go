@@ -0,0 +1,176 @@
+package updater
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailureCount(t *testing.T) {
+	cases := []struct {
+		name     string
+		attrs    []*ecs.Attribute
+		expected int
+	}{
+		{name: "missing", attrs: []*ecs.Attribute{{Name: aws.String("bottlerocket.variant")}}, expected: 0},
+		{name: "set", attrs: []*ecs.Attribute{{Name: aws.String(attributeFailureCount), Value: aws.String("3")}}, expected: 3},
+		{name: "invalid", attrs: []*ecs.Attribute{{Name: aws.String(attributeFailureCount), Value: aws.String("many")}}, expected: 0},
+		{name: "negative", attrs: []*ecs.Attribute{{Name: aws.String(attributeFailureCount), Value: aws.String("-1")}}, expected: 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, failureCount(tc.attrs))
+		})
+	}
+}
+
+func TestHistoryAttributes(t *testing.T) {
+	start := time.Date(2021, 3, 4, 5, 6, 7, 0, time.FixedZone("PST", -8*60*60))
+	inst := instance{
+		instanceID:          "inst-id-1",
+		containerInstanceID: "cont-inst-1",
+		bottlerocketVersion: "1.0.5",
+		targetVersion:       "1.0.6",
+		failureCount:        2,
+	}
+
+	assert.Equal(t, map[string]string{
+		"bottlerocket.updater.last-run":         "2021-03-04T13:06:07Z",
+		"bottlerocket.updater.last-result":      "updated",
+		"bottlerocket.updater.last-updated":     "2021-03-04T13:06:07Z",
+		"bottlerocket.updater.previous-version": "1.0.5",
+		"bottlerocket.updater.failure-count":    "0",
+	}, historyAttributes(instanceResult{instance: inst, outcome: OutcomeUpdated}, start, 0))
+
+	assert.Equal(t, map[string]string{
+		"bottlerocket.updater.last-run":      "2021-03-04T13:06:07Z",
+		"bottlerocket.updater.last-result":   "failed",
+		"bottlerocket.updater.failure-count": "3",
+	}, historyAttributes(instanceResult{instance: inst, outcome: OutcomeFailed, phase: PhaseDrain}, start, 4))
+
+	assert.Nil(t, historyAttributes(instanceResult{instance: inst, outcome: OutcomeSkipped, phase: PhaseEligibility}, start, 3))
+}
+
+func TestHistoryAttributesQuarantine(t *testing.T) {
+	start := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	cases := []struct {
+		name             string
+		previous         int
+		quarantinedSince string
+		quarantineAfter  int
+		count            string
+		quarantined      bool
+	}{
+		{name: "below threshold", previous: 1, quarantineAfter: 3, count: "2", quarantined: false},
+		{name: "reaches threshold", previous: 2, quarantineAfter: 3, count: "3", quarantined: true},
+		{name: "fails again while quarantined", previous: 3, quarantinedSince: "2021-03-03T05:06:07Z", quarantineAfter: 3, count: "4", quarantined: true},
+		{name: "fails again after operator retry", previous: 3, quarantineAfter: 3, count: "1", quarantined: false},
+		{name: "disabled", previous: 10, quarantineAfter: 0, count: "11", quarantined: false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			inst := instance{failureCount: tc.previous, quarantinedSince: tc.quarantinedSince}
+			attrs := historyAttributes(instanceResult{instance: inst, outcome: OutcomeFailed, phase: PhaseUpdate}, start, tc.quarantineAfter)
+			assert.Equal(t, tc.count, attrs[attributeFailureCount])
+			value, ok := attrs[attributeQuarantined]
+			assert.Equal(t, tc.quarantined, ok)
+			if tc.quarantined {
+				assert.Equal(t, "2021-03-04T05:06:07Z", value)
+			}
+		})
+	}
+
+	// A successful update never quarantines an instance.
+	res := instanceResult{instance: instance{failureCount: 5}, outcome: OutcomeUpdated}
+	assert.NotContains(t, historyAttributes(res, start, 3), attributeQuarantined)
+}
+
+func TestWriteHistoryQuarantine(t *testing.T) {
+	inputs := []*ecs.PutAttributesInput{}
+	mockECS := MockECS{
+		PutAttributesFn: func(input *ecs.PutAttributesInput) (*ecs.PutAttributesOutput, error) {
+			inputs = append(inputs, input)
+			return &ecs.PutAttributesOutput{}, nil
+		},
+	}
+	published := []*eventbridge.PutEventsInput{}
+	mockEvents := MockEvents{
+		PutEventsFn: func(input *eventbridge.PutEventsInput) (*eventbridge.PutEventsOutput, error) {
+			published = append(published, input)
+			return &eventbridge.PutEventsOutput{}, nil
+		},
+	}
+	u := clusterUpdater{
+		cluster:         "test-cluster",
+		quarantineAfter: 2,
+		ecs:             mockECS,
+		events:          &eventPublisher{events: mockEvents, busName: "bus", cluster: "test-cluster"},
+	}
+	report := newRunReport("test-cluster")
+	inst := instance{instanceID: "inst-id-1", containerInstanceID: "cont-inst-1", failureCount: 1}
+
+	u.record(aws.BackgroundContext(), report, inst, OutcomeFailed, PhaseDrain, "drain timed out")
+	require.Len(t, inputs, 1)
+	quarantined := false
+	for _, attr := range inputs[0].Attributes {
+		if aws.StringValue(attr.Name) == attributeQuarantined {
+			quarantined = true
+		}
+	}
+	assert.True(t, quarantined)
+	require.Len(t, published, 1)
+	assert.Equal(t, string(EventQuarantined), aws.StringValue(published[0].Entries[0].DetailType))
+}
+
+func TestWriteHistory(t *testing.T) {
+	inputs := []*ecs.PutAttributesInput{}
+	mockECS := MockECS{
+		PutAttributesFn: func(input *ecs.PutAttributesInput) (*ecs.PutAttributesOutput, error) {
+			inputs = append(inputs, input)
+			return &ecs.PutAttributesOutput{}, nil
+		},
+	}
+	u := clusterUpdater{cluster: "test-cluster", ecs: mockECS}
+	report := newRunReport("test-cluster")
+	inst := instance{instanceID: "inst-id-1", containerInstanceID: "cont-inst-1", bottlerocketVersion: "1.0.5"}
+
+	u.record(aws.BackgroundContext(), report, inst, OutcomeSkipped, PhaseEligibility, skipNonServiceTask)
+	assert.Empty(t, inputs, "skipped instances must not be written")
+
+	u.record(aws.BackgroundContext(), report, inst, OutcomeUpdated, "", "")
+	require.Len(t, inputs, 1)
+	assert.Equal(t, "test-cluster", aws.StringValue(inputs[0].Cluster))
+	names := []string{}
+	for _, attr := range inputs[0].Attributes {
+		assert.Equal(t, "cont-inst-1", aws.StringValue(attr.TargetId))
+		assert.Equal(t, ecs.TargetTypeContainerInstance, aws.StringValue(attr.TargetType))
+		names = append(names, aws.StringValue(attr.Name))
+	}
+	assert.Equal(t, []string{
+		"bottlerocket.updater.failure-count",
+		"bottlerocket.updater.last-result",
+		"bottlerocket.updater.last-run",
+		"bottlerocket.updater.last-updated",
+		"bottlerocket.updater.previous-version",
+	}, names)
+}
+
+func TestWriteHistoryErr(t *testing.T) {
+	mockECS := MockECS{
+		PutAttributesFn: func(input *ecs.PutAttributesInput) (*ecs.PutAttributesOutput, error) {
+			return nil, errors.New("AccessDeniedException")
+		},
+	}
+	u := clusterUpdater{cluster: "test-cluster", ecs: mockECS}
+	report := newRunReport("test-cluster")
+
+	res := u.record(aws.BackgroundContext(), report, instance{instanceID: "inst-id-1"}, OutcomeFailed, PhaseDrain, "drain timed out")
+	assert.Equal(t, OutcomeFailed, res.outcome, "history failures must not change the outcome")
+	assert.Equal(t, 1, report.count(OutcomeFailed))
+}
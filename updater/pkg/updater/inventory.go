@@ -0,0 +1,311 @@
+package updater
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// The formats the inventory report can be written in.
+const (
+	FormatJSON     = "json"
+	FormatCSV      = "csv"
+	FormatMarkdown = "markdown"
+)
+
+// ParseReportFormat returns the inventory format named by value.
+func ParseReportFormat(value string) (string, error) {
+	switch value {
+	case FormatJSON, FormatCSV, FormatMarkdown:
+		return value, nil
+	default:
+		return "", fmt.Errorf("unknown report format %q, expected %s, %s or %s", value, FormatJSON, FormatCSV, FormatMarkdown)
+	}
+}
+
+// InventoryInstance is the version and update state of one Bottlerocket instance.
+type InventoryInstance struct {
+	Cluster              string `json:"cluster"`
+	InstanceID           string `json:"instanceId"`
+	ContainerInstanceARN string `json:"containerInstanceArn"`
+	Status               string `json:"status"`
+	Variant              string `json:"variant"`
+	Version              string `json:"version"`
+	UpdateState          string `json:"updateState"`
+	// AvailableVersions lists the versions newer than Version the instance can be updated to,
+	// newest first.
+	AvailableVersions []string `json:"availableVersions"`
+	ReleasesBehind    int      `json:"releasesBehind"`
+	// LastUpdated is when the updater last updated the instance, if it ever did.
+	LastUpdated string `json:"lastUpdated,omitempty"`
+	// Managed is false for instances the updater leaves alone because they are opted out or not
+	// selected.
+	Managed bool `json:"managed"`
+	// Flagged is set for instances more releases behind than the threshold, and for instances
+	// whose version could not be checked.
+	Flagged bool `json:"flagged"`
+	// Error is why the version could not be checked, if it could not.
+	Error string `json:"error,omitempty"`
+}
+
+// InventorySummary aggregates the instances of the inventory.
+type InventorySummary struct {
+	Instances         int            `json:"instances"`
+	Compliant         int            `json:"compliant"`
+	Flagged           int            `json:"flagged"`
+	MaxReleasesBehind int            `json:"maxReleasesBehind"`
+	Versions          map[string]int `json:"versions"`
+	Variants          map[string]int `json:"variants"`
+}
+
+// Inventory is the version inventory and compliance report of the fleet.
+type Inventory struct {
+	Generated time.Time `json:"generated"`
+	// Threshold is the number of releases an instance may be behind before it is flagged.
+	Threshold int                 `json:"threshold"`
+	Summary   InventorySummary    `json:"summary"`
+	Instances []InventoryInstance `json:"instances"`
+}
+
+func newInventory(instances []InventoryInstance, threshold int, now time.Time) *Inventory {
+	inv := &Inventory{
+		Generated: now.UTC(),
+		Threshold: threshold,
+		Summary: InventorySummary{
+			Versions: make(map[string]int),
+			Variants: make(map[string]int),
+		},
+		Instances: instances,
+	}
+	for i := range inv.Instances {
+		inst := &inv.Instances[i]
+		inst.Flagged = inst.Error != "" || inst.ReleasesBehind > threshold
+		inv.Summary.Instances++
+		if inst.Flagged {
+			inv.Summary.Flagged++
+		} else {
+			inv.Summary.Compliant++
+		}
+		if inst.ReleasesBehind > inv.Summary.MaxReleasesBehind {
+			inv.Summary.MaxReleasesBehind = inst.ReleasesBehind
+		}
+		if inst.Version != "" {
+			inv.Summary.Versions[inst.Version]++
+		}
+		inv.Summary.Variants[inst.Variant]++
+	}
+	return inv
+}
+
+// inventory runs the check document on every active and draining Bottlerocket instance in the
+// cluster, including those the updater does not manage, and returns their versions.
+func (u *clusterUpdater) inventory() ([]InventoryInstance, error) {
+	arns := make([]*string, 0)
+	for _, status := range []string{ecs.ContainerInstanceStatusActive, ecs.ContainerInstanceStatusDraining} {
+		listed, err := u.listContainerInstancesWithStatus(aws.BackgroundContext(), status)
+		if err != nil {
+			return nil, err
+		}
+		arns = append(arns, listed...)
+	}
+	if len(arns) == 0 {
+		return nil, nil
+	}
+	instances, err := u.describeBottlerocketInstances(aws.BackgroundContext(), arns)
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) == 0 {
+		return nil, nil
+	}
+	outputs, err := u.checkUpdates(aws.BackgroundContext(), instances)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := make([]InventoryInstance, 0, len(instances))
+	for _, inst := range instances {
+		entry := InventoryInstance{
+			Cluster:              u.cluster,
+			InstanceID:           inst.instanceID,
+			ContainerInstanceARN: inst.containerInstanceID,
+			Status:               inst.status,
+			Variant:              inst.variant,
+			AvailableVersions:    make([]string, 0),
+			LastUpdated:          inst.attributes[attributeLastUpdated],
+			Managed:              u.manages(inst, now),
+		}
+		output, err := parseCommandOutput(outputs[inst.instanceID])
+		if err != nil {
+			entry.Error = err.Error()
+			result = append(result, entry)
+			continue
+		}
+		entry.Version = output.ActivePartition.Image.Version
+		entry.UpdateState = output.UpdateState
+		entry.AvailableVersions = newerVersions(entry.Version, append(output.AvailableUpdates, output.ChosenUpdate.Version))
+		entry.ReleasesBehind = len(entry.AvailableVersions)
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+// newerVersions returns the distinct versions newer than current, newest first.
+func newerVersions(current string, versions []string) []string {
+	seen := make(map[string]bool)
+	newer := make([]string, 0)
+	for _, v := range versions {
+		if v == "" || seen[v] || compareVersions(v, current) <= 0 {
+			continue
+		}
+		seen[v] = true
+		newer = append(newer, v)
+	}
+	sort.Slice(newer, func(i, j int) bool { return compareVersions(newer[i], newer[j]) > 0 })
+	return newer
+}
+
+// compareVersions compares Bottlerocket versions such as "1.0.5" or "v1.1.0" numerically by
+// component, returning -1, 0 or 1. Components that are not numbers compare as strings.
+func compareVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y string
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		xn, xerr := strconv.Atoi(x)
+		yn, yerr := strconv.Atoi(y)
+		switch {
+		case x == y:
+			continue
+		case xerr == nil && yerr == nil && xn != yn:
+			if xn < yn {
+				return -1
+			}
+			return 1
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// WriteInventory writes the inventory in the given format to the file at path, or to w if path
+// is empty.
+func WriteInventory(inv *Inventory, format, path string, w io.Writer) error {
+	if path == "" {
+		return inv.write(w, format)
+	}
+	buf := &bytes.Buffer{}
+	if err := inv.write(buf, format); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// write writes the inventory in the given format.
+func (inv *Inventory) write(w io.Writer, format string) error {
+	switch format {
+	case FormatCSV:
+		return inv.writeCSV(w)
+	case FormatMarkdown:
+		return inv.writeMarkdown(w)
+	default:
+		data, err := json.MarshalIndent(inv, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	}
+}
+
+// writeCSV writes one row per instance; the aggregate view is only in the JSON and Markdown
+// formats.
+func (inv *Inventory) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"cluster", "instance_id", "container_instance_arn", "status", "variant", "version",
+		"update_state", "available_versions", "releases_behind", "last_updated", "managed", "flagged", "error"})
+	for _, inst := range inv.Instances {
+		cw.Write([]string{inst.Cluster, inst.InstanceID, inst.ContainerInstanceARN, inst.Status, inst.Variant, inst.Version,
+			inst.UpdateState, strings.Join(inst.AvailableVersions, " "), strconv.Itoa(inst.ReleasesBehind), inst.LastUpdated,
+			strconv.FormatBool(inst.Managed), strconv.FormatBool(inst.Flagged), inst.Error})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (inv *Inventory) writeMarkdown(w io.Writer) error {
+	b := &strings.Builder{}
+	s := inv.Summary
+	fmt.Fprintf(b, "# Bottlerocket inventory\n\n")
+	fmt.Fprintf(b, "Generated %s. Instances more than %d releases behind the newest available version are flagged.\n\n",
+		inv.Generated.Format(time.RFC3339), inv.Threshold)
+	fmt.Fprintf(b, "| Instances | Compliant | Flagged | Most releases behind |\n|---|---|---|---|\n")
+	fmt.Fprintf(b, "| %d | %d | %d | %d |\n\n", s.Instances, s.Compliant, s.Flagged, s.MaxReleasesBehind)
+	fmt.Fprintf(b, "| Version | Instances |\n|---|---|\n")
+	versions := make([]string, 0, len(s.Versions))
+	for v := range s.Versions {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) > 0 })
+	for _, v := range versions {
+		fmt.Fprintf(b, "| %s | %d |\n", v, s.Versions[v])
+	}
+	fmt.Fprintf(b, "\n| Variant | Instances |\n|---|---|\n")
+	variants := make([]string, 0, len(s.Variants))
+	for v := range s.Variants {
+		variants = append(variants, v)
+	}
+	sort.Strings(variants)
+	for _, v := range variants {
+		fmt.Fprintf(b, "| %s | %d |\n", markdownCell(v), s.Variants[v])
+	}
+	fmt.Fprintf(b, "\n| Cluster | Instance | Status | Variant | Version | Update state | Available versions | Releases behind | Last updated | Managed | Flagged |\n")
+	fmt.Fprintf(b, "|---|---|---|---|---|---|---|---|---|---|---|\n")
+	for _, inst := range inv.Instances {
+		flagged := "no"
+		if inst.Flagged {
+			flagged = "**yes**"
+			if inst.Error != "" {
+				flagged += ": " + inst.Error
+			}
+		}
+		managed := "yes"
+		if !inst.Managed {
+			managed = "no"
+		}
+		fmt.Fprintf(b, "| %s | %s | %s | %s | %s | %s | %s | %d | %s | %s | %s |\n",
+			markdownCell(inst.Cluster), inst.InstanceID, inst.Status, markdownCell(inst.Variant), markdownCell(inst.Version),
+			markdownCell(inst.UpdateState), markdownCell(strings.Join(inst.AvailableVersions, ", ")), inst.ReleasesBehind,
+			markdownCell(inst.LastUpdated), managed, markdownCell(flagged))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownCell escapes a value for a Markdown table cell, showing empty values as a dash.
+func markdownCell(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(value)
+}
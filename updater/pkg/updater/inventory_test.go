@@ -0,0 +1,139 @@
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0.5", "1.0.5", 0},
+		{"1.0.5", "v1.0.5", 0},
+		{"1.0.5", "1.0.6", -1},
+		{"1.0.10", "1.0.9", 1},
+		{"1.1.0", "1.0.9", 1},
+		{"1.1", "1.1.0", -1},
+		{"", "1.0.0", -1},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, compareVersions(tc.a, tc.b), "%s vs %s", tc.a, tc.b)
+	}
+}
+
+func TestNewerVersions(t *testing.T) {
+	assert.Equal(t, []string{"1.1.0", "1.0.10", "1.0.6"},
+		newerVersions("1.0.5", []string{"1.0.6", "1.0.4", "1.0.10", "1.1.0", "1.0.5", "1.1.0", ""}))
+	assert.Empty(t, newerVersions("1.1.0", []string{"1.0.6", "1.1.0"}))
+}
+
+func TestInventory(t *testing.T) {
+	activated := []string{}
+	mockSSM := commandTestSSM(t)
+	mockSSM.GetCommandInvocationFn = func(input *ssm.GetCommandInvocationInput) (*ssm.GetCommandInvocationOutput, error) {
+		output := `{"update_state": "Idle", "active_partition": {"image": {"version": "1.0.6"}}, "available_updates": ["1.0.6", "1.0.5"]}`
+		if aws.StringValue(input.InstanceId) == "inst-id-1" {
+			output = `{"update_state": "Available", "active_partition": {"image": {"version": "1.0.4"}}, "available_updates": ["1.0.6", "1.0.5", "1.0.4"], "chosen_update": {"version": "1.0.6"}}`
+		}
+		return &ssm.GetCommandInvocationOutput{StandardOutputContent: aws.String(output)}, nil
+	}
+	u := &clusterUpdater{cluster: "test-cluster", checkDocument: "check-doc", ecs: commandTestECS(t, &activated), ssm: mockSSM}
+	u.selection, _ = parseSelection("", "attribute:bottlerocket.updater.failure-count=2")
+
+	instances, err := u.inventory()
+	require.NoError(t, err)
+	assert.Equal(t, []InventoryInstance{
+		{
+			Cluster:              "test-cluster",
+			InstanceID:           "inst-id-1",
+			ContainerInstanceARN: "cont-inst-id-1",
+			Status:               "ACTIVE",
+			Variant:              "aws-ecs-1",
+			Version:              "1.0.4",
+			UpdateState:          "Available",
+			AvailableVersions:    []string{"1.0.6", "1.0.5"},
+			ReleasesBehind:       2,
+			Managed:              true,
+		},
+		{
+			Cluster:              "test-cluster",
+			InstanceID:           "inst-id-2",
+			ContainerInstanceARN: "cont-inst-id-2",
+			Status:               "DRAINING",
+			Variant:              "aws-ecs-1",
+			Version:              "1.0.6",
+			UpdateState:          "Idle",
+			AvailableVersions:    []string{},
+			Managed:              false,
+		},
+	}, instances, "instances the updater does not manage are reported too")
+}
+
+func testInventory() *Inventory {
+	return newInventory([]InventoryInstance{
+		{Cluster: "test-cluster", InstanceID: "inst-id-1", Status: "ACTIVE", Variant: "aws-ecs-1", Version: "1.0.4",
+			UpdateState: "Available", AvailableVersions: []string{"1.0.6", "1.0.5"}, ReleasesBehind: 2, Managed: true},
+		{Cluster: "test-cluster", InstanceID: "inst-id-2", Status: "ACTIVE", Variant: "aws-ecs-1", Version: "1.0.6",
+			UpdateState: "Idle", AvailableVersions: []string{}, LastUpdated: "2021-03-04T13:06:07Z", Managed: true},
+		{Cluster: "test-cluster", InstanceID: "inst-id-3", Status: "ACTIVE", Variant: "aws-ecs-1-nvidia",
+			AvailableVersions: []string{}, Error: "command output is empty"},
+	}, 1, time.Date(2021, 3, 5, 8, 0, 0, 0, time.UTC))
+}
+
+func TestNewInventory(t *testing.T) {
+	inv := testInventory()
+	assert.True(t, inv.Instances[0].Flagged, "more releases behind than the threshold")
+	assert.False(t, inv.Instances[1].Flagged)
+	assert.True(t, inv.Instances[2].Flagged, "instances that could not be checked are flagged")
+	assert.Equal(t, InventorySummary{
+		Instances:         3,
+		Compliant:         1,
+		Flagged:           2,
+		MaxReleasesBehind: 2,
+		Versions:          map[string]int{"1.0.4": 1, "1.0.6": 1},
+		Variants:          map[string]int{"aws-ecs-1": 2, "aws-ecs-1-nvidia": 1},
+	}, inv.Summary)
+}
+
+func TestWriteInventory(t *testing.T) {
+	inv := testInventory()
+
+	out := &bytes.Buffer{}
+	require.NoError(t, WriteInventory(inv, FormatJSON, "", out))
+	decoded := &Inventory{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), decoded))
+	assert.Equal(t, inv, decoded)
+
+	out.Reset()
+	require.NoError(t, WriteInventory(inv, FormatCSV, "", out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "cluster,instance_id,container_instance_arn,status,variant,version,update_state,available_versions,releases_behind,last_updated,managed,flagged,error", lines[0])
+	assert.Equal(t, "test-cluster,inst-id-1,,ACTIVE,aws-ecs-1,1.0.4,Available,1.0.6 1.0.5,2,,true,true,", lines[1])
+
+	out.Reset()
+	require.NoError(t, WriteInventory(inv, FormatMarkdown, "", out))
+	assert.Contains(t, out.String(), "| 3 | 1 | 2 | 2 |\n")
+	assert.Contains(t, out.String(), "| test-cluster | inst-id-2 | ACTIVE | aws-ecs-1 | 1.0.6 | Idle | - | 0 | 2021-03-04T13:06:07Z | yes | no |\n")
+	assert.Contains(t, out.String(), "| test-cluster | inst-id-3 | ACTIVE | aws-ecs-1-nvidia | - | - | - | 0 | - | no | **yes**: command output is empty |\n")
+}
+
+func TestParseReportFormat(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatCSV, FormatMarkdown} {
+		parsed, err := ParseReportFormat(format)
+		require.NoError(t, err)
+		assert.Equal(t, format, parsed)
+	}
+	_, err := ParseReportFormat("xml")
+	assert.Error(t, err)
+}
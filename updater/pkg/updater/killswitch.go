@@ -0,0 +1,192 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// killSwitchInterval is how often the kill switch is checked while waiting for an instance to drain.
+const killSwitchInterval = 30 * time.Second
+
+// skipPaused is the reason recorded for an instance whose drain was rolled back because the kill
+// switch was engaged.
+const skipPaused = "paused"
+
+// killSwitchSource is a place an operator can engage the kill switch: an SSM parameter, a tag on
+// the cluster, or an attribute on any container instance in the cluster.
+type killSwitchSource struct {
+	kind string
+	name string
+}
+
+func (s killSwitchSource) String() string {
+	return s.kind + ":" + s.name
+}
+
+// parseKillSwitch parses a comma-separated list of kill switch sources, such as
+// "ssm:/bottlerocket-ecs-updater/paused,tag:bottlerocket-updater-paused".
+func parseKillSwitch(value string) ([]killSwitchSource, error) {
+	sources := make([]killSwitchSource, 0)
+	for _, entry := range SplitList(value) {
+		colon := strings.Index(entry, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("invalid kill switch %q, expected ssm:<parameter>, tag:<key> or attribute:<name>", entry)
+		}
+		s := killSwitchSource{kind: entry[:colon], name: strings.TrimSpace(entry[colon+1:])}
+		switch {
+		case s.kind != "ssm" && s.kind != "tag" && s.kind != "attribute":
+			return nil, fmt.Errorf("invalid kill switch %q, expected ssm:<parameter>, tag:<key> or attribute:<name>", entry)
+		case s.name == "":
+			return nil, fmt.Errorf("invalid kill switch %q: missing name", entry)
+		}
+		sources = append(sources, s)
+	}
+	return sources, nil
+}
+
+// killSwitch stops rollouts when an operator sets one of its sources to "true" or to an RFC 3339
+// time until which rollouts are paused. Sources that cannot be read are logged and ignored. A nil
+// killSwitch is never engaged.
+type killSwitch struct {
+	sources []killSwitchSource
+	// ssm reads parameters in the updater's own account, and ecs reads the cluster.
+	ssm      SSMAPI
+	ecs      ECSAPI
+	cluster  string
+	interval time.Duration
+}
+
+func newKillSwitch(sources []killSwitchSource, ssmClient SSMAPI, ecsClient ECSAPI, cluster string) *killSwitch {
+	if len(sources) == 0 {
+		return nil
+	}
+	return &killSwitch{
+		sources:  sources,
+		ssm:      ssmClient,
+		ecs:      ecsClient,
+		cluster:  cluster,
+		interval: killSwitchInterval,
+	}
+}
+
+// engaged reports whether the kill switch is engaged, and which source engaged it.
+func (k *killSwitch) engaged() (bool, string) {
+	if k == nil {
+		return false, ""
+	}
+	now := time.Now()
+	for _, s := range k.sources {
+		values, err := k.values(s)
+		if err != nil {
+			log.Printf("Failed to read kill switch %s: %v", s, err)
+			continue
+		}
+		for _, value := range values {
+			if active, _ := optOutActive(value, now); active {
+				return true, fmt.Sprintf("kill switch %s set to %q", s, value)
+			}
+		}
+	}
+	return false, ""
+}
+
+// values returns the values of the source; it returns no values when the source is not set.
+func (k *killSwitch) values(s killSwitchSource) ([]string, error) {
+	switch s.kind {
+	case "ssm":
+		resp, err := k.ssm.GetParameter(&ssm.GetParameterInput{
+			Name:           aws.String(s.name),
+			WithDecryption: aws.Bool(true),
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []string{aws.StringValue(resp.Parameter.Value)}, nil
+	case "tag":
+		resp, err := k.ecs.DescribeClusters(&ecs.DescribeClustersInput{
+			Clusters: aws.StringSlice([]string{k.cluster}),
+			Include:  aws.StringSlice([]string{ecs.ClusterFieldTags}),
+		})
+		if err != nil {
+			return nil, err
+		}
+		values := make([]string, 0)
+		for _, cluster := range resp.Clusters {
+			for _, tag := range cluster.Tags {
+				if aws.StringValue(tag.Key) == s.name {
+					values = append(values, aws.StringValue(tag.Value))
+				}
+			}
+		}
+		return values, nil
+	default:
+		values := make([]string, 0)
+		input := &ecs.ListAttributesInput{
+			Cluster:       aws.String(k.cluster),
+			TargetType:    aws.String(ecs.TargetTypeContainerInstance),
+			AttributeName: aws.String(s.name),
+		}
+		for {
+			resp, err := k.ecs.ListAttributes(input)
+			if err != nil {
+				return nil, err
+			}
+			for _, attr := range resp.Attributes {
+				values = append(values, aws.StringValue(attr.Value))
+			}
+			if aws.StringValue(resp.NextToken) == "" {
+				return values, nil
+			}
+			input.NextToken = resp.NextToken
+		}
+	}
+}
+
+// watch returns a context that is cancelled as soon as the kill switch is engaged, checking it
+// every interval, and a function that stops watching and returns why the switch was engaged, or
+// an empty string if it was not.
+func (k *killSwitch) watch(ctx aws.Context) (aws.Context, func() string) {
+	if k == nil {
+		return ctx, func() string { return "" }
+	}
+	watched, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	result := make(chan string, 1)
+	go func() {
+		ticker := time.NewTicker(k.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				result <- ""
+				return
+			case <-watched.Done():
+				result <- ""
+				return
+			case <-ticker.C:
+				if on, why := k.engaged(); on {
+					result <- why
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return watched, func() string {
+		close(done)
+		why := <-result
+		cancel()
+		return why
+	}
+}
@@ -0,0 +1,170 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKillSwitch(t *testing.T) {
+	sources, err := parseKillSwitch("ssm:/updater/paused, tag:updater-paused,attribute:bottlerocket.updater.paused")
+	require.NoError(t, err)
+	assert.Equal(t, []killSwitchSource{
+		{kind: "ssm", name: "/updater/paused"},
+		{kind: "tag", name: "updater-paused"},
+		{kind: "attribute", name: "bottlerocket.updater.paused"},
+	}, sources)
+
+	sources, err = parseKillSwitch("")
+	require.NoError(t, err)
+	assert.Empty(t, sources)
+	assert.Nil(t, newKillSwitch(sources, MockSSM{}, MockECS{}, "test-cluster"))
+
+	for _, value := range []string{"paused", "s3:bucket", "ssm:", "tag: "} {
+		_, err := parseKillSwitch(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestKillSwitchEngaged(t *testing.T) {
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	cases := []struct {
+		name      string
+		parameter string
+		tag       string
+		attrs     []string
+		engaged   bool
+		reason    string
+	}{
+		{name: "unset"},
+		{name: "false everywhere", parameter: "false", tag: "false", attrs: []string{"false"}},
+		{name: "parameter", parameter: "true", engaged: true, reason: `kill switch ssm:/updater/paused set to "true"`},
+		{name: "tag until", tag: future, engaged: true, reason: `kill switch tag:updater-paused set to "` + future + `"`},
+		{name: "tag expired", tag: past},
+		{name: "attribute", attrs: []string{"false", "true"}, engaged: true, reason: `kill switch attribute:updater.paused set to "true"`},
+		{name: "unrecognized", parameter: "yes please", engaged: true, reason: `kill switch ssm:/updater/paused set to "yes please"`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockSSM := MockSSM{
+				GetParameterFn: func(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+					assert.Equal(t, "/updater/paused", aws.StringValue(input.Name))
+					if c.parameter == "" {
+						return nil, awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil)
+					}
+					return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(c.parameter)}}, nil
+				},
+			}
+			mockECS := MockECS{
+				DescribeClustersFn: func(input *ecs.DescribeClustersInput) (*ecs.DescribeClustersOutput, error) {
+					assert.Equal(t, []string{"test-cluster"}, aws.StringValueSlice(input.Clusters))
+					assert.Equal(t, []string{ecs.ClusterFieldTags}, aws.StringValueSlice(input.Include))
+					cluster := &ecs.Cluster{ClusterName: aws.String("test-cluster")}
+					if c.tag != "" {
+						cluster.Tags = []*ecs.Tag{{Key: aws.String("updater-paused"), Value: aws.String(c.tag)}}
+					}
+					return &ecs.DescribeClustersOutput{Clusters: []*ecs.Cluster{cluster}}, nil
+				},
+				ListAttributesFn: func(input *ecs.ListAttributesInput) (*ecs.ListAttributesOutput, error) {
+					assert.Equal(t, "test-cluster", aws.StringValue(input.Cluster))
+					assert.Equal(t, "updater.paused", aws.StringValue(input.AttributeName))
+					// Each attribute is returned on a page of its own.
+					i := 0
+					if input.NextToken != nil {
+						i = 1
+					}
+					output := &ecs.ListAttributesOutput{}
+					if i < len(c.attrs) {
+						output.Attributes = []*ecs.Attribute{{Name: aws.String("updater.paused"), Value: aws.String(c.attrs[i])}}
+					}
+					if i == 0 && len(c.attrs) > 1 {
+						output.NextToken = aws.String("next")
+					}
+					return output, nil
+				},
+			}
+			sources, err := parseKillSwitch("ssm:/updater/paused,tag:updater-paused,attribute:updater.paused")
+			require.NoError(t, err)
+			k := newKillSwitch(sources, mockSSM, mockECS, "test-cluster")
+			engaged, reason := k.engaged()
+			assert.Equal(t, c.engaged, engaged)
+			assert.Equal(t, c.reason, reason)
+		})
+	}
+}
+
+func TestKillSwitchReadErr(t *testing.T) {
+	k := newKillSwitch([]killSwitchSource{{kind: "ssm", name: "/updater/paused"}, {kind: "tag", name: "updater-paused"}},
+		MockSSM{
+			GetParameterFn: func(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+				return nil, errors.New("throttled")
+			},
+		},
+		MockECS{
+			DescribeClustersFn: func(input *ecs.DescribeClustersInput) (*ecs.DescribeClustersOutput, error) {
+				return &ecs.DescribeClustersOutput{Clusters: []*ecs.Cluster{{
+					Tags: []*ecs.Tag{{Key: aws.String("updater-paused"), Value: aws.String("true")}},
+				}}}, nil
+			},
+		}, "test-cluster")
+	engaged, reason := k.engaged()
+	assert.True(t, engaged, "a source that cannot be read should not hide the others")
+	assert.Equal(t, `kill switch tag:updater-paused set to "true"`, reason)
+}
+
+func TestKillSwitchWatch(t *testing.T) {
+	value := make(chan string, 10)
+	current := "false"
+	k := newKillSwitch([]killSwitchSource{{kind: "ssm", name: "/updater/paused"}}, MockSSM{
+		GetParameterFn: func(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+			select {
+			case current = <-value:
+			default:
+			}
+			return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(current)}}, nil
+		},
+	}, MockECS{}, "test-cluster")
+	k.interval = time.Millisecond
+
+	ctx, stop := k.watch(context.Background())
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ctx.Err())
+	assert.Equal(t, "", stop(), "the switch was not engaged")
+	assert.Error(t, ctx.Err(), "the context should be released once watching stops")
+
+	ctx, stop = k.watch(context.Background())
+	value <- "true"
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context was not cancelled when the kill switch was engaged")
+	}
+	assert.Equal(t, `kill switch ssm:/updater/paused set to "true"`, stop())
+
+	// The switch is still engaged, but is not checked again before the parent is cancelled.
+	k.interval = time.Hour
+	parent, cancel := context.WithCancel(context.Background())
+	ctx, stop = k.watch(parent)
+	cancel()
+	<-ctx.Done()
+	assert.Equal(t, "", stop(), "cancelling the parent is not a pause")
+}
+
+func TestNilKillSwitch(t *testing.T) {
+	var k *killSwitch
+	engaged, _ := k.engaged()
+	assert.False(t, engaged)
+	ctx := context.Background()
+	watched, stop := k.watch(ctx)
+	assert.Equal(t, ctx, watched)
+	assert.Equal(t, "", stop())
+}
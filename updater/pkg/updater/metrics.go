@@ -0,0 +1,286 @@
+package updater
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsNamespace = "bottlerocket_ecs_updater"
+
+// labelEscaper escapes label values as required by the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type metricType string
+
+const (
+	metricCounter   metricType = "counter"
+	metricGauge     metricType = "gauge"
+	metricHistogram metricType = "histogram"
+)
+
+// series is a single combination of label values within a metric family.
+type series struct {
+	labelValues []string
+	value       float64
+	// buckets, sum and count are only used by histograms; buckets holds cumulative counts.
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// family is a metric with a fixed set of label names, rendered in the Prometheus text format.
+type family struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	bounds  []float64
+	entries map[string]*series
+}
+
+func newFamily(typ metricType, name, help string, bounds []float64, labels ...string) *family {
+	f := &family{
+		name:    metricsNamespace + "_" + name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		bounds:  bounds,
+		entries: make(map[string]*series),
+	}
+	// Metrics without labels are reported as zero until they are first observed.
+	if len(labels) == 0 {
+		f.get(nil)
+	}
+	return f
+}
+
+// get returns the series for the label values, creating it if needed. Callers must hold f.mu.
+func (f *family) get(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.entries[key]
+	if !ok {
+		s = &series{labelValues: labelValues}
+		if f.typ == metricHistogram {
+			s.buckets = make([]uint64, len(f.bounds))
+		}
+		f.entries[key] = s
+	}
+	return s
+}
+
+func (f *family) add(v float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(labelValues).value += v
+}
+
+func (f *family) observe(v float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.get(labelValues)
+	for i, bound := range f.bounds {
+		if v <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// reset removes the series whose first label has the given value, which lets a gauge drop label
+// values that no longer exist.
+func (f *family) reset(first string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, s := range f.entries {
+		if len(s.labelValues) > 0 && s.labelValues[0] == first {
+			delete(f.entries, key)
+		}
+	}
+}
+
+func (f *family) set(v float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(labelValues).value = v
+}
+
+func (f *family) write(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	keys := make([]string, 0, len(f.entries))
+	for k := range f.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := f.entries[k]
+		if f.typ != metricHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), formatValue(s.value))
+			continue
+		}
+		for i, bound := range f.bounds {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", formatValue(bound)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+labelEscaper.Replace(extraValue)+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metrics holds the Prometheus metrics describing the update lifecycle. A nil metrics
+// ignores all observations, which is the case when no HTTP server is running.
+type metrics struct {
+	families []*family
+
+	discovered     *family
+	bottlerocket   *family
+	candidates     *family
+	updated        *family
+	skipped        *family
+	failed         *family
+	drainDuration  *family
+	ssmDuration    *family
+	rebootDuration *family
+	updateDuration *family
+	versions       *family
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		discovered:   newFamily(metricCounter, "instances_discovered_total", "Container instances discovered in the cluster.", nil),
+		bottlerocket: newFamily(metricCounter, "bottlerocket_instances_total", "Container instances detected as running Bottlerocket.", nil),
+		candidates:   newFamily(metricCounter, "candidates_total", "Bottlerocket instances found with an update available.", nil),
+		updated:      newFamily(metricCounter, "instances_updated_total", "Instances updated successfully.", nil),
+		skipped:      newFamily(metricCounter, "instances_skipped_total", "Candidate instances skipped, by reason.", nil, "reason"),
+		failed:       newFamily(metricCounter, "instances_failed_total", "Candidate instances that failed to update, by phase.", nil, "phase"),
+		drainDuration: newFamily(metricHistogram, "drain_duration_seconds", "Time taken to drain a container instance.",
+			[]float64{10, 30, 60, 120, 300, 600, 900, 1200, 1800}),
+		ssmDuration: newFamily(metricHistogram, "ssm_command_duration_seconds", "Time from sending an SSM command until it completed on all instances, by document.",
+			[]float64{1, 5, 10, 30, 60, 120, 300, 600}, "document"),
+		rebootDuration: newFamily(metricHistogram, "reboot_duration_seconds", "Time from sending the reboot command until the EC2 instance status is Ok.",
+			[]float64{30, 60, 120, 180, 300, 600, 900, 1800}),
+		updateDuration: newFamily(metricHistogram, "instance_update_duration_seconds", "Time taken to update an instance, from the start of draining to the end of verification.",
+			[]float64{60, 300, 600, 900, 1200, 1800, 2700, 3600, 7200}),
+		versions: newFamily(metricGauge, "bottlerocket_version_instances", "Bottlerocket instances by cluster and active version, as of the last update check.", nil, "cluster", "version"),
+	}
+	m.families = []*family{
+		m.discovered, m.bottlerocket, m.candidates, m.updated, m.skipped, m.failed,
+		m.drainDuration, m.ssmDuration, m.rebootDuration, m.updateDuration, m.versions,
+	}
+	return m
+}
+
+func (m *metrics) instancesDiscovered(n int) {
+	if m == nil {
+		return
+	}
+	m.discovered.add(float64(n))
+}
+
+func (m *metrics) bottlerocketInstances(n int) {
+	if m == nil {
+		return
+	}
+	m.bottlerocket.add(float64(n))
+}
+
+func (m *metrics) updateCandidates(n int) {
+	if m == nil {
+		return
+	}
+	m.candidates.add(float64(n))
+}
+
+// result counts the outcome of processing a candidate instance.
+func (m *metrics) result(o Outcome, p Phase, reason string) {
+	if m == nil {
+		return
+	}
+	switch o {
+	case OutcomeUpdated:
+		m.updated.add(1)
+	case OutcomeSkipped:
+		m.skipped.add(1, reason)
+	case OutcomeFailed:
+		m.failed.add(1, string(p))
+	}
+}
+
+func (m *metrics) drained(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.drainDuration.observe(d.Seconds())
+}
+
+func (m *metrics) ssmCommand(document string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.ssmDuration.observe(d.Seconds(), document)
+}
+
+func (m *metrics) rebooted(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.rebootDuration.observe(d.Seconds())
+}
+
+func (m *metrics) instanceUpdated(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.updateDuration.observe(d.Seconds())
+}
+
+// fleetVersions replaces the per-version instance counts of the cluster.
+func (m *metrics) fleetVersions(cluster string, counts map[string]int) {
+	if m == nil {
+		return
+	}
+	m.versions.reset(cluster)
+	for version, n := range counts {
+		m.versions.set(float64(n), cluster, version)
+	}
+}
+
+// ServeHTTP renders all metrics in the Prometheus text exposition format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, f := range m.families {
+		f.write(w)
+	}
+}
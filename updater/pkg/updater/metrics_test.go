@@ -0,0 +1,65 @@
+package updater
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	m := newMetrics()
+	m.instancesDiscovered(3)
+	m.bottlerocketInstances(2)
+	m.result(OutcomeUpdated, "", "")
+	m.result(OutcomeSkipped, PhaseEligibility, skipNonServiceTask)
+	m.result(OutcomeFailed, PhaseDrain, "drain timed out")
+	m.result(OutcomeFailed, PhaseDrain, "drain timed out")
+	m.drained(45 * time.Second)
+	m.ssmCommand("check-doc", 2*time.Second)
+	m.fleetVersions("test-cluster", map[string]int{"1.0.5": 1, "1.0.6": 1})
+	m.fleetVersions("test-cluster", map[string]int{"1.0.6": 2})
+	m.fleetVersions("other-cluster", map[string]int{"1.0.5": 3})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, line := range []string{
+		"# TYPE bottlerocket_ecs_updater_instances_discovered_total counter",
+		"bottlerocket_ecs_updater_instances_discovered_total 3",
+		"bottlerocket_ecs_updater_bottlerocket_instances_total 2",
+		"bottlerocket_ecs_updater_candidates_total 0",
+		"bottlerocket_ecs_updater_instances_updated_total 1",
+		`bottlerocket_ecs_updater_instances_skipped_total{reason="non-service-task"} 1`,
+		`bottlerocket_ecs_updater_instances_failed_total{phase="drain"} 2`,
+		"# TYPE bottlerocket_ecs_updater_drain_duration_seconds histogram",
+		`bottlerocket_ecs_updater_drain_duration_seconds_bucket{le="30"} 0`,
+		`bottlerocket_ecs_updater_drain_duration_seconds_bucket{le="60"} 1`,
+		`bottlerocket_ecs_updater_drain_duration_seconds_bucket{le="+Inf"} 1`,
+		"bottlerocket_ecs_updater_drain_duration_seconds_sum 45",
+		"bottlerocket_ecs_updater_drain_duration_seconds_count 1",
+		`bottlerocket_ecs_updater_ssm_command_duration_seconds_bucket{document="check-doc",le="5"} 1`,
+		`bottlerocket_ecs_updater_bottlerocket_version_instances{cluster="other-cluster",version="1.0.5"} 3`,
+		`bottlerocket_ecs_updater_bottlerocket_version_instances{cluster="test-cluster",version="1.0.6"} 2`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	assert.NotContains(t, body, `cluster="test-cluster",version="1.0.5"`, "versions no longer in the fleet should be dropped")
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *metrics
+	assert.NotPanics(t, func() {
+		m.instancesDiscovered(1)
+		m.result(OutcomeFailed, PhaseUpdate, "apply failed")
+		m.rebooted(time.Minute)
+		m.fleetVersions("test-cluster", map[string]int{"1.0.6": 1})
+	})
+}
+
+func TestLabelEscaping(t *testing.T) {
+	assert.Equal(t, `{reason="a \"quoted\" \\ value\n"}`, formatLabels([]string{"reason"}, []string{"a \"quoted\" \\ value\n"}, "", ""))
+}
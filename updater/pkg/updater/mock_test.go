@@ -0,0 +1,147 @@
+package updater
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+type MockECS struct {
+	ListContainerInstancesFn           func(input *ecs.ListContainerInstancesInput) (*ecs.ListContainerInstancesOutput, error)
+	DescribeContainerInstancesFn       func(input *ecs.DescribeContainerInstancesInput) (*ecs.DescribeContainerInstancesOutput, error)
+	UpdateContainerInstancesStateFn    func(input *ecs.UpdateContainerInstancesStateInput) (*ecs.UpdateContainerInstancesStateOutput, error)
+	ListTasksFn                        func(input *ecs.ListTasksInput) (*ecs.ListTasksOutput, error)
+	DescribeTasksFn                    func(input *ecs.DescribeTasksInput) (*ecs.DescribeTasksOutput, error)
+	WaitUntilTasksStoppedWithContextFn func(ctx aws.Context, input *ecs.DescribeTasksInput, opts ...request.WaiterOption) error
+	PutAttributesFn                    func(input *ecs.PutAttributesInput) (*ecs.PutAttributesOutput, error)
+	DeleteAttributesFn                 func(input *ecs.DeleteAttributesInput) (*ecs.DeleteAttributesOutput, error)
+	ListClustersFn                     func(input *ecs.ListClustersInput) (*ecs.ListClustersOutput, error)
+	DescribeClustersFn                 func(input *ecs.DescribeClustersInput) (*ecs.DescribeClustersOutput, error)
+	ListAttributesFn                   func(input *ecs.ListAttributesInput) (*ecs.ListAttributesOutput, error)
+}
+
+var _ ECSAPI = (*MockECS)(nil)
+
+type MockSSM struct {
+	WaitUntilCommandExecutedWithContextFn func(ctx aws.Context, input *ssm.GetCommandInvocationInput, opts ...request.WaiterOption) error
+	SendCommandFn                         func(input *ssm.SendCommandInput) (*ssm.SendCommandOutput, error)
+	GetCommandInvocationFn                func(input *ssm.GetCommandInvocationInput) (*ssm.GetCommandInvocationOutput, error)
+	GetParametersByPathFn                 func(input *ssm.GetParametersByPathInput) (*ssm.GetParametersByPathOutput, error)
+	GetParameterFn                        func(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error)
+}
+
+var _ SSMAPI = (*MockSSM)(nil)
+
+type MockEC2 struct {
+	WaitUntilInstanceStatusOkWithContextFn func(ctx aws.Context, input *ec2.DescribeInstanceStatusInput, opts ...request.WaiterOption) error
+	DescribeTagsFn                         func(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error)
+}
+
+var _ EC2API = (*MockEC2)(nil)
+
+type MockCloudWatch struct {
+	DescribeAlarmsFn func(input *cloudwatch.DescribeAlarmsInput) (*cloudwatch.DescribeAlarmsOutput, error)
+}
+
+var _ CloudWatchAPI = (*MockCloudWatch)(nil)
+
+type MockEvents struct {
+	PutEventsFn func(input *eventbridge.PutEventsInput) (*eventbridge.PutEventsOutput, error)
+}
+
+var _ EventsAPI = (*MockEvents)(nil)
+
+type MockSNS struct {
+	PublishFn func(input *sns.PublishInput) (*sns.PublishOutput, error)
+}
+
+var _ SNSAPI = (*MockSNS)(nil)
+
+func (m MockECS) ListContainerInstancesWithContext(ctx aws.Context, input *ecs.ListContainerInstancesInput, opts ...request.Option) (*ecs.ListContainerInstancesOutput, error) {
+	return m.ListContainerInstancesFn(input)
+}
+
+func (m MockECS) DescribeContainerInstancesWithContext(ctx aws.Context, input *ecs.DescribeContainerInstancesInput, opts ...request.Option) (*ecs.DescribeContainerInstancesOutput, error) {
+	return m.DescribeContainerInstancesFn(input)
+}
+
+func (m MockECS) UpdateContainerInstancesStateWithContext(ctx aws.Context, input *ecs.UpdateContainerInstancesStateInput, opts ...request.Option) (*ecs.UpdateContainerInstancesStateOutput, error) {
+	return m.UpdateContainerInstancesStateFn(input)
+}
+
+func (m MockECS) ListTasksWithContext(ctx aws.Context, input *ecs.ListTasksInput, opts ...request.Option) (*ecs.ListTasksOutput, error) {
+	return m.ListTasksFn(input)
+}
+
+func (m MockECS) DescribeTasksWithContext(ctx aws.Context, input *ecs.DescribeTasksInput, opts ...request.Option) (*ecs.DescribeTasksOutput, error) {
+	return m.DescribeTasksFn(input)
+}
+
+func (m MockECS) WaitUntilTasksStoppedWithContext(ctx aws.Context, input *ecs.DescribeTasksInput, opts ...request.WaiterOption) error {
+	return m.WaitUntilTasksStoppedWithContextFn(ctx, input, opts...)
+}
+
+func (m MockECS) PutAttributesWithContext(ctx aws.Context, input *ecs.PutAttributesInput, opts ...request.Option) (*ecs.PutAttributesOutput, error) {
+	return m.PutAttributesFn(input)
+}
+
+func (m MockECS) DeleteAttributesWithContext(ctx aws.Context, input *ecs.DeleteAttributesInput, opts ...request.Option) (*ecs.DeleteAttributesOutput, error) {
+	return m.DeleteAttributesFn(input)
+}
+
+func (m MockECS) ListClusters(input *ecs.ListClustersInput) (*ecs.ListClustersOutput, error) {
+	return m.ListClustersFn(input)
+}
+
+func (m MockECS) DescribeClusters(input *ecs.DescribeClustersInput) (*ecs.DescribeClustersOutput, error) {
+	return m.DescribeClustersFn(input)
+}
+
+func (m MockECS) ListAttributes(input *ecs.ListAttributesInput) (*ecs.ListAttributesOutput, error) {
+	return m.ListAttributesFn(input)
+}
+
+func (m MockSSM) SendCommandWithContext(ctx aws.Context, input *ssm.SendCommandInput, opts ...request.Option) (*ssm.SendCommandOutput, error) {
+	return m.SendCommandFn(input)
+}
+
+func (m MockSSM) WaitUntilCommandExecutedWithContext(ctx aws.Context, input *ssm.GetCommandInvocationInput, opts ...request.WaiterOption) error {
+	return m.WaitUntilCommandExecutedWithContextFn(ctx, input, opts...)
+}
+
+func (m MockSSM) GetCommandInvocationWithContext(ctx aws.Context, input *ssm.GetCommandInvocationInput, opts ...request.Option) (*ssm.GetCommandInvocationOutput, error) {
+	return m.GetCommandInvocationFn(input)
+}
+
+func (m MockSSM) GetParametersByPath(input *ssm.GetParametersByPathInput) (*ssm.GetParametersByPathOutput, error) {
+	return m.GetParametersByPathFn(input)
+}
+
+func (m MockSSM) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	return m.GetParameterFn(input)
+}
+
+func (c MockEC2) WaitUntilInstanceStatusOkWithContext(ctx aws.Context, input *ec2.DescribeInstanceStatusInput, opts ...request.WaiterOption) error {
+	return c.WaitUntilInstanceStatusOkWithContextFn(ctx, input, opts...)
+}
+
+func (c MockEC2) DescribeTagsWithContext(ctx aws.Context, input *ec2.DescribeTagsInput, opts ...request.Option) (*ec2.DescribeTagsOutput, error) {
+	return c.DescribeTagsFn(input)
+}
+
+func (c MockCloudWatch) DescribeAlarmsWithContext(ctx aws.Context, input *cloudwatch.DescribeAlarmsInput, opts ...request.Option) (*cloudwatch.DescribeAlarmsOutput, error) {
+	return c.DescribeAlarmsFn(input)
+}
+
+func (e MockEvents) PutEventsWithContext(ctx aws.Context, input *eventbridge.PutEventsInput, opts ...request.Option) (*eventbridge.PutEventsOutput, error) {
+	return e.PutEventsFn(input)
+}
+
+func (s MockSNS) Publish(input *sns.PublishInput) (*sns.PublishOutput, error) {
+	return s.PublishFn(input)
+}
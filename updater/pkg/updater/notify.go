@@ -0,0 +1,290 @@
+package updater
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+const (
+	webhookTimeout = 10 * time.Second
+	// maxSubjectLength is the maximum length of an SNS message subject.
+	maxSubjectLength = 100
+)
+
+// notificationKind distinguishes end-of-run summaries from fatal errors reported immediately and
+// plans that await approval.
+type notificationKind string
+
+const (
+	notificationSummary  notificationKind = "summary"
+	notificationFatal    notificationKind = "fatal"
+	notificationApproval notificationKind = "approval"
+)
+
+// notificationFilter selects which notifications a backend receives.
+type notificationFilter string
+
+const (
+	// filterAll sends every summary and fatal error.
+	filterAll notificationFilter = "all"
+	// filterFailures only sends notifications about runs with failures, halted runs, fatal errors
+	// and plans that await approval.
+	filterFailures notificationFilter = "failures"
+	// filterSummaries only sends end-of-run summaries.
+	filterSummaries notificationFilter = "summaries"
+)
+
+func parseNotificationFilter(value string) (notificationFilter, error) {
+	switch f := notificationFilter(value); f {
+	case "":
+		return filterAll, nil
+	case filterAll, filterFailures, filterSummaries:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown notification filter %q, expected %q, %q or %q", value, filterAll, filterFailures, filterSummaries)
+	}
+}
+
+// notification is the data available to notification templates.
+type notification struct {
+	Kind    notificationKind `json:"kind"`
+	Cluster string           `json:"cluster"`
+	// Report is the summary of the run so far.
+	Report RunSummary `json:"report"`
+	// Instance is the instance a fatal error is about, if any.
+	Instance *instanceStatus `json:"instance,omitempty"`
+	// Error is the error that ended the run, if any.
+	Error string `json:"error,omitempty"`
+	// Plan is the plan awaiting approval, if any.
+	Plan *approvalPlan `json:"plan,omitempty"`
+}
+
+// failed reports whether the notification is about a problem.
+func (n notification) failed() bool {
+	return n.Kind == notificationFatal || n.Report.Failed > 0 || n.Report.Quarantined > 0 || n.Report.HaltReason != "" || n.Error != ""
+}
+
+const defaultNotificationTemplate = `{{if eq .Kind "approval"}}Bottlerocket ECS updater plan {{.Plan.ID}} for cluster {{.Cluster}} awaits approval until {{.Plan.Expires.Format "2006-01-02T15:04:05Z07:00"}}:
+{{- range .Plan.Instances}}
+- {{.InstanceID}}: {{.BottlerocketVersion}} to {{.TargetVersion}}{{end}}
+{{else if eq .Kind "fatal"}}Bottlerocket ECS updater needs attention in cluster {{.Cluster}}: {{.Error}}
+{{- if .Instance}}
+Instance {{.Instance.InstanceID}} ({{.Instance.ContainerInstanceARN}}) may be left out of service.{{end}}
+{{else}}Bottlerocket ECS updater run in cluster {{.Cluster}} finished: {{.Report.Candidates}} candidates, {{.Report.Updated}} updated, {{.Report.Skipped}} skipped, {{.Report.Failed}} failed.
+{{- if .Report.Quarantined}}
+{{.Report.Quarantined}} quarantined instances need an operator.{{end}}
+{{- if .Report.HaltReason}}
+Rollout {{if .Report.Paused}}paused{{else}}halted{{end}}: {{.Report.HaltReason}}{{end}}
+{{- if .Error}}
+Error: {{.Error}}{{end}}
+{{- range .Report.Results}}{{if ne .Outcome "updated"}}
+- {{.InstanceID}}: {{.Outcome}} during {{.Phase}}: {{.Reason}}{{end}}{{end}}
+{{end}}`
+
+// notificationBackend delivers a rendered notification.
+type notificationBackend interface {
+	send(n notification, body string) error
+	String() string
+}
+
+// notificationTarget is a backend with its template and filter.
+type notificationTarget struct {
+	backend  notificationBackend
+	template *template.Template
+	filter   notificationFilter
+}
+
+func newNotificationTarget(backend notificationBackend, text string, filter notificationFilter) (*notificationTarget, error) {
+	target := &notificationTarget{backend: backend, filter: filter}
+	if text == "" {
+		// Webhooks receive the notification as JSON unless a template is configured.
+		if _, ok := backend.(*webhookBackend); ok {
+			return target, nil
+		}
+		text = defaultNotificationTemplate
+	}
+	tmpl, err := template.New(backend.String()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification template for %s: %w", backend, err)
+	}
+	target.template = tmpl
+	return target, nil
+}
+
+func (t *notificationTarget) accepts(n notification) bool {
+	switch t.filter {
+	case filterFailures:
+		return n.failed() || n.Kind == notificationApproval
+	case filterSummaries:
+		return n.Kind == notificationSummary
+	default:
+		return true
+	}
+}
+
+func (t *notificationTarget) render(n notification) (string, error) {
+	if t.template == nil {
+		body, err := json.Marshal(n)
+		return string(body), err
+	}
+	buf := &bytes.Buffer{}
+	if err := t.template.Execute(buf, n); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// notifier sends notifications to every configured target. Delivery is best effort: failures
+// are logged and never change the outcome of a run. A nil notifier sends nothing.
+type notifier struct {
+	cluster string
+	targets []*notificationTarget
+}
+
+// summary notifies the end of a run. err is the error that ended the run, if any.
+func (n *notifier) summary(report *runReport, err error) {
+	if n == nil {
+		return
+	}
+	msg := notification{
+		Kind:    notificationSummary,
+		Cluster: n.cluster,
+		Report:  report.summary(),
+	}
+	if err != nil {
+		msg.Error = err.Error()
+	}
+	n.send(msg)
+}
+
+// fatal notifies an error that needs an operator's attention, such as an instance that could not
+// be returned to ACTIVE.
+func (n *notifier) fatal(report *runReport, inst *instance, err error) {
+	if n == nil {
+		return
+	}
+	msg := notification{
+		Kind:    notificationFatal,
+		Cluster: n.cluster,
+		Report:  report.summary(),
+		Error:   err.Error(),
+	}
+	if inst != nil {
+		status := newInstanceStatus(*inst)
+		msg.Instance = &status
+	}
+	n.send(msg)
+}
+
+// approval notifies a plan that awaits approval before any instance is drained.
+func (n *notifier) approval(report *runReport, plan approvalPlan) {
+	if n == nil {
+		return
+	}
+	n.send(notification{
+		Kind:    notificationApproval,
+		Cluster: n.cluster,
+		Report:  report.summary(),
+		Plan:    &plan,
+	})
+}
+
+func (n *notifier) send(msg notification) {
+	for _, target := range n.targets {
+		if !target.accepts(msg) {
+			continue
+		}
+		body, err := target.render(msg)
+		if err != nil {
+			log.Printf("Failed to render %s notification for %s: %v", msg.Kind, target.backend, err)
+			continue
+		}
+		if err := target.backend.send(msg, body); err != nil {
+			log.Printf("Failed to send %s notification to %s: %v", msg.Kind, target.backend, err)
+			continue
+		}
+		log.Printf("Sent %s notification to %s", msg.Kind, target.backend)
+	}
+}
+
+// snsBackend publishes notifications to an SNS topic.
+type snsBackend struct {
+	sns      SNSAPI
+	topicARN string
+}
+
+func (b *snsBackend) send(n notification, body string) error {
+	subject := fmt.Sprintf("Bottlerocket ECS updater %s for cluster %s", n.Kind, n.Cluster)
+	if n.failed() {
+		subject = fmt.Sprintf("Bottlerocket ECS updater %s with failures for cluster %s", n.Kind, n.Cluster)
+	}
+	if len(subject) > maxSubjectLength {
+		subject = subject[:maxSubjectLength]
+	}
+	_, err := b.sns.Publish(&sns.PublishInput{
+		TopicArn: aws.String(b.topicARN),
+		Subject:  aws.String(subject),
+		Message:  aws.String(body),
+	})
+	return err
+}
+
+func (b *snsBackend) String() string {
+	return "SNS topic " + b.topicARN
+}
+
+// webhookBackend posts notifications to an HTTP endpoint.
+type webhookBackend struct {
+	url string
+	// host is logged instead of the URL, which may contain credentials.
+	host   string
+	client *http.Client
+}
+
+func newWebhookBackend(rawURL string) (*webhookBackend, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL: must be an absolute http or https URL")
+	}
+	return &webhookBackend{
+		url:    rawURL,
+		host:   parsed.Host,
+		client: &http.Client{Timeout: webhookTimeout},
+	}, nil
+}
+
+func (b *webhookBackend) send(n notification, body string) error {
+	contentType := "text/plain; charset=utf-8"
+	if json.Valid([]byte(body)) {
+		contentType = "application/json"
+	}
+	resp, err := b.client.Post(b.url, contentType, strings.NewReader(body))
+	if err != nil {
+		// Drop the URL from the error so that it is not logged.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %s", resp.Status)
+	}
+	return nil
+}
+
+func (b *webhookBackend) String() string {
+	return "webhook on " + b.host
+}
@@ -0,0 +1,122 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReport(failed bool) *runReport {
+	report := newRunReport("test-cluster")
+	report.candidates = 2
+	report.record(instance{instanceID: "inst-id-1"}, OutcomeUpdated, "", "")
+	if failed {
+		report.record(instance{instanceID: "inst-id-2"}, OutcomeFailed, PhaseDrain, "drain timed out")
+	} else {
+		report.record(instance{instanceID: "inst-id-2"}, OutcomeSkipped, PhaseEligibility, skipNonServiceTask)
+	}
+	return report
+}
+
+func TestNotifierSNS(t *testing.T) {
+	published := []*sns.PublishInput{}
+	mockSNS := MockSNS{
+		PublishFn: func(input *sns.PublishInput) (*sns.PublishOutput, error) {
+			published = append(published, input)
+			return &sns.PublishOutput{}, nil
+		},
+	}
+	target, err := newNotificationTarget(&snsBackend{sns: mockSNS, topicARN: "topic-arn"}, "", filterAll)
+	require.NoError(t, err)
+	n := &notifier{cluster: "test-cluster", targets: []*notificationTarget{target}}
+
+	n.summary(testReport(true), nil)
+	require.Len(t, published, 1)
+	assert.Equal(t, "topic-arn", aws.StringValue(published[0].TopicArn))
+	assert.Equal(t, "Bottlerocket ECS updater summary with failures for cluster test-cluster", aws.StringValue(published[0].Subject))
+	assert.Equal(t, "Bottlerocket ECS updater run in cluster test-cluster finished: 2 candidates, 1 updated, 0 skipped, 1 failed.\n"+
+		"- inst-id-2: failed during drain: drain timed out\n", aws.StringValue(published[0].Message))
+
+	inst := instance{instanceID: "inst-id-3", containerInstanceID: "cont-inst-arn-3"}
+	n.fatal(testReport(false), &inst, errors.New("failed to re-activate"))
+	require.Len(t, published, 2)
+	assert.Equal(t, "Bottlerocket ECS updater needs attention in cluster test-cluster: failed to re-activate\n"+
+		"Instance inst-id-3 (cont-inst-arn-3) may be left out of service.\n", aws.StringValue(published[1].Message))
+}
+
+func TestNotifierWebhook(t *testing.T) {
+	bodies := []string{}
+	contentTypes := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+		contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+	}))
+	defer server.Close()
+
+	backend, err := newWebhookBackend(server.URL + "/hook?token=secret")
+	require.NoError(t, err)
+	assert.NotContains(t, backend.String(), "secret")
+	jsonTarget, err := newNotificationTarget(backend, "", filterFailures)
+	require.NoError(t, err)
+	textTarget, err := newNotificationTarget(backend, "{{.Cluster}}: {{.Report.Updated}} updated", filterSummaries)
+	require.NoError(t, err)
+	n := &notifier{cluster: "test-cluster", targets: []*notificationTarget{jsonTarget, textTarget}}
+
+	n.summary(testReport(false), nil)
+	require.Len(t, bodies, 1, "only the summaries target accepts a run without failures")
+	assert.Equal(t, "test-cluster: 1 updated", bodies[0])
+	assert.Equal(t, "text/plain; charset=utf-8", contentTypes[0])
+
+	n.fatal(testReport(false), nil, errors.New("failed to re-activate"))
+	require.Len(t, bodies, 2, "only the failures target accepts a fatal error")
+	assert.Equal(t, "application/json", contentTypes[1])
+	msg := notification{}
+	require.NoError(t, json.Unmarshal([]byte(bodies[1]), &msg))
+	assert.Equal(t, notificationFatal, msg.Kind)
+	assert.Equal(t, "failed to re-activate", msg.Error)
+	assert.Equal(t, 1, msg.Report.Updated)
+}
+
+func TestNotifierQuarantined(t *testing.T) {
+	published := []*sns.PublishInput{}
+	mockSNS := MockSNS{
+		PublishFn: func(input *sns.PublishInput) (*sns.PublishOutput, error) {
+			published = append(published, input)
+			return &sns.PublishOutput{}, nil
+		},
+	}
+	target, err := newNotificationTarget(&snsBackend{sns: mockSNS, topicARN: "topic-arn"}, "", filterFailures)
+	require.NoError(t, err)
+	n := &notifier{cluster: "test-cluster", targets: []*notificationTarget{target}}
+
+	report := newRunReport("test-cluster")
+	report.candidates = 1
+	report.record(instance{instanceID: "inst-id-1"}, OutcomeSkipped, PhaseEligibility, skipQuarantined)
+	n.summary(report, nil)
+	require.Len(t, published, 1, "quarantined instances must be reported as failures")
+	assert.Equal(t, "Bottlerocket ECS updater run in cluster test-cluster finished: 1 candidates, 0 updated, 1 skipped, 0 failed.\n"+
+		"1 quarantined instances need an operator.\n"+
+		"- inst-id-1: skipped during eligibility: quarantined\n", aws.StringValue(published[0].Message))
+}
+
+func TestNotificationConfigErr(t *testing.T) {
+	_, err := parseNotificationFilter("sometimes")
+	assert.Error(t, err)
+	_, err = newWebhookBackend("not a url")
+	assert.Error(t, err)
+	_, err = newNotificationTarget(&snsBackend{topicARN: "topic-arn"}, "{{.Cluster", filterAll)
+	assert.Error(t, err)
+
+	var n *notifier
+	assert.NotPanics(t, func() { n.summary(testReport(true), nil) })
+}
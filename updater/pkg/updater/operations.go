@@ -0,0 +1,180 @@
+package updater
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// selectInstances returns the instances with the given EC2 instance IDs, or every instance if no
+// IDs are given, and the IDs that matched no instance.
+func selectInstances(instances []instance, ids []string) ([]instance, []string) {
+	if len(ids) == 0 {
+		return instances, nil
+	}
+	byID := make(map[string]instance, len(instances))
+	for _, inst := range instances {
+		byID[inst.instanceID] = inst
+	}
+	selected := make([]instance, 0, len(ids))
+	missing := make([]string, 0)
+	for _, id := range ids {
+		if inst, ok := byID[id]; ok {
+			selected = append(selected, inst)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return selected, missing
+}
+
+// bottlerocketInstances returns the Bottlerocket instances selected for updates with any of the
+// given container instance statuses.
+func (u *clusterUpdater) bottlerocketInstances(statuses ...string) ([]instance, error) {
+	arns := make([]*string, 0)
+	for _, status := range statuses {
+		listed, err := u.listContainerInstancesWithStatus(aws.BackgroundContext(), status)
+		if err != nil {
+			return nil, err
+		}
+		arns = append(arns, listed...)
+	}
+	if len(arns) == 0 {
+		return nil, nil
+	}
+	return u.filterBottlerocketInstances(aws.BackgroundContext(), arns)
+}
+
+// printStatus writes the Bottlerocket version, update state and update history of the instances
+// with the given IDs, or of every instance, as a table.
+func (u *clusterUpdater) printStatus(w io.Writer, ids []string) error {
+	instances, err := u.bottlerocketInstances(ecs.ContainerInstanceStatusActive, ecs.ContainerInstanceStatusDraining)
+	if err != nil {
+		return err
+	}
+	instances, missing := selectInstances(instances, ids)
+	if len(missing) != 0 {
+		log.Printf("Instances not found in cluster %q: %q", u.cluster, missing)
+	}
+	outputs := make(map[string][]byte)
+	if len(instances) != 0 {
+		outputs, err = u.checkUpdates(aws.BackgroundContext(), instances)
+		if err != nil {
+			return err
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "CLUSTER\tINSTANCE\tSTATUS\tVARIANT\tVERSION\tUPDATE STATE\tAVAILABLE\tLAST RESULT\tFAILURES\tQUARANTINED\n")
+	for _, inst := range instances {
+		output, err := parseCommandOutput(outputs[inst.instanceID])
+		if err != nil {
+			log.Printf("Failed to parse command output %q: %v", string(outputs[inst.instanceID]), err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			u.cluster, inst.instanceID, inst.status, orDash(inst.variant),
+			orDash(output.ActivePartition.Image.Version), orDash(output.UpdateState), orDash(output.ChosenUpdate.Version),
+			orDash(inst.attributes[attributeLastResult]), strconv.Itoa(inst.failureCount), orDash(inst.quarantinedSince))
+	}
+	return tw.Flush()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// printCheck runs the check document on the instances with the given IDs, or on every active
+// instance, and writes its output for each of them.
+func (u *clusterUpdater) printCheck(w io.Writer, ids []string) error {
+	instances, err := u.bottlerocketInstances(ecs.ContainerInstanceStatusActive)
+	if err != nil {
+		return err
+	}
+	instances, missing := selectInstances(instances, ids)
+	if len(missing) != 0 {
+		return fmt.Errorf("instances are not active Bottlerocket container instances in cluster %q: %q", u.cluster, missing)
+	}
+	if len(instances) == 0 {
+		log.Printf("No Bottlerocket instances detected")
+		return nil
+	}
+	outputs, err := u.checkUpdates(aws.BackgroundContext(), instances)
+	if err != nil {
+		return err
+	}
+	for _, inst := range instances {
+		fmt.Fprintf(w, "%s %s: %s\n", u.cluster, inst.instanceID, strings.TrimSpace(string(outputs[inst.instanceID])))
+	}
+	return nil
+}
+
+// drainOnly drains the instances with the given IDs one at a time and leaves them DRAINING. It
+// refuses to drain instances running non-service tasks, which would never stop.
+func (u *clusterUpdater) drainOnly(ctx aws.Context, ids []string) error {
+	instances, err := u.bottlerocketInstances(ecs.ContainerInstanceStatusActive)
+	if err != nil {
+		return err
+	}
+	instances, missing := selectInstances(instances, ids)
+	if len(missing) != 0 {
+		return fmt.Errorf("instances are not active Bottlerocket container instances in cluster %q: %q", u.cluster, missing)
+	}
+	for _, inst := range instances {
+		eligible, err := u.eligible(ctx, inst.containerInstanceID)
+		if err != nil {
+			return fmt.Errorf("failed to determine eligibility for draining of instance %#q: %w", inst, err)
+		}
+		if !eligible {
+			return fmt.Errorf("instance %#q is running non-service tasks, not draining it", inst)
+		}
+		if err := u.drainInstance(ctx, inst); err != nil {
+			return fmt.Errorf("failed to drain instance %#q: %w", inst, err)
+		}
+		log.Printf("Instance %#q drained; reactivate it to return it to service", inst)
+	}
+	return nil
+}
+
+// reactivate returns the DRAINING Bottlerocket instances with the given IDs, or all of them, to
+// ACTIVE. Only instances the updater drained, which carry the drain marker, are considered:
+// instances drained by an operator or another tool are left alone.
+func (u *clusterUpdater) reactivate(ids []string) error {
+	draining, err := u.bottlerocketInstances(ecs.ContainerInstanceStatusDraining)
+	if err != nil {
+		return err
+	}
+	instances := make([]instance, 0, len(draining))
+	for _, inst := range draining {
+		if _, ok := inst.attributes[attributeDrained]; ok {
+			instances = append(instances, inst)
+		}
+	}
+	instances, missing := selectInstances(instances, ids)
+	if len(missing) != 0 {
+		return fmt.Errorf("instances are not Bottlerocket container instances drained by the updater in cluster %q: %q", u.cluster, missing)
+	}
+	if len(instances) == 0 {
+		log.Printf("No Bottlerocket instances drained by the updater in cluster %q", u.cluster)
+		return nil
+	}
+	arns := make([]string, 0, len(instances))
+	for _, inst := range instances {
+		arns = append(arns, inst.containerInstanceID)
+	}
+	if err := u.ensureActive(aws.BackgroundContext(), arns); err != nil {
+		return err
+	}
+	for _, inst := range instances {
+		log.Printf("Instance %#q returned to ACTIVE", inst)
+		u.events.publish(aws.BackgroundContext(), EventReactivated, inst, "")
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectInstances(t *testing.T) {
+	instances := []instance{{instanceID: "inst-id-1"}, {instanceID: "inst-id-2"}}
+	selected, missing := selectInstances(instances, nil)
+	assert.Equal(t, instances, selected)
+	assert.Empty(t, missing)
+
+	selected, missing = selectInstances(instances, []string{"inst-id-2", "inst-id-3", "inst-id-1"})
+	assert.Equal(t, []instance{{instanceID: "inst-id-2"}, {instanceID: "inst-id-1"}}, selected, "instances are returned in the order given")
+	assert.Equal(t, []string{"inst-id-3"}, missing)
+}
+
+// commandTestECS mocks a cluster with an active instance, inst-id-1, and a draining one, inst-id-2,
+// drained by the updater.
+func commandTestECS(t *testing.T, activated *[]string) MockECS {
+	return MockECS{
+		ListContainerInstancesFn: func(input *ecs.ListContainerInstancesInput) (*ecs.ListContainerInstancesOutput, error) {
+			switch aws.StringValue(input.Status) {
+			case ecs.ContainerInstanceStatusActive:
+				return &ecs.ListContainerInstancesOutput{ContainerInstanceArns: aws.StringSlice([]string{"cont-inst-id-1"})}, nil
+			case ecs.ContainerInstanceStatusDraining:
+				return &ecs.ListContainerInstancesOutput{ContainerInstanceArns: aws.StringSlice([]string{"cont-inst-id-2"})}, nil
+			}
+			t.Errorf("unexpected status %q", aws.StringValue(input.Status))
+			return &ecs.ListContainerInstancesOutput{}, nil
+		},
+		DescribeContainerInstancesFn: func(input *ecs.DescribeContainerInstancesInput) (*ecs.DescribeContainerInstancesOutput, error) {
+			output := &ecs.DescribeContainerInstancesOutput{}
+			for _, arn := range aws.StringValueSlice(input.ContainerInstances) {
+				id := strings.TrimPrefix(arn, "cont-")
+				status := ecs.ContainerInstanceStatusActive
+				attrs := []*ecs.Attribute{{Name: aws.String(variantAttribute), Value: aws.String("aws-ecs-1")}}
+				if id == "inst-id-2" {
+					status = ecs.ContainerInstanceStatusDraining
+					attrs = append(attrs,
+						&ecs.Attribute{Name: aws.String(attributeLastResult), Value: aws.String("failed")},
+						&ecs.Attribute{Name: aws.String(attributeFailureCount), Value: aws.String("2")},
+						&ecs.Attribute{Name: aws.String(attributeDrained), Value: aws.String("2021-06-01T00:00:00Z")})
+				}
+				output.ContainerInstances = append(output.ContainerInstances, &ecs.ContainerInstance{
+					Ec2InstanceId:        aws.String(id),
+					ContainerInstanceArn: aws.String(arn),
+					Status:               aws.String(status),
+					Attributes:           attrs,
+				})
+			}
+			return output, nil
+		},
+		UpdateContainerInstancesStateFn: func(input *ecs.UpdateContainerInstancesStateInput) (*ecs.UpdateContainerInstancesStateOutput, error) {
+			assert.Equal(t, ecs.ContainerInstanceStatusActive, aws.StringValue(input.Status))
+			*activated = append(*activated, aws.StringValueSlice(input.ContainerInstances)...)
+			return &ecs.UpdateContainerInstancesStateOutput{}, nil
+		},
+	}
+}
+
+func commandTestSSM(t *testing.T) MockSSM {
+	return MockSSM{
+		SendCommandFn: func(input *ssm.SendCommandInput) (*ssm.SendCommandOutput, error) {
+			assert.Equal(t, "check-doc", aws.StringValue(input.DocumentName))
+			return &ssm.SendCommandOutput{Command: &ssm.Command{CommandId: aws.String("command-id")}}, nil
+		},
+		WaitUntilCommandExecutedWithContextFn: func(ctx aws.Context, input *ssm.GetCommandInvocationInput, opts ...request.WaiterOption) error {
+			return nil
+		},
+		GetCommandInvocationFn: func(input *ssm.GetCommandInvocationInput) (*ssm.GetCommandInvocationOutput, error) {
+			output := `{"update_state": "Idle", "active_partition": {"image": {"version": "1.0.6"}}}`
+			if aws.StringValue(input.InstanceId) == "inst-id-1" {
+				output = `{"update_state": "Available", "active_partition": {"image": {"version": "1.0.5"}}, "chosen_update": {"version": "1.0.6"}}`
+			}
+			return &ssm.GetCommandInvocationOutput{StandardOutputContent: aws.String(output)}, nil
+		},
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+	activated := []string{}
+	u := &clusterUpdater{cluster: "test-cluster", checkDocument: "check-doc", ecs: commandTestECS(t, &activated), ssm: commandTestSSM(t)}
+	out := &bytes.Buffer{}
+	require.NoError(t, u.printStatus(out, nil))
+	assert.Equal(t, ""+
+		"CLUSTER       INSTANCE   STATUS    VARIANT    VERSION  UPDATE STATE  AVAILABLE  LAST RESULT  FAILURES  QUARANTINED\n"+
+		"test-cluster  inst-id-1  ACTIVE    aws-ecs-1  1.0.5    Available     1.0.6      -            0         -\n"+
+		"test-cluster  inst-id-2  DRAINING  aws-ecs-1  1.0.6    Idle          -          failed       2         -\n", out.String())
+
+	out.Reset()
+	require.NoError(t, u.printStatus(out, []string{"inst-id-2"}))
+	assert.Equal(t, 2, strings.Count(out.String(), "\n"), "only the header and the selected instance are shown")
+}
+
+func TestPrintCheck(t *testing.T) {
+	activated := []string{}
+	u := &clusterUpdater{cluster: "test-cluster", checkDocument: "check-doc", ecs: commandTestECS(t, &activated), ssm: commandTestSSM(t)}
+	out := &bytes.Buffer{}
+	require.NoError(t, u.printCheck(out, nil))
+	assert.Equal(t, `test-cluster inst-id-1: {"update_state": "Available", "active_partition": {"image": {"version": "1.0.5"}}, "chosen_update": {"version": "1.0.6"}}`+"\n", out.String())
+
+	err := u.printCheck(out, []string{"inst-id-2"})
+	assert.Error(t, err, "draining instances are not checked")
+}
+
+func TestReactivate(t *testing.T) {
+	activated := []string{}
+	cleared := []string{}
+	mockECS := commandTestECS(t, &activated)
+	// inst-id-3 was drained by an operator and has no drain marker.
+	listContainerInstances := mockECS.ListContainerInstancesFn
+	mockECS.ListContainerInstancesFn = func(input *ecs.ListContainerInstancesInput) (*ecs.ListContainerInstancesOutput, error) {
+		output, err := listContainerInstances(input)
+		if aws.StringValue(input.Status) == ecs.ContainerInstanceStatusDraining {
+			output.ContainerInstanceArns = append(output.ContainerInstanceArns, aws.String("cont-inst-id-3"))
+		}
+		return output, err
+	}
+	describeContainerInstances := mockECS.DescribeContainerInstancesFn
+	mockECS.DescribeContainerInstancesFn = func(input *ecs.DescribeContainerInstancesInput) (*ecs.DescribeContainerInstancesOutput, error) {
+		output, err := describeContainerInstances(input)
+		for _, containerInstance := range output.ContainerInstances {
+			if aws.StringValue(containerInstance.Ec2InstanceId) == "inst-id-3" {
+				containerInstance.Status = aws.String(ecs.ContainerInstanceStatusDraining)
+			}
+		}
+		return output, err
+	}
+	mockECS.DeleteAttributesFn = func(input *ecs.DeleteAttributesInput) (*ecs.DeleteAttributesOutput, error) {
+		for _, attr := range input.Attributes {
+			assert.Equal(t, attributeDrained, aws.StringValue(attr.Name))
+			cleared = append(cleared, aws.StringValue(attr.TargetId))
+		}
+		return &ecs.DeleteAttributesOutput{}, nil
+	}
+	u := &clusterUpdater{cluster: "test-cluster", ecs: mockECS}
+	require.NoError(t, u.reactivate(nil))
+	assert.Equal(t, []string{"cont-inst-id-2"}, activated, "only instances drained by the updater are reactivated")
+	assert.Equal(t, []string{"cont-inst-id-2"}, cleared)
+
+	activated = activated[:0]
+	err := u.reactivate([]string{"inst-id-3"})
+	assert.Error(t, err, "instances drained by an operator are left alone")
+	err = u.reactivate([]string{"inst-id-1"})
+	assert.Error(t, err, "only draining instances are reactivated")
+	assert.Empty(t, activated)
+}
+
+func TestDrainOnlyNonService(t *testing.T) {
+	activated := []string{}
+	mockECS := commandTestECS(t, &activated)
+	mockECS.ListTasksFn = func(input *ecs.ListTasksInput) (*ecs.ListTasksOutput, error) {
+		return &ecs.ListTasksOutput{TaskArns: aws.StringSlice([]string{"task-arn-1"})}, nil
+	}
+	mockECS.DescribeTasksFn = func(input *ecs.DescribeTasksInput) (*ecs.DescribeTasksOutput, error) {
+		return &ecs.DescribeTasksOutput{Tasks: []*ecs.Task{{StartedBy: aws.String("standalone")}}}, nil
+	}
+	u := &clusterUpdater{cluster: "test-cluster", ecs: mockECS}
+	err := u.drainOnly(context.Background(), []string{"inst-id-1"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "non-service tasks")
+	assert.Empty(t, activated)
+
+	err = u.drainOnly(context.Background(), []string{"inst-id-2"})
+	assert.Error(t, err, "draining instances cannot be drained again")
+}
+
+func TestCycleOnlyMissing(t *testing.T) {
+	u := planTestUpdater(t, []planTestInstance{
+		{id: "inst-id-1", version: "1.0.5", target: "1.0.6"},
+		{id: "inst-id-2", version: "1.0.6"},
+	})
+	u.only = []string{"inst-id-2"}
+	report := newRunReport("test-cluster")
+	err := u.cycle(context.Background(), report)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `["inst-id-2"]`)
+	assert.Empty(t, report.results, "nothing is updated when a requested instance cannot be")
+}
@@ -0,0 +1,63 @@
+package updater
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// DefaultOptOutAttribute is the ECS attribute operators set to keep an instance off the updater.
+const DefaultOptOutAttribute = attributePrefix + "skip"
+
+// optOutActive reports whether an opt-out marker with the given value is in effect at now. The
+// value is either "true", which opts out indefinitely, "false", or an RFC 3339 timestamp at which
+// the opt-out lapses. Any other value is treated as an indefinite opt-out so that a mistyped
+// marker never causes an instance to be drained; the returned message explains the decision.
+func optOutActive(value string, now time.Time) (bool, string) {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(value) {
+	case "true":
+		return true, "opted out of updates"
+	case "false", "":
+		return false, ""
+	}
+	until, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return true, fmt.Sprintf("opted out of updates with unrecognized value %q; expected true, false or an RFC 3339 expiry time", value)
+	}
+	if !now.Before(until) {
+		return false, fmt.Sprintf("opt-out expired at %s", until.Format(time.RFC3339))
+	}
+	return true, fmt.Sprintf("opted out of updates until %s", until.Format(time.RFC3339))
+}
+
+// optedOut reports whether an operator opted the instance out of updates with the opt-out
+// attribute or tag, logging the decision.
+func (u *clusterUpdater) optedOut(inst instance, now time.Time) bool {
+	markers := []struct {
+		kind, name string
+		values     map[string]string
+	}{
+		{kind: "attribute", name: u.optOutAttribute, values: inst.attributes},
+		{kind: "tag", name: u.optOutTag, values: inst.tags},
+	}
+	for _, m := range markers {
+		if m.name == "" {
+			continue
+		}
+		value, ok := m.values[m.name]
+		if !ok {
+			continue
+		}
+		active, msg := optOutActive(value, now)
+		if active {
+			log.Printf("Excluding instance %q: %s by %s %q", inst.instanceID, msg, m.kind, m.name)
+			return true
+		}
+		if msg != "" {
+			log.Printf("Instance %q: %s by %s %q", inst.instanceID, msg, m.kind, m.name)
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package updater
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptOutActive(t *testing.T) {
+	now := time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		value  string
+		active bool
+	}{
+		{value: "true", active: true},
+		{value: "TRUE", active: true},
+		{value: "false", active: false},
+		{value: "", active: false},
+		{value: "2021-03-05T00:00:00Z", active: true},
+		{value: "2021-03-04T13:00:00+02:00", active: false},
+		{value: "2021-03-04T12:00:00Z", active: false},
+		{value: "next week", active: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			active, _ := optOutActive(tc.value, now)
+			assert.Equal(t, tc.active, active)
+		})
+	}
+}
+
+func TestFilterBottlerocketInstancesOptOut(t *testing.T) {
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	bottlerocket := func(id string, attrs ...*ecs.Attribute) *ecs.ContainerInstance {
+		return &ecs.ContainerInstance{
+			Attributes:           append([]*ecs.Attribute{{Name: aws.String("bottlerocket.variant")}}, attrs...),
+			ContainerInstanceArn: aws.String("cont-inst-" + id),
+			Ec2InstanceId:        aws.String("ec2-id-" + id),
+		}
+	}
+	skip := func(value string) *ecs.Attribute {
+		return &ecs.Attribute{Name: aws.String("bottlerocket.updater.skip"), Value: aws.String(value)}
+	}
+	mockECS := MockECS{
+		DescribeContainerInstancesFn: func(_ *ecs.DescribeContainerInstancesInput) (*ecs.DescribeContainerInstancesOutput, error) {
+			return &ecs.DescribeContainerInstancesOutput{ContainerInstances: []*ecs.ContainerInstance{
+				bottlerocket("attr-true", skip("true")),
+				bottlerocket("attr-false", skip("false")),
+				bottlerocket("attr-future", skip(future)),
+				bottlerocket("attr-past", skip(past)),
+				bottlerocket("tag-true"),
+				bottlerocket("tag-past"),
+				bottlerocket("none"),
+			}}, nil
+		},
+	}
+	var tagInput *ec2.DescribeTagsInput
+	mockEC2 := MockEC2{
+		DescribeTagsFn: func(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
+			tagInput = input
+			return &ec2.DescribeTagsOutput{Tags: []*ec2.TagDescription{
+				{ResourceId: aws.String("ec2-id-tag-true"), Key: aws.String("updater-skip"), Value: aws.String("true")},
+				{ResourceId: aws.String("ec2-id-tag-past"), Key: aws.String("updater-skip"), Value: aws.String(past)},
+			}}, nil
+		},
+	}
+
+	t.Run("attribute", func(t *testing.T) {
+		u := clusterUpdater{ecs: mockECS, optOutAttribute: DefaultOptOutAttribute}
+		actual, err := u.filterBottlerocketInstances(aws.BackgroundContext(), []*string{})
+		require.NoError(t, err)
+		ids := []string{}
+		for _, inst := range actual {
+			ids = append(ids, inst.instanceID)
+		}
+		assert.Equal(t, []string{"ec2-id-attr-false", "ec2-id-attr-past", "ec2-id-tag-true", "ec2-id-tag-past", "ec2-id-none"}, ids)
+	})
+
+	t.Run("attribute and tag", func(t *testing.T) {
+		u := clusterUpdater{ecs: mockECS, ec2: mockEC2, optOutAttribute: DefaultOptOutAttribute, optOutTag: "updater-skip"}
+		actual, err := u.filterBottlerocketInstances(aws.BackgroundContext(), []*string{})
+		require.NoError(t, err)
+		ids := []string{}
+		for _, inst := range actual {
+			ids = append(ids, inst.instanceID)
+		}
+		assert.Equal(t, []string{"ec2-id-attr-false", "ec2-id-attr-past", "ec2-id-tag-past", "ec2-id-none"}, ids)
+		require.NotNil(t, tagInput)
+		assert.Equal(t, "resource-id", aws.StringValue(tagInput.Filters[1].Name))
+		assert.Equal(t, []string{
+			"ec2-id-attr-true", "ec2-id-attr-false", "ec2-id-attr-future", "ec2-id-attr-past",
+			"ec2-id-tag-true", "ec2-id-tag-past", "ec2-id-none",
+		}, aws.StringValueSlice(tagInput.Filters[1].Values), "tags are read for every Bottlerocket instance")
+		assert.Equal(t, []string{"updater-skip"}, aws.StringValueSlice(tagInput.Filters[2].Values))
+	})
+
+	t.Run("tag error", func(t *testing.T) {
+		failingEC2 := MockEC2{
+			DescribeTagsFn: func(_ *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
+				return nil, errors.New("UnauthorizedOperation")
+			},
+		}
+		u := clusterUpdater{ecs: mockECS, ec2: failingEC2, optOutTag: "updater-skip"}
+		_, err := u.filterBottlerocketInstances(aws.BackgroundContext(), []*string{})
+		assert.Error(t, err, "instances must not be updated when their opt-out tags cannot be read")
+	})
+}
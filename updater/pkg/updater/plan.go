@@ -0,0 +1,257 @@
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// planVersion is the version of the plan document format.
+const planVersion = 1
+
+// Plan is the plan returned by Updater.Plan and carried out by Run when set in Options: the
+// exact instances to update in each cluster, in order.
+type Plan struct {
+	Version  int           `json:"version"`
+	Created  time.Time     `json:"created"`
+	Clusters []ClusterPlan `json:"clusters"`
+}
+
+// ClusterPlan is the plan for one cluster. Instances are updated in order, one batch at a time;
+// as the updater drains one instance at a time, each batch holds a single instance.
+type ClusterPlan struct {
+	Cluster   string            `json:"cluster"`
+	RoleARN   string            `json:"roleArn,omitempty"`
+	Region    string            `json:"region,omitempty"`
+	Instances []PlannedInstance `json:"instances"`
+	// Skipped lists the instances with an update available that the plan leaves out, and why.
+	Skipped []PlannedSkip `json:"skipped,omitempty"`
+}
+
+// PlannedInstance is an instance the plan updates.
+type PlannedInstance struct {
+	Order                int    `json:"order"`
+	Batch                int    `json:"batch"`
+	InstanceID           string `json:"instanceId"`
+	ContainerInstanceARN string `json:"containerInstanceArn"`
+	Variant              string `json:"variant,omitempty"`
+	CurrentVersion       string `json:"currentVersion"`
+	TargetVersion        string `json:"targetVersion"`
+}
+
+// PlannedSkip is an instance with an update available that the plan leaves out.
+type PlannedSkip struct {
+	InstanceID           string `json:"instanceId"`
+	ContainerInstanceARN string `json:"containerInstanceArn"`
+	Reason               string `json:"reason"`
+}
+
+func (p ClusterPlan) target() target {
+	return target{roleARN: p.RoleARN, region: p.Region, cluster: p.Cluster}
+}
+
+// plan checks the cluster for updates and returns the instances a run would update now, leaving
+// out those it would skip. Nothing in the cluster is changed.
+func (u *clusterUpdater) plan() (ClusterPlan, error) {
+	p := ClusterPlan{
+		Cluster:   u.cluster,
+		Instances: make([]PlannedInstance, 0),
+	}
+	_, candidates, err := u.candidates(aws.BackgroundContext())
+	if err != nil {
+		return p, err
+	}
+	remote := u.remote.current()
+	for _, i := range candidates {
+		reason := ""
+		switch {
+		case i.quarantinedSince != "":
+			reason = skipQuarantined
+		case remote.blocks(i.targetVersion):
+			reason = skipBlockedVersion
+		default:
+			eligible, err := u.eligible(aws.BackgroundContext(), i.containerInstanceID)
+			if err != nil {
+				return p, fmt.Errorf("failed to determine eligibility for update of instance %#q: %w", i, err)
+			}
+			if !eligible {
+				reason = skipNonServiceTask
+			}
+		}
+		if reason != "" {
+			p.Skipped = append(p.Skipped, PlannedSkip{
+				InstanceID:           i.instanceID,
+				ContainerInstanceARN: i.containerInstanceID,
+				Reason:               reason,
+			})
+			continue
+		}
+		n := len(p.Instances) + 1
+		p.Instances = append(p.Instances, PlannedInstance{
+			Order:                n,
+			Batch:                n,
+			InstanceID:           i.instanceID,
+			ContainerInstanceARN: i.containerInstanceID,
+			Variant:              i.variant,
+			CurrentVersion:       i.bottlerocketVersion,
+			TargetVersion:        i.targetVersion,
+		})
+	}
+	return p, nil
+}
+
+// followPlan compares the cluster with the plan being applied and returns the planned instances,
+// in plan order. It returns an error describing every difference that matters if the cluster has
+// drifted from the plan, in which case nothing must be updated.
+func (u *clusterUpdater) followPlan(instances, candidates []instance) ([]instance, error) {
+	found := make(map[string]instance, len(instances))
+	for _, inst := range instances {
+		found[inst.containerInstanceID] = inst
+	}
+	available := make(map[string]instance, len(candidates))
+	for _, inst := range candidates {
+		available[inst.containerInstanceID] = inst
+	}
+
+	planned := make([]instance, 0, len(u.planned.Instances))
+	inPlan := make(map[string]bool, len(u.planned.Instances))
+	drift := make([]string, 0)
+	for _, p := range u.planned.Instances {
+		inPlan[p.ContainerInstanceARN] = true
+		inst, ok := found[p.ContainerInstanceARN]
+		candidate, hasUpdate := available[p.ContainerInstanceARN]
+		switch {
+		case !ok || inst.instanceID != p.InstanceID:
+			drift = append(drift, fmt.Sprintf("instance %s is no longer an active Bottlerocket container instance selected for updates", p.InstanceID))
+		case inst.bottlerocketVersion != p.CurrentVersion:
+			drift = append(drift, fmt.Sprintf("instance %s version changed from %s to %s", p.InstanceID, p.CurrentVersion, inst.bottlerocketVersion))
+		case !hasUpdate:
+			drift = append(drift, fmt.Sprintf("instance %s no longer has an update available", p.InstanceID))
+		case candidate.targetVersion != p.TargetVersion:
+			drift = append(drift, fmt.Sprintf("instance %s update changed from version %s to %s", p.InstanceID, p.TargetVersion, candidate.targetVersion))
+		default:
+			eligible, err := u.eligible(aws.BackgroundContext(), candidate.containerInstanceID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to determine eligibility for update of instance %#q: %w", candidate, err)
+			}
+			if !eligible {
+				drift = append(drift, fmt.Sprintf("instance %s is running non-service tasks", p.InstanceID))
+				continue
+			}
+			planned = append(planned, candidate)
+		}
+	}
+	if len(drift) != 0 {
+		return nil, fmt.Errorf("cluster %q has drifted from the plan, refusing to apply it: %s", u.cluster, strings.Join(drift, "; "))
+	}
+	for _, inst := range candidates {
+		if !inPlan[inst.containerInstanceID] {
+			log.Printf("Instance %#q has an update available but is not in the plan", inst)
+		}
+	}
+	log.Printf("Cluster %q matches the plan, applying it to %d instances", u.cluster, len(planned))
+	return planned, nil
+}
+
+// plan checks every cluster for updates and returns the plan. The plan fails if any cluster
+// cannot be checked, since an incomplete plan could not be reviewed.
+func (f *fleet) plan() (*Plan, error) {
+	f.remote.load()
+	clusters, err := f.clusters()
+	if err != nil {
+		return nil, err
+	}
+	doc := &Plan{
+		Version:  planVersion,
+		Created:  time.Now().UTC(),
+		Clusters: make([]ClusterPlan, 0, len(clusters)),
+	}
+	for _, t := range clusters {
+		u, err := f.updater(t)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %q: %w", t, err)
+		}
+		p, err := u.plan()
+		if err != nil {
+			return nil, fmt.Errorf("cluster %q: %w", t, err)
+		}
+		p.RoleARN = t.roleARN
+		p.Region = t.region
+		log.Printf("Plan for cluster %q: %d instances to update, %d skipped", t, len(p.Instances), len(p.Skipped))
+		doc.Clusters = append(doc.Clusters, p)
+	}
+	return doc, nil
+}
+
+// targets returns the clusters of the plan.
+func (d *Plan) targets() []target {
+	targets := make([]target, 0, len(d.Clusters))
+	for _, p := range d.Clusters {
+		targets = append(targets, p.target())
+	}
+	return targets
+}
+
+// cluster returns the plan for the target, or nil if the plan does not cover it.
+func (d *Plan) cluster(t target) *ClusterPlan {
+	for i := range d.Clusters {
+		if d.Clusters[i].target() == t {
+			return &d.Clusters[i]
+		}
+	}
+	return nil
+}
+
+// WritePlan writes the plan as JSON to path, or to w if path is empty.
+func WritePlan(doc *Plan, path string, w io.Writer) error {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "" {
+		_, err = w.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// ReadPlan reads and validates a plan written by WritePlan.
+func ReadPlan(path string) (*Plan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+	doc := &Plan{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %w", path, err)
+	}
+	if doc.Version != planVersion {
+		return nil, fmt.Errorf("unsupported plan version %d in %s, expected %d", doc.Version, path, planVersion)
+	}
+	if len(doc.Clusters) == 0 {
+		return nil, fmt.Errorf("plan %s has no clusters", path)
+	}
+	seen := make(map[target]bool)
+	for _, p := range doc.Clusters {
+		if p.Cluster == "" {
+			return nil, fmt.Errorf("plan %s has a cluster without a name", path)
+		}
+		if seen[p.target()] {
+			return nil, fmt.Errorf("plan %s has cluster %q more than once", path, p.Cluster)
+		}
+		seen[p.target()] = true
+		for _, inst := range p.Instances {
+			if inst.InstanceID == "" || inst.ContainerInstanceARN == "" {
+				return nil, fmt.Errorf("plan %s has an instance without an ID in cluster %q", path, p.Cluster)
+			}
+		}
+	}
+	return doc, nil
+}
@@ -0,0 +1,278 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// planTestInstance is a container instance in the cluster mocked by planTestUpdater.
+type planTestInstance struct {
+	id          string
+	version     string
+	target      string
+	nonService  bool
+	quarantined bool
+}
+
+// planTestUpdater returns an updater for a cluster holding the instances. Instances without a
+// target version have no update available.
+func planTestUpdater(t *testing.T, instances []planTestInstance) *clusterUpdater {
+	byID := make(map[string]planTestInstance)
+	arns := make([]*string, 0)
+	for _, inst := range instances {
+		byID[inst.id] = inst
+		arns = append(arns, aws.String("cont-"+inst.id))
+	}
+	mockECS := MockECS{
+		ListContainerInstancesFn: func(input *ecs.ListContainerInstancesInput) (*ecs.ListContainerInstancesOutput, error) {
+			return &ecs.ListContainerInstancesOutput{ContainerInstanceArns: arns}, nil
+		},
+		DescribeContainerInstancesFn: func(input *ecs.DescribeContainerInstancesInput) (*ecs.DescribeContainerInstancesOutput, error) {
+			output := &ecs.DescribeContainerInstancesOutput{}
+			for _, inst := range instances {
+				attrs := []*ecs.Attribute{{Name: aws.String(variantAttribute), Value: aws.String("aws-ecs-1")}}
+				if inst.quarantined {
+					attrs = append(attrs, &ecs.Attribute{Name: aws.String(attributeQuarantined), Value: aws.String("2021-06-01T00:00:00Z")})
+				}
+				output.ContainerInstances = append(output.ContainerInstances, &ecs.ContainerInstance{
+					Ec2InstanceId:        aws.String(inst.id),
+					ContainerInstanceArn: aws.String("cont-" + inst.id),
+					Attributes:           attrs,
+				})
+			}
+			return output, nil
+		},
+		ListTasksFn: func(input *ecs.ListTasksInput) (*ecs.ListTasksOutput, error) {
+			return &ecs.ListTasksOutput{TaskArns: []*string{aws.String("task-" + aws.StringValue(input.ContainerInstance))}}, nil
+		},
+		DescribeTasksFn: func(input *ecs.DescribeTasksInput) (*ecs.DescribeTasksOutput, error) {
+			id := aws.StringValue(input.Tasks[0])[len("task-cont-"):]
+			startedBy := "ecs-svc/svc-id"
+			if byID[id].nonService {
+				startedBy = "standalone"
+			}
+			return &ecs.DescribeTasksOutput{Tasks: []*ecs.Task{{StartedBy: aws.String(startedBy)}}}, nil
+		},
+		UpdateContainerInstancesStateFn: func(input *ecs.UpdateContainerInstancesStateInput) (*ecs.UpdateContainerInstancesStateOutput, error) {
+			t.Errorf("unexpected change of state of %q", aws.StringValueSlice(input.ContainerInstances))
+			return &ecs.UpdateContainerInstancesStateOutput{}, nil
+		},
+	}
+	mockSSM := MockSSM{
+		SendCommandFn: func(input *ssm.SendCommandInput) (*ssm.SendCommandOutput, error) {
+			assert.Equal(t, "check-doc", aws.StringValue(input.DocumentName))
+			return &ssm.SendCommandOutput{Command: &ssm.Command{CommandId: aws.String("command-id")}}, nil
+		},
+		WaitUntilCommandExecutedWithContextFn: func(ctx aws.Context, input *ssm.GetCommandInvocationInput, opts ...request.WaiterOption) error {
+			return nil
+		},
+		GetCommandInvocationFn: func(input *ssm.GetCommandInvocationInput) (*ssm.GetCommandInvocationOutput, error) {
+			inst := byID[aws.StringValue(input.InstanceId)]
+			state := "Idle"
+			if inst.target != "" {
+				state = "Available"
+			}
+			output := fmt.Sprintf(`{"update_state": %q, "active_partition": {"image": {"version": %q}}, "chosen_update": {"version": %q}}`,
+				state, inst.version, inst.target)
+			return &ssm.GetCommandInvocationOutput{StandardOutputContent: aws.String(output)}, nil
+		},
+	}
+	return &clusterUpdater{
+		cluster:       "test-cluster",
+		checkDocument: "check-doc",
+		ecs:           mockECS,
+		ssm:           mockSSM,
+	}
+}
+
+func TestUpdaterPlan(t *testing.T) {
+	u := planTestUpdater(t, []planTestInstance{
+		{id: "inst-id-1", version: "1.0.5", target: "1.0.6"},
+		{id: "inst-id-2", version: "1.0.6"},
+		{id: "inst-id-3", version: "1.0.5", target: "1.0.6", nonService: true},
+		{id: "inst-id-4", version: "1.0.5", target: "1.0.6", quarantined: true},
+		{id: "inst-id-5", version: "1.0.4", target: "1.0.6"},
+	})
+	var transitions []EventType
+	u.events = &eventPublisher{
+		cluster: u.cluster,
+		hook: func(t EventType, detail EventDetail) {
+			transitions = append(transitions, t)
+		},
+	}
+	u.metrics = newMetrics()
+	p, err := u.plan()
+	require.NoError(t, err)
+	assert.Empty(t, transitions, "a plan does not publish events")
+	rec := httptest.NewRecorder()
+	u.metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), "bottlerocket_ecs_updater_candidates_total 0\n", "a plan does not count candidates")
+	assert.Equal(t, ClusterPlan{
+		Cluster: "test-cluster",
+		Instances: []PlannedInstance{
+			{Order: 1, Batch: 1, InstanceID: "inst-id-1", ContainerInstanceARN: "cont-inst-id-1", Variant: "aws-ecs-1", CurrentVersion: "1.0.5", TargetVersion: "1.0.6"},
+			{Order: 2, Batch: 2, InstanceID: "inst-id-5", ContainerInstanceARN: "cont-inst-id-5", Variant: "aws-ecs-1", CurrentVersion: "1.0.4", TargetVersion: "1.0.6"},
+		},
+		Skipped: []PlannedSkip{
+			{InstanceID: "inst-id-3", ContainerInstanceARN: "cont-inst-id-3", Reason: skipNonServiceTask},
+			{InstanceID: "inst-id-4", ContainerInstanceARN: "cont-inst-id-4", Reason: skipQuarantined},
+		},
+	}, p)
+}
+
+func TestFollowPlan(t *testing.T) {
+	planned := ClusterPlan{
+		Cluster: "test-cluster",
+		Instances: []PlannedInstance{
+			{Order: 1, Batch: 1, InstanceID: "inst-id-2", ContainerInstanceARN: "cont-inst-id-2", CurrentVersion: "1.0.5", TargetVersion: "1.0.6"},
+			{Order: 2, Batch: 2, InstanceID: "inst-id-1", ContainerInstanceARN: "cont-inst-id-1", CurrentVersion: "1.0.5", TargetVersion: "1.0.6"},
+		},
+	}
+	cases := []struct {
+		name      string
+		instances []planTestInstance
+		drift     string
+	}{
+		{
+			name: "unchanged",
+			instances: []planTestInstance{
+				{id: "inst-id-1", version: "1.0.5", target: "1.0.6"},
+				{id: "inst-id-2", version: "1.0.5", target: "1.0.6"},
+				{id: "inst-id-3", version: "1.0.5", target: "1.0.6"},
+			},
+		},
+		{
+			name:      "instance gone",
+			instances: []planTestInstance{{id: "inst-id-1", version: "1.0.5", target: "1.0.6"}},
+			drift:     "instance inst-id-2 is no longer an active Bottlerocket container instance selected for updates",
+		},
+		{
+			name: "version changed",
+			instances: []planTestInstance{
+				{id: "inst-id-1", version: "1.0.5", target: "1.0.6"},
+				{id: "inst-id-2", version: "1.0.4", target: "1.0.6"},
+			},
+			drift: "instance inst-id-2 version changed from 1.0.5 to 1.0.4",
+		},
+		{
+			name: "already updated",
+			instances: []planTestInstance{
+				{id: "inst-id-1", version: "1.0.5", target: "1.0.6"},
+				{id: "inst-id-2", version: "1.0.5"},
+			},
+			drift: "instance inst-id-2 no longer has an update available",
+		},
+		{
+			name: "target changed",
+			instances: []planTestInstance{
+				{id: "inst-id-1", version: "1.0.5", target: "1.0.7"},
+				{id: "inst-id-2", version: "1.0.5", target: "1.0.6"},
+			},
+			drift: "instance inst-id-1 update changed from version 1.0.6 to 1.0.7",
+		},
+		{
+			name: "non-service task",
+			instances: []planTestInstance{
+				{id: "inst-id-1", version: "1.0.5", target: "1.0.6", nonService: true},
+				{id: "inst-id-2", version: "1.0.5", target: "1.0.6"},
+			},
+			drift: "instance inst-id-1 is running non-service tasks",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			u := planTestUpdater(t, c.instances)
+			u.planned = &planned
+			instances, candidates, err := u.candidates(context.Background())
+			require.NoError(t, err)
+			followed, err := u.followPlan(instances, candidates)
+			if c.drift != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.drift)
+				return
+			}
+			require.NoError(t, err)
+			ids := make([]string, 0)
+			for _, inst := range followed {
+				ids = append(ids, inst.instanceID)
+			}
+			assert.Equal(t, []string{"inst-id-2", "inst-id-1"}, ids, "only the planned instances are updated, in plan order")
+		})
+	}
+}
+
+func TestCycleRefusesDriftedPlan(t *testing.T) {
+	u := planTestUpdater(t, []planTestInstance{{id: "inst-id-1", version: "1.0.6"}})
+	u.planned = &ClusterPlan{
+		Cluster: "test-cluster",
+		Instances: []PlannedInstance{
+			{Order: 1, Batch: 1, InstanceID: "inst-id-1", ContainerInstanceARN: "cont-inst-id-1", CurrentVersion: "1.0.5", TargetVersion: "1.0.6"},
+		},
+	}
+	report := newRunReport("test-cluster")
+	err := u.cycle(context.Background(), report)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "instance inst-id-1 version changed from 1.0.5 to 1.0.6")
+	assert.Equal(t, "cluster drifted from the plan", report.haltReason)
+	assert.Empty(t, report.results)
+}
+
+func TestPlanFile(t *testing.T) {
+	doc := &Plan{
+		Version: planVersion,
+		Created: time.Date(2021, 6, 1, 8, 0, 0, 0, time.UTC),
+		Clusters: []ClusterPlan{
+			{
+				Cluster:   "test-cluster",
+				Instances: []PlannedInstance{{Order: 1, Batch: 1, InstanceID: "inst-id-1", ContainerInstanceARN: "cont-inst-id-1", CurrentVersion: "1.0.5", TargetVersion: "1.0.6"}},
+			},
+			{Cluster: "other-cluster", RoleARN: "arn:aws:iam::111122223333:role/updater", Region: "us-east-1", Instances: []PlannedInstance{}},
+		},
+	}
+	path := filepath.Join(t.TempDir(), "plan.json")
+	require.NoError(t, WritePlan(doc, path, nil))
+	read, err := ReadPlan(path)
+	require.NoError(t, err)
+	assert.Equal(t, doc, read)
+	assert.Equal(t, []target{
+		{cluster: "test-cluster"},
+		{roleARN: "arn:aws:iam::111122223333:role/updater", region: "us-east-1", cluster: "other-cluster"},
+	}, read.targets())
+	assert.Equal(t, &read.Clusters[1], read.cluster(target{roleARN: "arn:aws:iam::111122223333:role/updater", region: "us-east-1", cluster: "other-cluster"}))
+	assert.Nil(t, read.cluster(target{cluster: "other-cluster"}))
+
+	out := &bytes.Buffer{}
+	require.NoError(t, WritePlan(doc, "", out))
+	written, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(written), out.String(), "without a path the plan is written to standard output")
+
+	for name, content := range map[string]string{
+		"malformed":         `{"version": 1,`,
+		"version":           `{"version": 2, "clusters": [{"cluster": "test-cluster"}]}`,
+		"no clusters":       `{"version": 1, "clusters": []}`,
+		"unnamed cluster":   `{"version": 1, "clusters": [{"instances": []}]}`,
+		"duplicate cluster": `{"version": 1, "clusters": [{"cluster": "test-cluster"}, {"cluster": "test-cluster"}]}`,
+		"unnamed instance":  `{"version": 1, "clusters": [{"cluster": "test-cluster", "instances": [{"order": 1}]}]}`,
+	} {
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		_, err := ReadPlan(path)
+		assert.Error(t, err, name)
+	}
+	_, err = ReadPlan(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,196 @@
+package updater
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// The settings that can be changed at runtime, each read from the parameter of the same name
+// under the remote configuration path.
+const (
+	remotePaused          = "paused"
+	remoteBlockedVersions = "blocked-versions"
+	remoteParallel        = "parallel-clusters"
+	remoteFailureBudget   = "failure-budget"
+)
+
+// skipBlockedVersion is the reason recorded for instances whose update is to a blocked version.
+const skipBlockedVersion = "blocked-version"
+
+// remoteSettings are the settings loaded from SSM Parameter Store. Settings without a parameter
+// keep the value configured when the updater started.
+type remoteSettings struct {
+	// paused stops the updater from starting further instances.
+	paused bool
+	// blockedVersions holds patterns of Bottlerocket versions that instances are not updated to.
+	blockedVersions []string
+	// parallel is the number of clusters updated at the same time, or 0 if not set.
+	parallel int
+	// budget is the failure budget, or nil if not set.
+	budget *failureBudget
+}
+
+// parseRemoteSettings validates the parameters, keyed by the last element of their name, and
+// returns every problem found.
+func parseRemoteSettings(params map[string]string) (remoteSettings, []error) {
+	var s remoteSettings
+	errs := make([]error, 0)
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := strings.TrimSpace(params[name])
+		switch name {
+		case remotePaused:
+			paused, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s %q: must be true or false", name, value))
+			}
+			s.paused = paused
+		case remoteBlockedVersions:
+			for _, pattern := range SplitList(value) {
+				if _, err := path.Match(pattern, ""); err != nil {
+					errs = append(errs, fmt.Errorf("invalid %s pattern %q: %w", name, pattern, err))
+				}
+				s.blockedVersions = append(s.blockedVersions, pattern)
+			}
+		case remoteParallel:
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				errs = append(errs, fmt.Errorf("invalid %s %q: must be a number of at least 1", name, value))
+			}
+			s.parallel = n
+		case remoteFailureBudget:
+			budget, err := parseFailureBudget(value)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			s.budget = &budget
+		default:
+			errs = append(errs, fmt.Errorf("unknown parameter %q", name))
+		}
+	}
+	if len(errs) != 0 {
+		return remoteSettings{}, errs
+	}
+	return s, nil
+}
+
+// blocks reports whether updates to the version are blocked.
+func (s remoteSettings) blocks(version string) bool {
+	for _, pattern := range s.blockedVersions {
+		if ok, _ := path.Match(pattern, version); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// budgetOr returns the remote failure budget if one is set, and b otherwise.
+func (s remoteSettings) budgetOr(b failureBudget) failureBudget {
+	if s.budget != nil {
+		return *s.budget
+	}
+	return b
+}
+
+// remoteConfig loads settings from the parameters under an SSM Parameter Store path. When the
+// parameters cannot be read or are invalid, the last known good settings stay in effect. A nil
+// remoteConfig always returns the zero settings.
+type remoteConfig struct {
+	ssm  SSMAPI
+	path string
+
+	mu     sync.Mutex
+	good   remoteSettings
+	loaded bool
+}
+
+func newRemoteConfig(ssmClient SSMAPI, path string) *remoteConfig {
+	return &remoteConfig{ssm: ssmClient, path: strings.TrimSuffix(path, "/")}
+}
+
+// current returns the settings in effect without reloading them.
+func (r *remoteConfig) current() remoteSettings {
+	if r == nil {
+		return remoteSettings{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.good
+}
+
+// load reloads the settings and returns the settings in effect.
+func (r *remoteConfig) load() remoteSettings {
+	if r == nil {
+		return remoteSettings{}
+	}
+	params, err := r.parameters()
+	if err != nil {
+		log.Printf("Failed to load remote configuration from %s, keeping the last known good configuration: %v", r.path, err)
+		return r.current()
+	}
+	s, errs := parseRemoteSettings(params)
+	if len(errs) != 0 {
+		log.Printf("Invalid remote configuration in %s, keeping the last known good configuration: %v", r.path, &ConfigError{Problems: errs})
+		return r.current()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.loaded || s.describe() != r.good.describe() {
+		log.Printf("Loaded remote configuration from %s: %s", r.path, s.describe())
+	}
+	r.good = s
+	r.loaded = true
+	return s
+}
+
+// describe returns a summary of the settings for the log.
+func (s remoteSettings) describe() string {
+	parts := []string{fmt.Sprintf("%s=%t", remotePaused, s.paused)}
+	if len(s.blockedVersions) != 0 {
+		parts = append(parts, fmt.Sprintf("%s=%s", remoteBlockedVersions, strings.Join(s.blockedVersions, ",")))
+	}
+	if s.parallel != 0 {
+		parts = append(parts, fmt.Sprintf("%s=%d", remoteParallel, s.parallel))
+	}
+	if s.budget != nil {
+		parts = append(parts, fmt.Sprintf("%s=%s", remoteFailureBudget, *s.budget))
+	}
+	return strings.Join(parts, " ")
+}
+
+// parameters returns the parameters directly under the path, keyed by the last element of
+// their name.
+func (r *remoteConfig) parameters() (map[string]string, error) {
+	params := make(map[string]string)
+	input := &ssm.GetParametersByPathInput{
+		Path:           aws.String(r.path),
+		WithDecryption: aws.Bool(true),
+	}
+	for {
+		resp, err := r.ssm.GetParametersByPath(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range resp.Parameters {
+			name := aws.StringValue(p.Name)
+			params[name[strings.LastIndex(name, "/")+1:]] = aws.StringValue(p.Value)
+		}
+		if aws.StringValue(resp.NextToken) == "" {
+			return params, nil
+		}
+		input.NextToken = resp.NextToken
+	}
+}
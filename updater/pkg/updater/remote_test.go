@@ -0,0 +1,95 @@
+package updater
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRemoteSettings(t *testing.T) {
+	s, errs := parseRemoteSettings(map[string]string{
+		remotePaused:          "true",
+		remoteBlockedVersions: "1.2.0, 1.3.*",
+		remoteParallel:        "4",
+		remoteFailureBudget:   "10%",
+	})
+	require.Empty(t, errs)
+	assert.True(t, s.paused)
+	assert.Equal(t, []string{"1.2.0", "1.3.*"}, s.blockedVersions)
+	assert.Equal(t, 4, s.parallel)
+	assert.Equal(t, failureBudget{percent: 10}, s.budgetOr(failureBudget{count: 3}))
+	assert.True(t, s.blocks("1.2.0"))
+	assert.True(t, s.blocks("1.3.1"))
+	assert.False(t, s.blocks("1.2.1"))
+
+	s, errs = parseRemoteSettings(map[string]string{})
+	require.Empty(t, errs)
+	assert.Equal(t, remoteSettings{}, s)
+	assert.Equal(t, failureBudget{count: 3}, s.budgetOr(failureBudget{count: 3}))
+	assert.False(t, s.blocks("1.2.0"))
+
+	_, errs = parseRemoteSettings(map[string]string{
+		remotePaused:          "sometimes",
+		remoteBlockedVersions: "1.2.[",
+		remoteParallel:        "0",
+		remoteFailureBudget:   "none",
+		"concurrency":         "2",
+	})
+	assert.Len(t, errs, 5, "every problem should be reported")
+}
+
+func TestRemoteConfigLoad(t *testing.T) {
+	var params []*ssm.Parameter
+	var fail bool
+	calls := 0
+	r := newRemoteConfig(MockSSM{
+		GetParametersByPathFn: func(input *ssm.GetParametersByPathInput) (*ssm.GetParametersByPathOutput, error) {
+			calls++
+			assert.Equal(t, "/updater/prod", aws.StringValue(input.Path))
+			assert.True(t, aws.BoolValue(input.WithDecryption))
+			if fail {
+				return nil, errors.New("throttled")
+			}
+			// The first parameter is returned on a page of its own.
+			switch {
+			case aws.StringValue(input.NextToken) == "next":
+				return &ssm.GetParametersByPathOutput{Parameters: params[1:]}, nil
+			case len(params) > 1:
+				return &ssm.GetParametersByPathOutput{Parameters: params[:1], NextToken: aws.String("next")}, nil
+			default:
+				return &ssm.GetParametersByPathOutput{Parameters: params}, nil
+			}
+		},
+	}, "/updater/prod/")
+
+	params = []*ssm.Parameter{
+		{Name: aws.String("/updater/prod/paused"), Value: aws.String("false")},
+		{Name: aws.String("/updater/prod/blocked-versions"), Value: aws.String("1.2.0")},
+	}
+	s := r.load()
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, remoteSettings{blockedVersions: []string{"1.2.0"}}, s)
+	assert.Equal(t, s, r.current())
+
+	// Invalid parameters leave the last known good settings in effect.
+	params = []*ssm.Parameter{{Name: aws.String("/updater/prod/paused"), Value: aws.String("maybe")}}
+	assert.Equal(t, remoteSettings{blockedVersions: []string{"1.2.0"}}, r.load())
+
+	// So do failures to read them.
+	fail = true
+	assert.Equal(t, remoteSettings{blockedVersions: []string{"1.2.0"}}, r.load())
+
+	fail = false
+	params = []*ssm.Parameter{{Name: aws.String("/updater/prod/paused"), Value: aws.String("true")}}
+	assert.Equal(t, remoteSettings{paused: true}, r.load())
+}
+
+func TestNilRemoteConfig(t *testing.T) {
+	var r *remoteConfig
+	assert.Equal(t, remoteSettings{}, r.load())
+	assert.Equal(t, remoteSettings{}, r.current())
+}
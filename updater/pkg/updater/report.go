@@ -0,0 +1,202 @@
+package updater
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Outcome describes what happened to a candidate instance during a run.
+type Outcome string
+
+const (
+	OutcomeUpdated Outcome = "updated"
+	OutcomeSkipped Outcome = "skipped"
+	OutcomeFailed  Outcome = "failed"
+)
+
+// Phase identifies the step of the update workflow an instance was in when it
+// was skipped or failed.
+type Phase string
+
+const (
+	PhaseEligibility Phase = "eligibility"
+	PhaseDrain       Phase = "drain"
+	PhaseUpdate      Phase = "update"
+	PhaseActivate    Phase = "activate"
+	PhaseVerify      Phase = "verify"
+)
+
+// Reasons for skipping an instance, also used as metric labels.
+const (
+	skipNonServiceTask = "non-service-task"
+	skipBackoff        = "backoff"
+	skipQuarantined    = "quarantined"
+	skipShutdown       = "shutdown"
+	// skipEligibilityError is the reason for instances whose eligibility could not be checked,
+	// for example because the ECS API throttled the updater. It is not the instance's failure,
+	// so it does not count towards the failure budget, backoff or quarantine.
+	skipEligibilityError = "eligibility-check-failed"
+	// skipHook is the reason for instances the before-drain hook refused.
+	skipHook = "refused-by-hook"
+)
+
+// instanceResult records the outcome of processing a single candidate instance.
+type instanceResult struct {
+	instance instance
+	outcome  Outcome
+	phase    Phase
+	reason   string
+	// drainTime and updateTime are set for instances that were drained and updated respectively.
+	drainTime  time.Duration
+	updateTime time.Duration
+}
+
+// runReport collects the results of a single updater run.
+type runReport struct {
+	cluster    string
+	start      time.Time
+	end        time.Time
+	candidates int
+	results    []instanceResult
+	// haltReason is set when the rollout was stopped before all candidates were processed.
+	haltReason string
+	// paused is set when the rollout was stopped because the updater was paused remotely.
+	paused bool
+	// awaitingApproval holds the ID of the plan that expired before it was approved.
+	awaitingApproval string
+}
+
+func newRunReport(cluster string) *runReport {
+	return &runReport{
+		cluster: cluster,
+		start:   time.Now(),
+	}
+}
+
+// record adds the result of processing an instance to the report. The returned result is only
+// valid until the next call to record.
+func (r *runReport) record(inst instance, o Outcome, p Phase, reason string) *instanceResult {
+	r.results = append(r.results, instanceResult{
+		instance: inst,
+		outcome:  o,
+		phase:    p,
+		reason:   reason,
+	})
+	return &r.results[len(r.results)-1]
+}
+
+// halt marks the rollout as stopped with the given reason.
+func (r *runReport) halt(reason string) {
+	r.haltReason = reason
+}
+
+// pause marks the rollout as stopped because the updater was paused, with the given reason.
+func (r *runReport) pause(reason string) {
+	r.haltReason = reason
+	r.paused = true
+}
+
+// awaitApproval marks the rollout as stopped because the plan with the given ID was not
+// approved in time.
+func (r *runReport) awaitApproval(id string) {
+	r.haltReason = fmt.Sprintf("plan %s awaiting approval", id)
+	r.awaitingApproval = id
+}
+
+// halted reports whether the rollout was stopped early.
+func (r *runReport) halted() bool {
+	return r.haltReason != ""
+}
+
+// count returns the number of instances with the given outcome.
+func (r *runReport) count(o Outcome) int {
+	n := 0
+	for _, res := range r.results {
+		if res.outcome == o {
+			n++
+		}
+	}
+	return n
+}
+
+// log writes a summary of the run to the log.
+func (r *runReport) log() {
+	if r.end.IsZero() {
+		r.end = time.Now()
+	}
+	log.Printf("Run summary for cluster %q: %d candidates, %d updated, %d skipped, %d failed in %s",
+		r.cluster, r.candidates, r.count(OutcomeUpdated), r.count(OutcomeSkipped), r.count(OutcomeFailed),
+		r.end.Sub(r.start).Round(time.Second))
+	for _, res := range r.results {
+		if res.outcome == OutcomeUpdated {
+			log.Printf("Instance %q: %s", res.instance.instanceID, res.outcome)
+			continue
+		}
+		log.Printf("Instance %q: %s during %s: %s", res.instance.instanceID, res.outcome, res.phase, res.reason)
+	}
+	switch {
+	case r.paused:
+		log.Printf("Rollout paused: %s", r.haltReason)
+	case r.awaitingApproval != "":
+		log.Printf("Rollout awaiting approval: %s", r.haltReason)
+	case r.halted():
+		log.Printf("Rollout halted: %s", r.haltReason)
+	}
+}
+
+// InstanceSummary is the serializable form of an instanceResult.
+type InstanceSummary struct {
+	InstanceID           string  `json:"instanceId"`
+	ContainerInstanceARN string  `json:"containerInstanceArn"`
+	Outcome              Outcome `json:"outcome"`
+	Phase                Phase   `json:"phase,omitempty"`
+	Reason               string  `json:"reason,omitempty"`
+}
+
+// RunSummary is the serializable form of a runReport.
+type RunSummary struct {
+	Cluster     string    `json:"cluster"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Candidates  int       `json:"candidates"`
+	Updated     int       `json:"updated"`
+	Skipped     int       `json:"skipped"`
+	Failed      int       `json:"failed"`
+	Quarantined int       `json:"quarantined"`
+	HaltReason  string    `json:"haltReason,omitempty"`
+	Paused      bool      `json:"paused,omitempty"`
+	// AwaitingApproval holds the ID of the plan that expired before it was approved.
+	AwaitingApproval string            `json:"awaitingApproval,omitempty"`
+	Results          []InstanceSummary `json:"results"`
+}
+
+// summary returns the serializable form of the report.
+func (r *runReport) summary() RunSummary {
+	s := RunSummary{
+		Cluster:          r.cluster,
+		Start:            r.start,
+		End:              r.end,
+		Candidates:       r.candidates,
+		Updated:          r.count(OutcomeUpdated),
+		Skipped:          r.count(OutcomeSkipped),
+		Failed:           r.count(OutcomeFailed),
+		HaltReason:       r.haltReason,
+		Paused:           r.paused,
+		AwaitingApproval: r.awaitingApproval,
+		Results:          make([]InstanceSummary, 0, len(r.results)),
+	}
+	for _, res := range r.results {
+		if res.outcome == OutcomeSkipped && res.reason == skipQuarantined {
+			s.Quarantined++
+		}
+		s.Results = append(s.Results, InstanceSummary{
+			InstanceID:           res.instance.instanceID,
+			ContainerInstanceARN: res.instance.containerInstanceID,
+			Outcome:              res.outcome,
+			Phase:                res.phase,
+			Reason:               res.reason,
+		})
+	}
+	return s
+}
@@ -0,0 +1,367 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// run performs a single update cycle: it discovers the Bottlerocket instances in the cluster,
+// checks them for available updates and updates the eligible ones one at a time. Cancelling
+// ctx stops the cycle before the next instance is started and interrupts draining.
+func (u *clusterUpdater) run(ctx aws.Context) (*runReport, error) {
+	report := newRunReport(u.cluster)
+	ctx, span := u.tracer.start(ctx, "run", "ecs.cluster", u.cluster, "cloud.region", u.region)
+	err := u.cycle(ctx, report)
+	span.set("updater.candidates", report.candidates,
+		"updater.updated", report.count(OutcomeUpdated),
+		"updater.skipped", report.count(OutcomeSkipped),
+		"updater.failed", report.count(OutcomeFailed))
+	if report.halted() {
+		span.set("updater.halt_reason", report.haltReason)
+	}
+	span.fail(err)
+	u.backoff.update(report)
+	report.log()
+	u.emf.emit(report)
+	u.notifier.summary(report, err)
+	u.status.finish(report)
+	if u.hooks.RunFinished != nil {
+		u.hooks.RunFinished(report.summary(), err)
+	}
+	span.finish()
+	return report, err
+}
+
+// cycle runs the update cycle, recording the results in report.
+func (u *clusterUpdater) cycle(ctx aws.Context, report *runReport) error {
+	remote := u.remote.current()
+	if remote.paused {
+		log.Printf("Paused by remote configuration, skipping this run")
+		report.pause("paused by remote configuration")
+		return nil
+	}
+	if on, why := u.killSwitch.engaged(); on {
+		log.Printf("Paused by %s, skipping this run", why)
+		report.pause(why)
+		return nil
+	}
+	if !u.maintenance.open(time.Now()) {
+		log.Printf("Outside of the maintenance window, skipping this run")
+		report.halt("outside maintenance window")
+		return nil
+	}
+
+	instances, candidates, err := u.candidates(ctx)
+	if err != nil {
+		return err
+	}
+	u.reportCandidates(ctx, instances, candidates)
+	if len(u.only) != 0 {
+		var missing []string
+		candidates, missing = selectInstances(candidates, u.only)
+		if len(missing) != 0 {
+			return fmt.Errorf("instances have no update available or are not active Bottlerocket container instances in cluster %q: %q", u.cluster, missing)
+		}
+	}
+	if u.planned != nil {
+		candidates, err = u.followPlan(instances, candidates)
+		if err != nil {
+			report.halt("cluster drifted from the plan")
+			return err
+		}
+	}
+	if len(candidates) == 0 {
+		log.Printf("No instances to update")
+		return nil
+	}
+	log.Printf("Instances ready for update: %#q", candidates)
+
+	report.candidates = len(candidates)
+	u.status.setQueue(u.cluster, candidates)
+	// A plan being applied was approved when it was reviewed.
+	if u.planned == nil && !u.awaitApproval(ctx, report, candidates) {
+		return nil
+	}
+
+	// drained tracks every container instance the updater started draining so they can all be
+	// returned to ACTIVE if the rollout is stopped.
+	drained := make([]string, 0)
+	// haltErr is returned once the drained instances are back in service when the rollout is
+	// halted by an error.
+	var haltErr error
+	// exhausted is set when candidates are left unprocessed because the failure budget ran out.
+	exhausted := false
+	// instSpan is the trace span of the instance being processed. It is ended when the result of
+	// the instance is recorded, or when the rollout stops before a result is recorded.
+	var instSpan *span
+	defer func() { instSpan.finish() }()
+	budget := remote.budgetOr(u.budget)
+	for n, i := range candidates {
+		// Remote settings are reloaded between instances so that changes apply to long runs.
+		if n > 0 {
+			remote = u.remote.load()
+			budget = remote.budgetOr(u.budget)
+		}
+		// The budget is checked before each instance is started, so a run whose last instance
+		// uses it up has not stopped anything.
+		if budget.exhausted(report.count(OutcomeFailed), len(candidates)) {
+			exhausted = true
+			break
+		}
+		if ctx.Err() != nil {
+			log.Printf("Shutting down, not starting further instances")
+			report.halt("updater shutting down")
+			break
+		}
+		if u.status.isPaused() {
+			log.Printf("Paused by operator, not starting further instances")
+			report.halt("paused by operator")
+			break
+		}
+		if remote.paused {
+			log.Printf("Paused by remote configuration, not starting further instances")
+			report.pause("paused by remote configuration")
+			break
+		}
+		if !u.maintenance.open(time.Now()) {
+			log.Printf("Maintenance window closed, not starting further instances")
+			report.halt("maintenance window closed")
+			break
+		}
+		u.status.start(u.cluster, i)
+		var instCtx aws.Context
+		instCtx, instSpan = u.tracer.start(ctx, "instance",
+			"ec2.instance_id", i.instanceID,
+			"ecs.container_instance", i.containerInstanceID,
+			"bottlerocket.version", i.bottlerocketVersion,
+			"bottlerocket.target_version", i.targetVersion)
+		if i.quarantinedSince != "" {
+			log.Printf("QUARANTINE: skipping instance %#q, quarantined since %s; delete the %s attribute from the container instance to retry it",
+				i, i.quarantinedSince, attributeQuarantined)
+			u.record(instCtx, report, i, OutcomeSkipped, PhaseEligibility, skipQuarantined)
+			continue
+		}
+		if remaining := u.backoff.remaining(i.instanceID, time.Now()); remaining > 0 {
+			log.Printf("Skipping instance %#q for another %s after previous failures", i, remaining.Round(time.Second))
+			u.record(instCtx, report, i, OutcomeSkipped, PhaseEligibility, skipBackoff)
+			continue
+		}
+		if remote.blocks(i.targetVersion) {
+			log.Printf("Skipping instance %#q because updates to version %s are blocked by remote configuration", i, i.targetVersion)
+			u.record(instCtx, report, i, OutcomeSkipped, PhaseEligibility, skipBlockedVersion)
+			continue
+		}
+		u.status.setActivity(u.cluster, activityEligibility)
+		eligible, err := u.eligible(instCtx, i.containerInstanceID)
+		if err != nil {
+			log.Printf("Skipping instance %#q, failed to determine eligibility for update: %v", i, err)
+			u.record(instCtx, report, i, OutcomeSkipped, PhaseEligibility, skipEligibilityError)
+			continue
+		}
+		if !eligible {
+			log.Printf("Instance %#q is not eligible for updates because it contains non-service task", i)
+			u.record(instCtx, report, i, OutcomeSkipped, PhaseEligibility, skipNonServiceTask)
+			continue
+		}
+		log.Printf("Instance %q is eligible for update", i)
+
+		firing, err := u.firingAlarms(instCtx)
+		if err != nil {
+			report.halt(fmt.Sprintf("unable to check CloudWatch alarms: %v", err))
+			haltErr = fmt.Errorf("rollout halted before draining instance %#q: %w", i, err)
+			break
+		}
+		if len(firing) != 0 {
+			report.halt(fmt.Sprintf("CloudWatch alarms in ALARM state: %s", strings.Join(firing, ", ")))
+			haltErr = fmt.Errorf("rollout halted before draining instance %#q: alarms in ALARM state: %q", i, firing)
+			break
+		}
+
+		if u.hooks.BeforeDrain != nil {
+			if err := u.hooks.BeforeDrain(ctx, i.hookInstance(u.cluster)); err != nil {
+				log.Printf("Skipping instance %#q, refused by the before-drain hook: %v", i, err)
+				u.record(instCtx, report, i, OutcomeSkipped, PhaseEligibility, skipHook)
+				continue
+			}
+		}
+
+		if on, why := u.killSwitch.engaged(); on {
+			log.Printf("Paused by %s, not draining instance %#q", why, i)
+			u.record(instCtx, report, i, OutcomeSkipped, PhaseEligibility, skipPaused)
+			report.pause(why)
+			break
+		}
+
+		u.status.setActivity(u.cluster, activityDraining)
+		updateStart := time.Now()
+		drained = append(drained, i.containerInstanceID)
+		// The drain is interrupted, and the instance returned to ACTIVE, if the kill switch is
+		// engaged while waiting for its tasks to stop.
+		drainCtx, stopWatching := u.killSwitch.watch(instCtx)
+		err = u.drainInstance(drainCtx, i)
+		if why := stopWatching(); why != "" {
+			// The switch may have been engaged just as the drain completed, in which case the
+			// instance is still DRAINING; it is returned to ACTIVE with the others below.
+			log.Printf("Paused by %s while draining instance %#q", why, i)
+			u.record(instCtx, report, i, OutcomeSkipped, PhaseDrain, skipPaused)
+			report.pause(why)
+			break
+		}
+		// A drain interrupted because the updater is shutting down is not the instance's failure:
+		// it must not count against the failure budget, backoff or quarantine. drainInstance has
+		// already returned the instance to ACTIVE. Updates and verifications interrupted by a
+		// shutdown are recorded the same way below.
+		if err != nil && ctx.Err() != nil {
+			log.Printf("Shutting down while draining instance %#q", i)
+			u.record(instCtx, report, i, OutcomeSkipped, PhaseDrain, skipShutdown)
+			report.halt("updater shutting down")
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to drain instance %#q: %v", i, err)
+			u.record(instCtx, report, i, OutcomeFailed, PhaseDrain, err.Error())
+			continue
+		}
+		log.Printf("Instance %#q successfully drained!", i)
+		drainTime := time.Since(updateStart)
+
+		u.status.setActivity(u.cluster, activityUpdating)
+		updateErr := u.updateInstance(instCtx, i)
+		activateErr := u.activateInstance(instCtx, i)
+		if updateErr != nil && activateErr == nil && ctx.Err() != nil {
+			log.Printf("Shutting down while updating instance %#q", i)
+			u.record(instCtx, report, i, OutcomeSkipped, PhaseUpdate, skipShutdown).drainTime = drainTime
+			report.halt("updater shutting down")
+			break
+		} else if updateErr != nil && activateErr != nil {
+			log.Printf("Failed to update instance %#q: %v", i, updateErr)
+			u.record(instCtx, report, i, OutcomeFailed, PhaseActivate, activateErr.Error()).drainTime = drainTime
+			err := fmt.Errorf("instance %#q failed to re-activate after failing to update: %w", i, activateErr)
+			u.notifier.fatal(report, &i, err)
+			return err
+		} else if updateErr != nil {
+			log.Printf("Failed to update instance %#q: %v", i, updateErr)
+			u.record(instCtx, report, i, OutcomeFailed, PhaseUpdate, updateErr.Error()).drainTime = drainTime
+			continue
+		} else if activateErr != nil {
+			u.record(instCtx, report, i, OutcomeFailed, PhaseActivate, activateErr.Error()).drainTime = drainTime
+			err := fmt.Errorf("instance %#q failed to re-activate after update: %w", i, activateErr)
+			u.notifier.fatal(report, &i, err)
+			return err
+		}
+
+		// Reboots are not immediate, and initiating an SSM command races with reboot. Add some
+		// sleep time to allow the reboot to progress before we verify update.
+		u.status.setActivity(u.cluster, activityVerifying)
+		time.Sleep(20 * time.Second)
+		ok, err := u.verifyUpdate(instCtx, i)
+		if err != nil && ctx.Err() != nil {
+			log.Printf("Shutting down while verifying instance %#q", i)
+			u.record(instCtx, report, i, OutcomeSkipped, PhaseVerify, skipShutdown).drainTime = drainTime
+			report.halt("updater shutting down")
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to verify update for instance %#q: %v", i, err)
+		}
+		if !ok {
+			log.Printf("Update failed for instance %#q", i)
+			reason := "version did not change"
+			if err != nil {
+				reason = err.Error()
+			}
+			u.record(instCtx, report, i, OutcomeFailed, PhaseVerify, reason).drainTime = drainTime
+		} else {
+			log.Printf("Instance %#q updated successfully!", i)
+			res := u.record(instCtx, report, i, OutcomeUpdated, "", "")
+			res.drainTime = drainTime
+			res.updateTime = time.Since(updateStart)
+			u.metrics.instanceUpdated(res.updateTime)
+		}
+	}
+
+	failures := report.count(OutcomeFailed)
+	if exhausted {
+		report.halt(fmt.Sprintf("failure budget of %s exhausted after %d failed instances", budget, failures))
+	}
+	if report.halted() {
+		if err := u.ensureActive(ctx, drained); err != nil {
+			err = fmt.Errorf("rollout stopped with instances left DRAINING: %w", err)
+			u.notifier.fatal(report, nil, err)
+			return err
+		}
+	}
+	if haltErr != nil {
+		return haltErr
+	}
+	if exhausted {
+		return fmt.Errorf("rollout stopped after exhausting failure budget of %s with %d failed instances", budget, failures)
+	}
+	return nil
+}
+
+// candidates discovers the Bottlerocket instances in the cluster selected for updates, with their
+// active version, and returns them with those that have an update available.
+func (u *clusterUpdater) candidates(ctx aws.Context) ([]instance, []instance, error) {
+	u.status.setActivity(u.cluster, activityDiscovering)
+	listedInstances, err := u.listContainerInstances(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to get container instances in cluster %q: %w", u.cluster, err)
+	}
+	if len(listedInstances) == 0 {
+		log.Print("Zero instances in the cluster")
+		return nil, nil, nil
+	}
+
+	bottlerocketInstances, err := u.filterBottlerocketInstances(ctx, listedInstances)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to filter Bottlerocket instances: %w", err)
+	}
+
+	if len(bottlerocketInstances) == 0 {
+		log.Printf("No Bottlerocket instances detected")
+		return nil, nil, nil
+	}
+	u.status.setActivity(u.cluster, activityChecking)
+	candidates, err := u.filterAvailableUpdates(ctx, bottlerocketInstances)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to check updates: %w", err)
+	}
+	return bottlerocketInstances, candidates, nil
+}
+
+// reportCandidates publishes the candidates found by a run and updates the version and candidate
+// metrics. Plans and operator commands look for candidates too, but only runs report them.
+func (u *clusterUpdater) reportCandidates(ctx aws.Context, instances []instance, candidates []instance) {
+	versions := make(map[string]int)
+	for _, inst := range instances {
+		if inst.bottlerocketVersion != "" {
+			versions[inst.bottlerocketVersion]++
+		}
+	}
+	u.metrics.fleetVersions(u.cluster, versions)
+	u.metrics.updateCandidates(len(candidates))
+	for _, inst := range candidates {
+		u.events.publish(ctx, EventCandidateFound, inst, "")
+	}
+}
+
+// record adds the result of processing an instance to the report and the metrics, writes the
+// update history to the container instance, and ends the instance's trace span.
+func (u *clusterUpdater) record(ctx aws.Context, report *runReport, inst instance, o Outcome, p Phase, reason string) *instanceResult {
+	u.metrics.result(o, p, reason)
+	span := u.tracer.current(ctx)
+	span.set("updater.outcome", string(o), "updater.phase", string(p), "updater.reason", reason)
+	if o == OutcomeFailed {
+		span.fail(errors.New(reason))
+	}
+	span.finish()
+	res := report.record(inst, o, p, reason)
+	u.writeHistory(ctx, *res, report.start)
+	return res
+}
@@ -0,0 +1,303 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// cycleTestUpdater returns an updater for a cluster of n instances, inst-id-1 to inst-id-n, that
+// all have an update available. Every change of container instance state is appended to states
+// as "<container instance>=<status>". Draining fails unless WaitUntilTasksStoppedWithContextFn
+// is replaced, so that tests do not reach the update phase.
+func cycleTestUpdater(t *testing.T, n int, states *[]string) *clusterUpdater {
+	instances := make([]planTestInstance, 0, n)
+	for i := 1; i <= n; i++ {
+		instances = append(instances, planTestInstance{id: fmt.Sprintf("inst-id-%d", i), version: "1.0.5", target: "1.0.6"})
+	}
+	u := planTestUpdater(t, instances)
+	mockECS := u.ecs.(MockECS)
+	mockECS.UpdateContainerInstancesStateFn = func(input *ecs.UpdateContainerInstancesStateInput) (*ecs.UpdateContainerInstancesStateOutput, error) {
+		for _, arn := range aws.StringValueSlice(input.ContainerInstances) {
+			*states = append(*states, arn+"="+aws.StringValue(input.Status))
+		}
+		return &ecs.UpdateContainerInstancesStateOutput{}, nil
+	}
+	mockECS.WaitUntilTasksStoppedWithContextFn = func(ctx aws.Context, input *ecs.DescribeTasksInput, opts ...request.WaiterOption) error {
+		return fmt.Errorf("tasks did not stop")
+	}
+	mockECS.PutAttributesFn = func(input *ecs.PutAttributesInput) (*ecs.PutAttributesOutput, error) {
+		return &ecs.PutAttributesOutput{}, nil
+	}
+	mockECS.DeleteAttributesFn = func(input *ecs.DeleteAttributesInput) (*ecs.DeleteAttributesOutput, error) {
+		return &ecs.DeleteAttributesOutput{}, nil
+	}
+	u.ecs = mockECS
+	return u
+}
+
+func TestCycleShutdownDuringDrain(t *testing.T) {
+	states := []string{}
+	u := cycleTestUpdater(t, 2, &states)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mockECS := u.ecs.(MockECS)
+	mockECS.WaitUntilTasksStoppedWithContextFn = func(ctx aws.Context, input *ecs.DescribeTasksInput, opts ...request.WaiterOption) error {
+		// The updater is stopped while waiting for the tasks to stop.
+		cancel()
+		return ctx.Err()
+	}
+	mockECS.PutAttributesFn = func(input *ecs.PutAttributesInput) (*ecs.PutAttributesOutput, error) {
+		if aws.StringValue(input.Attributes[0].Name) != attributeDrained {
+			t.Errorf("unexpected update history written for %q", aws.StringValue(input.Attributes[0].TargetId))
+		}
+		return &ecs.PutAttributesOutput{}, nil
+	}
+	u.ecs = mockECS
+	budget, err := parseFailureBudget("1")
+	require.NoError(t, err)
+	u.budget = budget
+	u.quarantineAfter = 1
+	u.backoff = newBackoffTracker(time.Hour, time.Hour)
+
+	report := newRunReport("test-cluster")
+	require.NoError(t, u.cycle(ctx, report), "a shutdown does not exhaust the failure budget")
+	require.Len(t, report.results, 1, "no further instance is started")
+	assert.Equal(t, OutcomeSkipped, report.results[0].outcome)
+	assert.Equal(t, PhaseDrain, report.results[0].phase)
+	assert.Equal(t, skipShutdown, report.results[0].reason)
+	assert.Equal(t, "updater shutting down", report.haltReason)
+	assert.Equal(t, []string{
+		"cont-inst-id-1=DRAINING", "cont-inst-id-1=ACTIVE",
+		// Every instance drained during the run is made active again when the rollout stops.
+		"cont-inst-id-1=ACTIVE",
+	}, states, "the instance is returned to service")
+
+	u.backoff.update(report)
+	assert.Zero(t, u.backoff.remaining("inst-id-1", time.Now()), "a shutdown does not back off the instance")
+}
+
+func TestCycleBudgetExhausted(t *testing.T) {
+	states := []string{}
+	u := cycleTestUpdater(t, 3, &states)
+	budget, err := parseFailureBudget("2")
+	require.NoError(t, err)
+	u.budget = budget
+
+	report := newRunReport("test-cluster")
+	err = u.cycle(context.Background(), report)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exhausting failure budget of 2 with 2 failed instances")
+	assert.Equal(t, "failure budget of 2 exhausted after 2 failed instances", report.haltReason)
+	require.Len(t, report.results, 2, "no instance is started once the budget is exhausted")
+	assert.Equal(t, 2, report.count(OutcomeFailed))
+	assert.Equal(t, []string{
+		"cont-inst-id-1=DRAINING", "cont-inst-id-1=ACTIVE",
+		"cont-inst-id-2=DRAINING", "cont-inst-id-2=ACTIVE",
+		// Every instance drained during the run is made active again.
+		"cont-inst-id-1=ACTIVE", "cont-inst-id-2=ACTIVE",
+	}, states)
+}
+
+func TestCycleBudgetExhaustedByLastInstance(t *testing.T) {
+	states := []string{}
+	u := cycleTestUpdater(t, 2, &states)
+	budget, err := parseFailureBudget("2")
+	require.NoError(t, err)
+	u.budget = budget
+
+	report := newRunReport("test-cluster")
+	require.NoError(t, u.cycle(context.Background(), report), "no instance was left unprocessed")
+	assert.False(t, report.halted())
+	assert.Equal(t, 2, report.count(OutcomeFailed))
+	assert.Equal(t, []string{
+		"cont-inst-id-1=DRAINING", "cont-inst-id-1=ACTIVE",
+		"cont-inst-id-2=DRAINING", "cont-inst-id-2=ACTIVE",
+	}, states)
+}
+
+func TestCycleEligibilityError(t *testing.T) {
+	states := []string{}
+	u := cycleTestUpdater(t, 2, &states)
+	mockECS := u.ecs.(MockECS)
+	mockECS.ListTasksFn = func(input *ecs.ListTasksInput) (*ecs.ListTasksOutput, error) {
+		return nil, errors.New("ThrottlingException: Rate exceeded")
+	}
+	mockECS.PutAttributesFn = func(input *ecs.PutAttributesInput) (*ecs.PutAttributesOutput, error) {
+		t.Errorf("unexpected update history written for %q", aws.StringValue(input.Attributes[0].TargetId))
+		return &ecs.PutAttributesOutput{}, nil
+	}
+	u.ecs = mockECS
+	budget, err := parseFailureBudget("1")
+	require.NoError(t, err)
+	u.budget = budget
+	u.quarantineAfter = 1
+
+	report := newRunReport("test-cluster")
+	require.NoError(t, u.cycle(context.Background(), report), "an eligibility error does not use up the failure budget")
+	require.Len(t, report.results, 2)
+	for _, res := range report.results {
+		assert.Equal(t, OutcomeSkipped, res.outcome)
+		assert.Equal(t, skipEligibilityError, res.reason)
+	}
+	assert.Empty(t, states, "no instance is drained")
+}
+
+func TestCycleAlarmHalt(t *testing.T) {
+	states := []string{}
+	u := cycleTestUpdater(t, 2, &states)
+	u.alarms = []string{"high-error-rate", "high-latency"}
+	var exporter *tracetest.InMemoryExporter
+	u.tracer, exporter = testTracer()
+	u.cloudwatch = MockCloudWatch{
+		DescribeAlarmsFn: func(input *cloudwatch.DescribeAlarmsInput) (*cloudwatch.DescribeAlarmsOutput, error) {
+			assert.Equal(t, u.alarms, aws.StringValueSlice(input.AlarmNames))
+			return &cloudwatch.DescribeAlarmsOutput{
+				MetricAlarms: []*cloudwatch.MetricAlarm{{AlarmName: aws.String("high-latency")}},
+			}, nil
+		},
+	}
+
+	report := newRunReport("test-cluster")
+	err := u.cycle(context.Background(), report)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "alarms in ALARM state")
+	assert.Equal(t, "CloudWatch alarms in ALARM state: high-latency", report.haltReason)
+	assert.False(t, report.paused)
+	assert.Empty(t, report.results)
+	assert.Empty(t, states, "no instance is drained while an alarm fires")
+	spans := spansByName(exporter.GetSpans())
+	require.Contains(t, spans, "instance", "the span of the instance is ended when the rollout halts")
+	assert.Contains(t, spans["instance"].Attributes, attribute.String("ec2.instance_id", "inst-id-1"))
+}
+
+// closingWindow is a maintenance window that is open for its first open checks, then closed.
+type closingWindow struct {
+	open int
+}
+
+func (w *closingWindow) contains(time.Time) bool {
+	w.open--
+	return w.open >= 0
+}
+
+func TestCycleMaintenanceWindowCloses(t *testing.T) {
+	states := []string{}
+	u := cycleTestUpdater(t, 3, &states)
+	// The window is checked when the cycle starts and before each instance.
+	u.maintenance = &schedule{location: time.UTC, windows: []window{&closingWindow{open: 2}}}
+
+	report := newRunReport("test-cluster")
+	require.NoError(t, u.cycle(context.Background(), report))
+	assert.Equal(t, "maintenance window closed", report.haltReason)
+	assert.False(t, report.paused)
+	require.Len(t, report.results, 1, "only the instance started while the window was open is processed")
+	assert.Equal(t, "inst-id-1", report.results[0].instance.instanceID)
+	assert.Equal(t, []string{"cont-inst-id-1=DRAINING", "cont-inst-id-1=ACTIVE", "cont-inst-id-1=ACTIVE"}, states)
+}
+
+func TestCycleKillSwitchDuringDrain(t *testing.T) {
+	states := []string{}
+	u := cycleTestUpdater(t, 2, &states)
+	var draining int32
+	mockECS := u.ecs.(MockECS)
+	setState := mockECS.UpdateContainerInstancesStateFn
+	mockECS.UpdateContainerInstancesStateFn = func(input *ecs.UpdateContainerInstancesStateInput) (*ecs.UpdateContainerInstancesStateOutput, error) {
+		if aws.StringValue(input.Status) == ecs.ContainerInstanceStatusDraining {
+			atomic.StoreInt32(&draining, 1)
+		}
+		return setState(input)
+	}
+	mockECS.WaitUntilTasksStoppedWithContextFn = func(ctx aws.Context, input *ecs.DescribeTasksInput, opts ...request.WaiterOption) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			t.Error("the drain was not interrupted by the kill switch")
+			return nil
+		}
+	}
+	u.ecs = mockECS
+	// The operator engages the kill switch once the first instance starts draining.
+	u.killSwitch = newKillSwitch([]killSwitchSource{{kind: "ssm", name: "/updater/paused"}}, MockSSM{
+		GetParameterFn: func(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+			value := "false"
+			if atomic.LoadInt32(&draining) == 1 {
+				value = "true"
+			}
+			return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(value)}}, nil
+		},
+	}, u.ecs, u.cluster)
+	u.killSwitch.interval = time.Millisecond
+
+	report := newRunReport("test-cluster")
+	require.NoError(t, u.cycle(context.Background(), report))
+	assert.True(t, report.paused)
+	assert.Equal(t, `kill switch ssm:/updater/paused set to "true"`, report.haltReason)
+	require.Len(t, report.results, 1, "no further instance is started")
+	assert.Equal(t, OutcomeSkipped, report.results[0].outcome)
+	assert.Equal(t, PhaseDrain, report.results[0].phase)
+	assert.Equal(t, skipPaused, report.results[0].reason)
+	assert.Equal(t, []string{"cont-inst-id-1=DRAINING", "cont-inst-id-1=ACTIVE", "cont-inst-id-1=ACTIVE"}, states, "the drain is rolled back")
+}
+
+func TestCycleKillSwitchAsDrainCompletes(t *testing.T) {
+	states := []string{}
+	u := cycleTestUpdater(t, 2, &states)
+	var draining int32
+	mockECS := u.ecs.(MockECS)
+	setState := mockECS.UpdateContainerInstancesStateFn
+	mockECS.UpdateContainerInstancesStateFn = func(input *ecs.UpdateContainerInstancesStateInput) (*ecs.UpdateContainerInstancesStateOutput, error) {
+		if aws.StringValue(input.Status) == ecs.ContainerInstanceStatusDraining {
+			atomic.StoreInt32(&draining, 1)
+		}
+		return setState(input)
+	}
+	// The drain completes even though the kill switch is engaged while it is running.
+	mockECS.WaitUntilTasksStoppedWithContextFn = func(ctx aws.Context, input *ecs.DescribeTasksInput, opts ...request.WaiterOption) error {
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}
+	cleared := make([]string, 0)
+	mockECS.DeleteAttributesFn = func(input *ecs.DeleteAttributesInput) (*ecs.DeleteAttributesOutput, error) {
+		for _, attr := range input.Attributes {
+			if aws.StringValue(attr.Name) == attributeDrained {
+				cleared = append(cleared, aws.StringValue(attr.TargetId))
+			}
+		}
+		return &ecs.DeleteAttributesOutput{}, nil
+	}
+	u.ecs = mockECS
+	u.killSwitch = newKillSwitch([]killSwitchSource{{kind: "ssm", name: "/updater/paused"}}, MockSSM{
+		GetParameterFn: func(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+			value := "false"
+			if atomic.LoadInt32(&draining) == 1 {
+				value = "true"
+			}
+			return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(value)}}, nil
+		},
+	}, u.ecs, u.cluster)
+	u.killSwitch.interval = time.Millisecond
+
+	report := newRunReport("test-cluster")
+	require.NoError(t, u.cycle(context.Background(), report))
+	assert.True(t, report.paused)
+	require.Len(t, report.results, 1, "no further instance is started")
+	assert.Equal(t, OutcomeSkipped, report.results[0].outcome)
+	assert.Equal(t, skipPaused, report.results[0].reason)
+	assert.Equal(t, []string{"cont-inst-id-1=DRAINING", "cont-inst-id-1=ACTIVE"}, states, "the drained instance is not left DRAINING")
+	assert.Equal(t, []string{"cont-inst-id-1"}, cleared, "the drain marker is removed")
+}
@@ -0,0 +1,163 @@
+package updater
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	// asgTag is the EC2 tag Auto Scaling sets to the name of the group an instance belongs to.
+	asgTag = "aws:autoscaling:groupName"
+	// instanceTypeAttribute is the ECS attribute holding the EC2 instance type.
+	instanceTypeAttribute = "ecs.instance-type"
+)
+
+// selectorKind is the property of an instance a selector matches.
+type selectorKind string
+
+const (
+	selectAttribute        selectorKind = "attribute"
+	selectTag              selectorKind = "tag"
+	selectCapacityProvider selectorKind = "capacity-provider"
+	selectASG              selectorKind = "asg"
+	selectInstanceType     selectorKind = "instance-type"
+)
+
+// selector matches instances on a single property against a shell pattern as understood by
+// path.Match, for example "tag:team=web-*" or "capacity-provider=bottlerocket".
+type selector struct {
+	kind selectorKind
+	// key is the attribute name or tag key for attribute and tag selectors.
+	key     string
+	pattern string
+}
+
+func parseSelector(value string) (selector, error) {
+	eq := strings.Index(value, "=")
+	if eq < 0 {
+		return selector{}, fmt.Errorf("invalid selector %q, expected <property>=<pattern>", value)
+	}
+	property, pattern := strings.TrimSpace(value[:eq]), strings.TrimSpace(value[eq+1:])
+	if _, err := path.Match(pattern, ""); err != nil {
+		return selector{}, fmt.Errorf("invalid pattern in selector %q: %w", value, err)
+	}
+	s := selector{pattern: pattern}
+	if colon := strings.Index(property, ":"); colon >= 0 {
+		s.kind, s.key = selectorKind(property[:colon]), property[colon+1:]
+		if (s.kind != selectAttribute && s.kind != selectTag) || s.key == "" {
+			return selector{}, fmt.Errorf("invalid selector %q, expected attribute:<name>=<pattern> or tag:<key>=<pattern>", value)
+		}
+		return s, nil
+	}
+	switch s.kind = selectorKind(property); s.kind {
+	case selectCapacityProvider, selectASG, selectInstanceType:
+		return s, nil
+	default:
+		return selector{}, fmt.Errorf("invalid selector %q, expected one of attribute:<name>, tag:<key>, %s, %s or %s",
+			value, selectCapacityProvider, selectASG, selectInstanceType)
+	}
+}
+
+// value returns the property of the instance the selector matches.
+func (s selector) value(inst instance) (string, bool) {
+	switch s.kind {
+	case selectAttribute:
+		v, ok := inst.attributes[s.key]
+		return v, ok
+	case selectTag:
+		v, ok := inst.tags[s.key]
+		return v, ok
+	case selectCapacityProvider:
+		return inst.capacityProvider, inst.capacityProvider != ""
+	case selectASG:
+		v, ok := inst.tags[asgTag]
+		return v, ok
+	case selectInstanceType:
+		v, ok := inst.attributes[instanceTypeAttribute]
+		return v, ok
+	}
+	return "", false
+}
+
+func (s selector) matches(inst instance) bool {
+	v, ok := s.value(inst)
+	if !ok {
+		return false
+	}
+	matched, _ := path.Match(s.pattern, v)
+	return matched
+}
+
+func (s selector) String() string {
+	if s.key != "" {
+		return fmt.Sprintf("%s:%s=%s", s.kind, s.key, s.pattern)
+	}
+	return fmt.Sprintf("%s=%s", s.kind, s.pattern)
+}
+
+// selection chooses the instances the updater manages. An instance is selected when it matches
+// at least one include selector, or there are none, and matches no exclude selector. The zero
+// value selects every instance.
+type selection struct {
+	include []selector
+	exclude []selector
+}
+
+// parseSelection parses comma-separated lists of include and exclude selectors.
+func parseSelection(include, exclude string) (selection, error) {
+	var s selection
+	for _, value := range SplitList(include) {
+		sel, err := parseSelector(value)
+		if err != nil {
+			return selection{}, err
+		}
+		s.include = append(s.include, sel)
+	}
+	for _, value := range SplitList(exclude) {
+		sel, err := parseSelector(value)
+		if err != nil {
+			return selection{}, err
+		}
+		s.exclude = append(s.exclude, sel)
+	}
+	return s, nil
+}
+
+// tagKeys returns the EC2 tag keys the selectors need, sorted.
+func (s selection) tagKeys() []string {
+	keys := make(map[string]bool)
+	for _, sel := range append(append([]selector{}, s.include...), s.exclude...) {
+		switch sel.kind {
+		case selectTag:
+			keys[sel.key] = true
+		case selectASG:
+			keys[asgTag] = true
+		}
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// selects reports whether the instance is selected, and otherwise why not.
+func (s selection) selects(inst instance) (bool, string) {
+	for _, sel := range s.exclude {
+		if sel.matches(inst) {
+			return false, fmt.Sprintf("matches exclude selector %s", sel)
+		}
+	}
+	if len(s.include) == 0 {
+		return true, ""
+	}
+	for _, sel := range s.include {
+		if sel.matches(inst) {
+			return true, ""
+		}
+	}
+	return false, "matches no include selector"
+}
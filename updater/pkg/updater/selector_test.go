@@ -0,0 +1,136 @@
+package updater
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSelector(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected selector
+	}{
+		{value: "attribute:bottlerocket.variant=aws-ecs-1", expected: selector{kind: selectAttribute, key: "bottlerocket.variant", pattern: "aws-ecs-1"}},
+		{value: "tag:team = web-*", expected: selector{kind: selectTag, key: "team", pattern: "web-*"}},
+		{value: "tag:owner=a=b", expected: selector{kind: selectTag, key: "owner", pattern: "a=b"}},
+		{value: "capacity-provider=bottlerocket", expected: selector{kind: selectCapacityProvider, pattern: "bottlerocket"}},
+		{value: "asg=prod-*", expected: selector{kind: selectASG, pattern: "prod-*"}},
+		{value: "instance-type=m5.*", expected: selector{kind: selectInstanceType, pattern: "m5.*"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			actual, err := parseSelector(tc.value)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+
+	for _, value := range []string{"asg", "region=us-west-2", "tag:=x", "label:team=web", "asg=[", "instance-type:x=y"} {
+		t.Run("invalid "+value, func(t *testing.T) {
+			_, err := parseSelector(value)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSelection(t *testing.T) {
+	gpu := instance{
+		instanceID:       "gpu",
+		attributes:       map[string]string{"bottlerocket.variant": "aws-ecs-1-nvidia", "ecs.instance-type": "g4dn.xlarge"},
+		tags:             map[string]string{"aws:autoscaling:groupName": "prod-gpu", "team": "ml"},
+		capacityProvider: "gpu",
+	}
+	web := instance{
+		instanceID:       "web",
+		attributes:       map[string]string{"bottlerocket.variant": "aws-ecs-1", "ecs.instance-type": "m5.large"},
+		tags:             map[string]string{"aws:autoscaling:groupName": "prod-web", "team": "web"},
+		capacityProvider: "general",
+	}
+	bare := instance{
+		instanceID: "bare",
+		attributes: map[string]string{"bottlerocket.variant": "aws-ecs-1", "ecs.instance-type": "m5.large"},
+	}
+	cases := []struct {
+		name     string
+		include  string
+		exclude  string
+		selected []string
+	}{
+		{name: "everything by default", selected: []string{"gpu", "web", "bare"}},
+		{name: "variant", include: "attribute:bottlerocket.variant=aws-ecs-1", selected: []string{"web", "bare"}},
+		{name: "any include matches", include: "capacity-provider=gpu, tag:team=web", selected: []string{"gpu", "web"}},
+		{name: "asg pattern", include: "asg=prod-*", selected: []string{"gpu", "web"}},
+		{name: "exclude", exclude: "instance-type=g4dn.*", selected: []string{"web", "bare"}},
+		{name: "exclude wins over include", include: "asg=prod-*", exclude: "tag:team=ml", selected: []string{"web"}},
+		{name: "missing tag does not match", exclude: "tag:team=*", selected: []string{"bare"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := parseSelection(tc.include, tc.exclude)
+			require.NoError(t, err)
+			selected := []string{}
+			for _, inst := range []instance{gpu, web, bare} {
+				if ok, _ := s.selects(inst); ok {
+					selected = append(selected, inst.instanceID)
+				}
+			}
+			assert.Equal(t, tc.selected, selected)
+		})
+	}
+}
+
+func TestSelectionTagKeys(t *testing.T) {
+	s, err := parseSelection("tag:team=web,asg=prod-*,capacity-provider=gpu", "tag:team=ml,tag:env=dev")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aws:autoscaling:groupName", "env", "team"}, s.tagKeys())
+
+	s, err = parseSelection("attribute:bottlerocket.variant=aws-ecs-1", "")
+	require.NoError(t, err)
+	assert.Empty(t, s.tagKeys())
+}
+
+func TestFilterBottlerocketInstancesSelection(t *testing.T) {
+	mockECS := MockECS{
+		DescribeContainerInstancesFn: func(_ *ecs.DescribeContainerInstancesInput) (*ecs.DescribeContainerInstancesOutput, error) {
+			return &ecs.DescribeContainerInstancesOutput{ContainerInstances: []*ecs.ContainerInstance{{
+				Attributes:           []*ecs.Attribute{{Name: aws.String("bottlerocket.variant"), Value: aws.String("aws-ecs-1")}},
+				ContainerInstanceArn: aws.String("cont-inst-1"),
+				Ec2InstanceId:        aws.String("ec2-id-1"),
+				CapacityProviderName: aws.String("bottlerocket"),
+			}, {
+				Attributes:           []*ecs.Attribute{{Name: aws.String("bottlerocket.variant"), Value: aws.String("aws-ecs-1")}},
+				ContainerInstanceArn: aws.String("cont-inst-2"),
+				Ec2InstanceId:        aws.String("ec2-id-2"),
+				CapacityProviderName: aws.String("bottlerocket"),
+			}, {
+				Attributes:           []*ecs.Attribute{{Name: aws.String("bottlerocket.variant"), Value: aws.String("aws-ecs-1")}},
+				ContainerInstanceArn: aws.String("cont-inst-3"),
+				Ec2InstanceId:        aws.String("ec2-id-3"),
+				CapacityProviderName: aws.String("pinned"),
+			}}}, nil
+		},
+	}
+	mockEC2 := MockEC2{
+		DescribeTagsFn: func(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
+			assert.Equal(t, []string{asgTag}, aws.StringValueSlice(input.Filters[2].Values))
+			return &ec2.DescribeTagsOutput{Tags: []*ec2.TagDescription{
+				{ResourceId: aws.String("ec2-id-1"), Key: aws.String(asgTag), Value: aws.String("canary")},
+				{ResourceId: aws.String("ec2-id-2"), Key: aws.String(asgTag), Value: aws.String("prod")},
+			}}, nil
+		},
+	}
+	selection, err := parseSelection("capacity-provider=bottlerocket", "asg=canary")
+	require.NoError(t, err)
+	u := clusterUpdater{ecs: mockECS, ec2: mockEC2, selection: selection}
+
+	actual, err := u.filterBottlerocketInstances(aws.BackgroundContext(), []*string{})
+	require.NoError(t, err)
+	require.Len(t, actual, 1)
+	assert.Equal(t, "ec2-id-2", actual[0].instanceID)
+	assert.Equal(t, map[string]string{asgTag: "prod"}, actual[0].tags)
+}
@@ -0,0 +1,225 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// shutdownTimeout is how long in-flight HTTP requests are given to complete when the server
+	// stops.
+	shutdownTimeout = 5 * time.Second
+	// controlTimestampHeader holds the time a control request was signed, in seconds since the
+	// Unix epoch.
+	controlTimestampHeader = "X-Approval-Timestamp"
+	// controlSignatureWindow is how far the signing time of a control request may be from the
+	// updater's clock.
+	controlSignatureWindow = 5 * time.Minute
+)
+
+var (
+	errControlSignature = errors.New("invalid control signature")
+	errControlExpired   = fmt.Errorf("control request must be signed within %s of the updater's clock", controlSignatureWindow)
+	errControlReplayed  = errors.New("control signature was already used")
+	errControlRemote    = errors.New("control requests are only accepted from localhost without approval-secret")
+)
+
+// newStatusHandler returns the HTTP handler exposing the updater status and controls:
+//
+//	GET  /status   current activity, instance being processed, queue and last cycle results
+//	POST /pause    stop starting new instances and cycles
+//	POST /resume   resume a paused updater
+//	POST /trigger  start the next cycle now
+//	GET  /healthz  liveness probe
+//	GET  /readyz   readiness probe
+//	GET  /metrics  Prometheus metrics
+//	GET  /approvals             plans awaiting approval
+//	POST /approve?plan=<id>     approve a plan, signed with the X-Approval-Signature header
+//
+// The control endpoints, /pause, /resume and /trigger, must be signed like approvals, with the
+// HMAC of the method, path and signing time of the request keyed with secret. Without a secret
+// they only accept requests from the loopback interface.
+func newStatusHandler(s *status, m *metrics, g *approvalGate, secret string) http.Handler {
+	guard := newControlGuard(secret)
+	control := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if err := guard.authorize(r); err != nil {
+				log.Printf("Rejected %s request from %s: %v", r.URL.Path, r.RemoteAddr, err)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			h(w, r)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.snapshot())
+	})
+	mux.HandleFunc("/pause", control(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Pause requested from %s", r.RemoteAddr)
+		s.setPaused(true)
+		writeJSON(w, http.StatusOK, s.snapshot())
+	}))
+	mux.HandleFunc("/resume", control(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Resume requested from %s", r.RemoteAddr)
+		s.setPaused(false)
+		writeJSON(w, http.StatusOK, s.snapshot())
+	}))
+	mux.HandleFunc("/trigger", control(func(w http.ResponseWriter, r *http.Request) {
+		if s.isPaused() {
+			http.Error(w, "updater is paused", http.StatusConflict)
+			return
+		}
+		log.Printf("Immediate cycle requested from %s", r.RemoteAddr)
+		s.requestCycle()
+		writeJSON(w, http.StatusAccepted, s.snapshot())
+	}))
+	mux.HandleFunc("/approvals", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, g.pendingPlans())
+	})
+	mux.HandleFunc("/approve", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := r.URL.Query().Get("plan")
+		err := g.approve(id, r.Header.Get(approvalSignatureHeader))
+		switch {
+		case errors.Is(err, errApprovalDisabled), errors.Is(err, errApprovalUnknown):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, errApprovalSignature):
+			log.Printf("Rejected approval of plan %q from %s: %v", id, r.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		log.Printf("Plan %s approved from %s", id, r.RemoteAddr)
+		writeJSON(w, http.StatusOK, g.pendingPlans())
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.isReady() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	})
+	return mux
+}
+
+// controlGuard authorizes control requests. Each signature is accepted once, so that a captured
+// request cannot be replayed.
+type controlGuard struct {
+	secret []byte
+	mu     sync.Mutex
+	// used holds the signatures accepted within the signature window, with their signing time.
+	used map[string]time.Time
+}
+
+func newControlGuard(secret string) *controlGuard {
+	return &controlGuard{secret: []byte(secret), used: make(map[string]time.Time)}
+}
+
+// controlMessage returns the message signed for a control request.
+func controlMessage(method, path, timestamp string) string {
+	return method + " " + path + " " + timestamp
+}
+
+// authorize checks that a control request is signed with the secret within the signature window
+// and was not seen before or, without a secret, that it comes from the loopback interface.
+func (c *controlGuard) authorize(r *http.Request) error {
+	if len(c.secret) != 0 {
+		return c.checkSignature(r, time.Now())
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return errControlRemote
+	}
+	return nil
+}
+
+func (c *controlGuard) checkSignature(r *http.Request, now time.Time) error {
+	timestamp := r.Header.Get(controlTimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errControlExpired
+	}
+	signed := time.Unix(seconds, 0)
+	if signed.Before(now.Add(-controlSignatureWindow)) || signed.After(now.Add(controlSignatureWindow)) {
+		return errControlExpired
+	}
+	signature := r.Header.Get(approvalSignatureHeader)
+	if !validSignature(c.secret, controlMessage(r.Method, r.URL.Path, timestamp), signature) {
+		return errControlSignature
+	}
+	// Hex digits may be given in either case.
+	signature = strings.ToLower(signature)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Signatures signed before the window are rejected by their timestamp already.
+	for used, at := range c.used {
+		if at.Before(now.Add(-controlSignatureWindow)) {
+			delete(c.used, used)
+		}
+	}
+	if _, ok := c.used[signature]; ok {
+		return errControlReplayed
+	}
+	c.used[signature] = signed
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write HTTP response: %v", err)
+	}
+}
+
+// serveHTTP runs an HTTP server with the given handler on addr until ctx is cancelled.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	log.Printf("Serving HTTP on %s", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("HTTP server failed: %v", err)
+	}
+}
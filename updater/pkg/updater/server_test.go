@@ -0,0 +1,200 @@
+package updater
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusHandler(t *testing.T) {
+	s := newStatus()
+	handler := newStatusHandler(s, newMetrics(), nil, "")
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "127.0.0.1:40000"
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("probes", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/healthz").Code)
+		assert.Equal(t, http.StatusServiceUnavailable, do(http.MethodGet, "/readyz").Code)
+		s.setReady()
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/readyz").Code)
+	})
+
+	t.Run("status", func(t *testing.T) {
+		first := instance{instanceID: "inst-1", containerInstanceID: "cont-inst-1", bottlerocketVersion: "v1.0.5"}
+		second := instance{instanceID: "inst-2", containerInstanceID: "cont-inst-2", bottlerocketVersion: "v1.0.5"}
+		s.setQueue("test-cluster", []instance{first, second})
+		s.start("test-cluster", first)
+		s.setActivity("test-cluster", activityDraining)
+
+		rec := do(http.MethodGet, "/status")
+		require.Equal(t, http.StatusOK, rec.Code)
+		snap := statusSnapshot{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snap))
+		assert.Equal(t, activityDraining, snap.Activity)
+		require.NotNil(t, snap.Current)
+		assert.Equal(t, "inst-1", snap.Current.InstanceID)
+		assert.Equal(t, []instanceStatus{newInstanceStatus(second)}, snap.Queue)
+		require.Len(t, snap.InProgress, 1)
+		assert.Equal(t, "test-cluster", snap.InProgress[0].Cluster)
+		assert.Nil(t, snap.LastReport)
+
+		report := newRunReport("test-cluster")
+		report.candidates = 2
+		report.record(first, OutcomeUpdated, "", "")
+		report.record(second, OutcomeFailed, PhaseDrain, "drain timed out")
+		s.finish(report)
+
+		rec = do(http.MethodGet, "/status")
+		snap = statusSnapshot{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snap))
+		assert.Equal(t, activityIdle, snap.Activity)
+		assert.Nil(t, snap.Current)
+		assert.Empty(t, snap.Queue)
+		assert.Empty(t, snap.InProgress)
+		require.NotNil(t, snap.LastReport)
+		assert.Equal(t, 1, snap.LastReport.Updated)
+		assert.Equal(t, 1, snap.LastReport.Failed)
+		assert.Equal(t, PhaseDrain, snap.LastReport.Results[1].Phase)
+	})
+
+	t.Run("pause resume trigger", func(t *testing.T) {
+		assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodGet, "/pause").Code)
+		assert.Equal(t, http.StatusOK, do(http.MethodPost, "/pause").Code)
+		assert.True(t, s.isPaused())
+		assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/trigger").Code)
+		assert.Empty(t, s.triggered())
+
+		assert.Equal(t, http.StatusOK, do(http.MethodPost, "/resume").Code)
+		assert.False(t, s.isPaused())
+		assert.Equal(t, http.StatusAccepted, do(http.MethodPost, "/trigger").Code)
+		assert.Equal(t, http.StatusAccepted, do(http.MethodPost, "/trigger").Code)
+		assert.Len(t, s.triggered(), 1, "repeated triggers should collapse into one")
+	})
+}
+
+// signControl returns the headers of a control request to path signed with secret at signed.
+func signControl(secret, path string, signed time.Time) map[string]string {
+	timestamp := strconv.FormatInt(signed.Unix(), 10)
+	return map[string]string{
+		controlTimestampHeader:  timestamp,
+		approvalSignatureHeader: sign(secret, controlMessage(http.MethodPost, path, timestamp)),
+	}
+}
+
+func TestControlAuthorization(t *testing.T) {
+	do := func(handler http.Handler, remote, path string, headers map[string]string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = remote
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("localhost only without secret", func(t *testing.T) {
+		s := newStatus()
+		handler := newStatusHandler(s, newMetrics(), nil, "")
+		for _, path := range []string{"/pause", "/resume", "/trigger"} {
+			assert.Equal(t, http.StatusForbidden, do(handler, "10.0.0.5:40000", path, nil), path)
+		}
+		assert.False(t, s.isPaused())
+		assert.Empty(t, s.triggered())
+		assert.Equal(t, http.StatusOK, do(handler, "[::1]:40000", "/pause", nil))
+		assert.True(t, s.isPaused())
+	})
+
+	t.Run("signed with secret", func(t *testing.T) {
+		s := newStatus()
+		handler := newStatusHandler(s, newMetrics(), nil, "secret")
+		now := time.Now()
+		assert.Equal(t, http.StatusForbidden, do(handler, "127.0.0.1:40000", "/pause", nil), "localhost requests must be signed too")
+		assert.Equal(t, http.StatusForbidden, do(handler, "10.0.0.5:40000", "/pause", signControl("other", "/pause", now)))
+		assert.Equal(t, http.StatusForbidden, do(handler, "10.0.0.5:40000", "/pause", signControl("secret", "/resume", now)), "a signature only applies to its own endpoint")
+		unsigned := signControl("secret", "/pause", now)
+		unsigned[controlTimestampHeader] = strconv.FormatInt(now.Add(time.Second).Unix(), 10)
+		assert.Equal(t, http.StatusForbidden, do(handler, "10.0.0.5:40000", "/pause", unsigned), "the timestamp is signed")
+		assert.Equal(t, http.StatusForbidden, do(handler, "10.0.0.5:40000", "/pause", signControl("secret", "/pause", now.Add(-10*time.Minute))), "old signatures expire")
+		assert.Equal(t, http.StatusForbidden, do(handler, "10.0.0.5:40000", "/pause", signControl("secret", "/pause", now.Add(10*time.Minute))))
+		assert.False(t, s.isPaused())
+
+		pause := signControl("secret", "/pause", now)
+		assert.Equal(t, http.StatusOK, do(handler, "10.0.0.5:40000", "/pause", pause))
+		assert.True(t, s.isPaused())
+		assert.Equal(t, http.StatusOK, do(handler, "10.0.0.5:40000", "/resume", signControl("secret", "/resume", now)))
+		assert.False(t, s.isPaused())
+		assert.Equal(t, http.StatusForbidden, do(handler, "10.0.0.5:40000", "/pause", pause), "a signature is only accepted once")
+		pause[approvalSignatureHeader] = strings.ToUpper(pause[approvalSignatureHeader])
+		assert.Equal(t, http.StatusForbidden, do(handler, "10.0.0.5:40000", "/pause", pause), "a signature is only accepted once")
+		assert.False(t, s.isPaused())
+		assert.Equal(t, http.StatusAccepted, do(handler, "10.0.0.5:40000", "/trigger", signControl("secret", "/trigger", now)))
+		assert.Len(t, s.triggered(), 1)
+	})
+}
+
+func TestStatusParallelClusters(t *testing.T) {
+	s := newStatus()
+	a1 := instance{instanceID: "inst-a1", containerInstanceID: "cont-inst-a1"}
+	a2 := instance{instanceID: "inst-a2", containerInstanceID: "cont-inst-a2"}
+	b1 := instance{instanceID: "inst-b1", containerInstanceID: "cont-inst-b1"}
+
+	// Both clusters report their progress at the same time.
+	var wg sync.WaitGroup
+	for cluster, queue := range map[string][]instance{"cluster-a": {a1, a2}, "cluster-b": {b1}} {
+		wg.Add(1)
+		go func(cluster string, queue []instance) {
+			defer wg.Done()
+			s.setActivity(cluster, activityDiscovering)
+			s.setQueue(cluster, queue)
+			s.start(cluster, queue[0])
+		}(cluster, queue)
+	}
+	wg.Wait()
+	s.setActivity("cluster-a", activityDraining)
+	s.setActivity("cluster-b", activityVerifying)
+
+	snap := s.snapshot()
+	assert.Equal(t, activityClusters, snap.Activity)
+	assert.Nil(t, snap.Current)
+	assert.Empty(t, snap.Queue)
+	assert.Equal(t, []clusterProgressStatus{
+		{Cluster: "cluster-a", Activity: activityDraining, Current: instanceStatusPtr(a1), Queue: []instanceStatus{newInstanceStatus(a2)}},
+		{Cluster: "cluster-b", Activity: activityVerifying, Current: instanceStatusPtr(b1), Queue: []instanceStatus{}},
+	}, snap.InProgress)
+
+	// The cycle finishing in one cluster leaves the other cluster's progress untouched.
+	s.finish(newRunReport("cluster-b"))
+	snap = s.snapshot()
+	assert.Equal(t, activityDraining, snap.Activity)
+	assert.Equal(t, instanceStatusPtr(a1), snap.Current)
+	assert.Equal(t, []instanceStatus{newInstanceStatus(a2)}, snap.Queue)
+	require.Len(t, snap.InProgress, 1)
+	assert.Equal(t, "cluster-a", snap.InProgress[0].Cluster)
+	require.NotNil(t, snap.LastReport)
+	assert.Equal(t, "cluster-b", snap.LastReport.Cluster)
+
+	s.finish(newRunReport("cluster-a"))
+	snap = s.snapshot()
+	assert.Equal(t, activityIdle, snap.Activity)
+	assert.Empty(t, snap.InProgress)
+	assert.Len(t, snap.Clusters, 2)
+}
+
+func instanceStatusPtr(inst instance) *instanceStatus {
+	status := newInstanceStatus(inst)
+	return &status
+}
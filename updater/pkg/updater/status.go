@@ -0,0 +1,288 @@
+package updater
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// activity describes what the updater is doing at a given moment.
+type activity string
+
+const (
+	activityIdle        activity = "idle"
+	activityDiscovering activity = "discovering"
+	activityChecking    activity = "checking"
+	activityEligibility activity = "checking-eligibility"
+	activityDraining    activity = "draining"
+	activityUpdating    activity = "updating"
+	activityVerifying   activity = "verifying"
+	activityWaiting     activity = "waiting"
+	activityPaused      activity = "paused"
+	activityApproval    activity = "awaiting-approval"
+	// activityClusters is reported when several clusters are being processed at the same time;
+	// what each of them is doing is reported separately.
+	activityClusters activity = "processing-clusters"
+)
+
+// status is the live state of the updater, shared between the update loop and the HTTP
+// server. A nil status ignores updates, which is the case when no HTTP server is running.
+type status struct {
+	mu sync.Mutex
+	// activity is what the updater does between cycles; progress holds what it is doing in
+	// each cluster whose cycle is in progress, as clusters may be processed in parallel.
+	activity  activity
+	progress  map[string]*clusterProgress
+	nextCycle time.Time
+	// lastReports holds the report of the last cycle in each cluster, and lastCluster the
+	// cluster whose cycle finished last.
+	lastReports map[string]*runReport
+	lastCluster string
+	paused      bool
+	ready       bool
+	trigger     chan struct{}
+}
+
+// clusterProgress is the state of a cycle in progress in one cluster.
+type clusterProgress struct {
+	activity activity
+	current  *instance
+	queue    []instance
+}
+
+func newStatus() *status {
+	return &status{
+		activity: activityIdle,
+		progress: make(map[string]*clusterProgress),
+		// trigger is buffered so that a trigger request never blocks and repeated requests
+		// before the next cycle collapse into one.
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// cluster returns the progress of the cycle in the cluster, starting it if needed. The caller
+// must hold the lock.
+func (s *status) cluster(cluster string) *clusterProgress {
+	p, ok := s.progress[cluster]
+	if !ok {
+		p = &clusterProgress{}
+		s.progress[cluster] = p
+	}
+	return p
+}
+
+// setActivity records what the updater is doing in the cluster.
+func (s *status) setActivity(cluster string, a activity) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cluster(cluster).activity = a
+}
+
+// setQueue records the candidates still waiting to be processed in the current cycle in the
+// cluster.
+func (s *status) setQueue(cluster string, queue []instance) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cluster(cluster).queue = append([]instance(nil), queue...)
+}
+
+// start records that the updater started working on the first instance in the cluster's queue.
+func (s *status) start(cluster string, inst instance) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.cluster(cluster)
+	p.current = &inst
+	if len(p.queue) > 0 && p.queue[0].instanceID == inst.instanceID {
+		p.queue = p.queue[1:]
+	}
+}
+
+// finish records the report of a completed cycle and resets the state of its cluster.
+func (s *status) finish(report *runReport) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.progress, report.cluster)
+	s.activity = activityIdle
+	if s.lastReports == nil {
+		s.lastReports = make(map[string]*runReport)
+	}
+	s.lastReports[report.cluster] = report
+	s.lastCluster = report.cluster
+}
+
+// waitUntil records when the next cycle is due.
+func (s *status) waitUntil(t time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.activity = activityWaiting
+	s.nextCycle = t
+}
+
+func (s *status) setReady() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ready = true
+}
+
+func (s *status) isReady() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready
+}
+
+// setPaused pauses or resumes the rollout. A paused updater finishes the instance it is working
+// on and does not start further instances or cycles until resumed.
+func (s *status) setPaused(paused bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = paused
+}
+
+func (s *status) isPaused() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// requestCycle asks the daemon to start the next cycle immediately.
+func (s *status) requestCycle() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// triggered returns a channel that receives when an immediate cycle is requested.
+func (s *status) triggered() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.trigger
+}
+
+// instanceStatus is the serializable form of an instance in the status.
+type instanceStatus struct {
+	InstanceID           string `json:"instanceId"`
+	ContainerInstanceARN string `json:"containerInstanceArn"`
+	BottlerocketVersion  string `json:"bottlerocketVersion,omitempty"`
+	TargetVersion        string `json:"targetVersion,omitempty"`
+}
+
+// clusterProgressStatus is the serializable form of a cycle in progress in a cluster.
+type clusterProgressStatus struct {
+	Cluster  string           `json:"cluster"`
+	Activity activity         `json:"activity"`
+	Current  *instanceStatus  `json:"current,omitempty"`
+	Queue    []instanceStatus `json:"queue"`
+}
+
+// statusSnapshot is the serializable form of the status. Activity, Current and Queue describe
+// the cycle in progress when a single cluster is being processed.
+type statusSnapshot struct {
+	Activity   activity         `json:"activity"`
+	Paused     bool             `json:"paused"`
+	Current    *instanceStatus  `json:"current,omitempty"`
+	Queue      []instanceStatus `json:"queue"`
+	NextCycle  *time.Time       `json:"nextCycle,omitempty"`
+	LastReport *RunSummary      `json:"lastCycle,omitempty"`
+	// InProgress holds the cycles in progress, one per cluster being processed.
+	InProgress []clusterProgressStatus `json:"inProgress,omitempty"`
+	// Clusters holds the last cycle of every cluster when the updater manages several.
+	Clusters []RunSummary `json:"clusters,omitempty"`
+}
+
+// snapshot returns a consistent copy of the status.
+func (s *status) snapshot() statusSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := statusSnapshot{
+		Activity: s.activity,
+		Paused:   s.paused,
+		Queue:    make([]instanceStatus, 0),
+	}
+	clusters := make([]string, 0, len(s.progress))
+	for cluster := range s.progress {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	for _, cluster := range clusters {
+		p := s.progress[cluster]
+		progress := clusterProgressStatus{
+			Cluster:  cluster,
+			Activity: p.activity,
+			Queue:    make([]instanceStatus, 0, len(p.queue)),
+		}
+		if p.current != nil {
+			current := newInstanceStatus(*p.current)
+			progress.Current = &current
+		}
+		for _, inst := range p.queue {
+			progress.Queue = append(progress.Queue, newInstanceStatus(inst))
+		}
+		snap.InProgress = append(snap.InProgress, progress)
+	}
+	switch len(snap.InProgress) {
+	case 0:
+	case 1:
+		snap.Activity = snap.InProgress[0].Activity
+		snap.Current = snap.InProgress[0].Current
+		snap.Queue = snap.InProgress[0].Queue
+	default:
+		snap.Activity = activityClusters
+	}
+	if s.activity == activityWaiting && !s.nextCycle.IsZero() {
+		next := s.nextCycle
+		snap.NextCycle = &next
+	}
+	if last, ok := s.lastReports[s.lastCluster]; ok {
+		summary := last.summary()
+		snap.LastReport = &summary
+	}
+	if len(s.lastReports) > 1 {
+		clusters := make([]string, 0, len(s.lastReports))
+		for cluster := range s.lastReports {
+			clusters = append(clusters, cluster)
+		}
+		sort.Strings(clusters)
+		for _, cluster := range clusters {
+			snap.Clusters = append(snap.Clusters, s.lastReports[cluster].summary())
+		}
+	}
+	return snap
+}
+
+func newInstanceStatus(inst instance) instanceStatus {
+	return instanceStatus{
+		InstanceID:           inst.instanceID,
+		ContainerInstanceARN: inst.containerInstanceID,
+		BottlerocketVersion:  inst.bottlerocketVersion,
+		TargetVersion:        inst.targetVersion,
+	}
+}
@@ -0,0 +1,200 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracingServiceName = "bottlerocket-ecs-updater"
+	otlpTracesPath     = "/v1/traces"
+	otlpExportTimeout  = 10 * time.Second
+)
+
+// parseOTLPEndpoint returns the URL to which traces are exported for an OTLP/HTTP endpoint, for
+// example "http://localhost:4318".
+func parseOTLPEndpoint(endpoint string) (string, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("invalid OTLP endpoint %q: must be an absolute http or https URL", endpoint)
+	}
+	if !strings.HasSuffix(parsed.Path, otlpTracesPath) {
+		parsed.Path = strings.TrimSuffix(parsed.Path, "/") + otlpTracesPath
+	}
+	return parsed.String(), nil
+}
+
+// tracer records OpenTelemetry spans of update runs. A span is the child of the span carried by
+// the context it is started with, so clusters updated in parallel each get their own trace.
+// Finished spans are exported to the collector in the background by a batch span processor, so
+// a slow or unreachable collector never holds up a rollout. A nil tracer records nothing.
+type tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// newTracer returns a tracer exporting with OTLP over HTTP to the endpoint, for example
+// "http://localhost:4318".
+func newTracer(endpoint string) (*tracer, error) {
+	tracesURL, err := parseOTLPEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(tracesURL),
+		otlptracehttp.WithTimeout(otlpExportTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	return newTracerWith(sdktrace.WithBatcher(exporter, sdktrace.WithExportTimeout(otlpExportTimeout))), nil
+}
+
+// newTracerWith returns a tracer whose provider has the given options, such as its span
+// processor, and the updater's resource attributes.
+func newTracerWith(opts ...sdktrace.TracerProviderOption) *tracer {
+	opts = append(opts, sdktrace.WithResource(resource.NewSchemaless(
+		attribute.String("service.name", tracingServiceName),
+		attribute.String("cloud.provider", "aws"))))
+	provider := sdktrace.NewTracerProvider(opts...)
+	return &tracer{
+		provider: provider,
+		tracer:   provider.Tracer(tracingServiceName),
+	}
+}
+
+// span is a single timed operation within a trace. A nil span records nothing.
+type span struct {
+	span trace.Span
+}
+
+// start starts a span as a child of the span in ctx, or as the root of a new trace, and returns
+// a context carrying it.
+func (t *tracer) start(ctx context.Context, name string, attrs ...interface{}) (context.Context, *span) {
+	return t.startKind(ctx, name, trace.SpanKindInternal, attrs...)
+}
+
+func (t *tracer) startKind(ctx context.Context, name string, kind trace.SpanKind, attrs ...interface{}) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	ctx, s := t.tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(keyValues(attrs)...))
+	return ctx, &span{span: s}
+}
+
+// current returns the span carried by ctx.
+func (t *tracer) current(ctx context.Context) *span {
+	if t == nil {
+		return nil
+	}
+	return &span{span: trace.SpanFromContext(ctx)}
+}
+
+// flush exports the spans that have finished but not been exported yet, waiting at most
+// otlpExportTimeout for the collector.
+func (t *tracer) flush() {
+	if t == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), otlpExportTimeout)
+	defer cancel()
+	if err := t.provider.ForceFlush(ctx); err != nil {
+		log.Printf("Failed to export spans: %v", err)
+	}
+}
+
+// set adds attributes, given as key/value pairs, to the span.
+func (s *span) set(attrs ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(keyValues(attrs)...)
+}
+
+// fail marks the span as failed.
+func (s *span) fail(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// finish ends the span. Ending a span more than once has no effect.
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
+
+func keyValues(attrs []interface{}) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs)/2)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		key := fmt.Sprint(attrs[i])
+		switch value := attrs[i+1].(type) {
+		case int:
+			kvs = append(kvs, attribute.Int(key, value))
+		case int64:
+			kvs = append(kvs, attribute.Int64(key, value))
+		case bool:
+			kvs = append(kvs, attribute.Bool(key, value))
+		default:
+			kvs = append(kvs, attribute.String(key, fmt.Sprint(value)))
+		}
+	}
+	return kvs
+}
+
+// instrument adds handlers to an AWS SDK client so that each API call made with a context
+// carrying a span, including each call made by a waiter, is recorded as a child of that span.
+// Calls made without one are not recorded.
+func (t *tracer) instrument(handlers *request.Handlers) {
+	if t == nil {
+		return
+	}
+	handlers.Validate.PushFrontNamed(request.NamedHandler{
+		Name: "updater.tracing.start",
+		Fn: func(r *request.Request) {
+			if !trace.SpanContextFromContext(r.Context()).IsValid() {
+				return
+			}
+			ctx, s := t.startKind(r.Context(), r.ClientInfo.ServiceID+"."+r.Operation.Name, trace.SpanKindClient,
+				"rpc.system", "aws-api",
+				"rpc.service", r.ClientInfo.ServiceID,
+				"rpc.method", r.Operation.Name)
+			r.SetContext(context.WithValue(ctx, spanContextKey{}, s))
+		},
+	})
+	handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "updater.tracing.end",
+		Fn: func(r *request.Request) {
+			s, ok := r.Context().Value(spanContextKey{}).(*span)
+			if !ok {
+				return
+			}
+			if r.RequestID != "" {
+				s.set("aws.request_id", r.RequestID)
+			}
+			if r.HTTPResponse != nil {
+				s.set("http.status_code", r.HTTPResponse.StatusCode)
+			}
+			s.set("aws.retry_count", r.RetryCount)
+			s.fail(r.Error)
+			s.finish()
+		},
+	})
+}
+
+type spanContextKey struct{}
@@ -0,0 +1,169 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// testTracer returns a tracer exporting every span to an in-memory exporter as soon as it ends.
+func testTracer() (*tracer, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return newTracerWith(sdktrace.WithSyncer(exporter)), exporter
+}
+
+func spansByName(spans tracetest.SpanStubs) map[string]tracetest.SpanStub {
+	byName := make(map[string]tracetest.SpanStub)
+	for _, s := range spans {
+		byName[s.Name] = s
+	}
+	return byName
+}
+
+func TestParseOTLPEndpoint(t *testing.T) {
+	cases := []struct {
+		endpoint string
+		expected string
+	}{
+		{endpoint: "http://localhost:4318", expected: "http://localhost:4318/v1/traces"},
+		{endpoint: "https://collector.example.com/otlp/", expected: "https://collector.example.com/otlp/v1/traces"},
+		{endpoint: "http://localhost:4318/v1/traces", expected: "http://localhost:4318/v1/traces"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.endpoint, func(t *testing.T) {
+			actual, err := parseOTLPEndpoint(tc.endpoint)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+
+	for _, endpoint := range []string{"localhost:4318", "grpc://localhost:4317", "/v1/traces"} {
+		t.Run("invalid "+endpoint, func(t *testing.T) {
+			_, err := parseOTLPEndpoint(endpoint)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestTracerSpans(t *testing.T) {
+	tr, exporter := testTracer()
+
+	ctx, root := tr.start(context.Background(), "run", "ecs.cluster", "test-cluster")
+	instCtx, inst := tr.start(ctx, "instance", "ec2.instance_id", "inst-id-1")
+	_, drain := tr.start(instCtx, "drainInstance")
+	drain.finish()
+	inst.fail(errors.New("version did not change"))
+	inst.finish()
+	inst.finish()
+	// A run started alongside, for another cluster, is a trace of its own.
+	_, other := tr.start(context.Background(), "run", "ecs.cluster", "other-cluster")
+	root.set("updater.candidates", 1)
+	root.finish()
+	other.finish()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 4, "a span is exported once however many times it is ended")
+	assert.Contains(t, spans[0].Resource.Attributes(), attribute.String("service.name", tracingServiceName))
+	assert.Contains(t, spans[0].Resource.Attributes(), attribute.String("cloud.provider", "aws"))
+
+	byName := spansByName(spans[:3])
+	run := byName["run"]
+	assert.False(t, run.Parent.IsValid())
+	assert.Equal(t, run.SpanContext.SpanID(), byName["instance"].Parent.SpanID())
+	assert.Equal(t, byName["instance"].SpanContext.SpanID(), byName["drainInstance"].Parent.SpanID())
+	for _, s := range byName {
+		assert.Equal(t, run.SpanContext.TraceID(), s.SpanContext.TraceID())
+	}
+	assert.NotEqual(t, run.SpanContext.TraceID(), spans[3].SpanContext.TraceID())
+
+	assert.Equal(t, codes.Error, byName["instance"].Status.Code)
+	assert.Equal(t, "version did not change", byName["instance"].Status.Description)
+	assert.Contains(t, byName["instance"].Attributes, attribute.String("ec2.instance_id", "inst-id-1"))
+	assert.Equal(t, codes.Unset, run.Status.Code)
+	assert.Contains(t, run.Attributes, attribute.Int("updater.candidates", 1))
+	assert.Contains(t, run.Attributes, attribute.String("ecs.cluster", "test-cluster"))
+}
+
+func TestTracerExportInBackground(t *testing.T) {
+	released := make(chan struct{})
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The collector does not answer until the test is done.
+		<-released
+	}))
+	defer collector.Close()
+	defer close(released)
+	tr, err := newTracer(collector.URL)
+	require.NoError(t, err)
+
+	start := time.Now()
+	ctx, root := tr.start(context.Background(), "run")
+	_, inst := tr.start(ctx, "instance")
+	inst.finish()
+	root.finish()
+	assert.Less(t, time.Since(start), time.Second, "ending spans does not wait for the collector")
+}
+
+func TestTracerInstrument(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Amzn-Requestid", "request-id")
+		w.Write([]byte(`{}`))
+	}))
+	defer api.Close()
+	tr, exporter := testTracer()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Endpoint:    aws.String(api.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	client := ecs.New(sess)
+	tr.instrument(&client.Handlers)
+
+	// Calls made outside of a span are not recorded.
+	_, err := client.ListContainerInstancesWithContext(aws.BackgroundContext(), &ecs.ListContainerInstancesInput{Cluster: aws.String("test-cluster")})
+	require.NoError(t, err)
+	assert.Empty(t, exporter.GetSpans())
+
+	ctx, root := tr.start(context.Background(), "run")
+	_, err = client.ListContainerInstancesWithContext(ctx, &ecs.ListContainerInstancesInput{Cluster: aws.String("test-cluster")})
+	require.NoError(t, err)
+	root.finish()
+
+	byName := spansByName(exporter.GetSpans())
+	require.Len(t, byName, 2)
+	require.Contains(t, byName, "ECS.ListContainerInstances")
+	call := byName["ECS.ListContainerInstances"]
+	assert.Equal(t, byName["run"].SpanContext.SpanID(), call.Parent.SpanID())
+	assert.Equal(t, trace.SpanKindClient, call.SpanKind)
+	assert.Contains(t, call.Attributes, attribute.String("rpc.method", "ListContainerInstances"))
+	assert.Contains(t, call.Attributes, attribute.String("aws.request_id", "request-id"))
+	assert.Contains(t, call.Attributes, attribute.Int("http.status_code", 200))
+}
+
+func TestNilTracer(t *testing.T) {
+	var tr *tracer
+	ctx := context.Background()
+	spanCtx, s := tr.start(ctx, "run")
+	assert.Nil(t, s)
+	assert.Equal(t, ctx, spanCtx)
+	s.set("key", "value")
+	s.fail(errors.New("failed"))
+	s.finish()
+	assert.Nil(t, tr.current(ctx))
+	tr.flush()
+}
@@ -0,0 +1,562 @@
+// Package updater updates the Bottlerocket container instances of Amazon ECS clusters, draining
+// one instance at a time and returning it to service once it runs the new version. The
+// bottlerocket-ecs-updater command is a thin wrapper around it.
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// Options configures an Updater. The string settings take the same syntax as the
+// bottlerocket-ecs-updater flag of the same name.
+type Options struct {
+	// Session is used to create the AWS clients. If nil, a session for Region is created.
+	Session *session.Session
+	// Region is the AWS Region in which the clusters are running, unless a target names another.
+	Region string
+
+	// Clusters holds the short names or ARNs of the clusters to manage.
+	Clusters []string
+	// ClusterPattern is a glob pattern; every cluster in the Region whose name matches it is
+	// managed as well.
+	ClusterPattern string
+	// ClusterTag is a <key>=<value> tag; every cluster in the Region carrying it is managed as
+	// well.
+	ClusterTag string
+	// Targets is a semicolon-separated list of clusters in other accounts or Regions, as
+	// <role ARN>,<region>,<cluster>.
+	Targets string
+	// ParallelClusters is the number of clusters updated at the same time; at least 1.
+	ParallelClusters int
+
+	// CheckDocument, ApplyDocument and RebootDocument name the SSM documents that check for,
+	// apply and reboot into an update. They are required.
+	CheckDocument  string
+	ApplyDocument  string
+	RebootDocument string
+	// VariantDocuments overrides the documents by Bottlerocket variant, as
+	// <variant>=<check>,<apply>,<reboot> entries separated by semicolons.
+	VariantDocuments string
+
+	// Alarms holds CloudWatch alarm names; the rollout halts if any of them is in ALARM state.
+	Alarms []string
+	// FailureBudget is the number (e.g. 3) or percentage of candidates (e.g. 20%) of failed
+	// instances after which the rollout stops.
+	FailureBudget string
+	// QuarantineAfter is the number of consecutive failed updates after which an instance is
+	// quarantined; 0 disables quarantine.
+	QuarantineAfter int
+	// OptOutAttribute and OptOutTag name the ECS attribute and EC2 tag that exclude an instance
+	// from updates; empty disables them.
+	OptOutAttribute string
+	OptOutTag       string
+	// Include and Exclude are comma-separated lists of instance selectors.
+	Include string
+	Exclude string
+	// MaintenanceWindows, MaintenanceTimezone and BlackoutDates restrict when instances are
+	// updated.
+	MaintenanceWindows  string
+	MaintenanceTimezone string
+	BlackoutDates       string
+
+	// Interval and Jitter space the update cycles of RunDaemon.
+	Interval time.Duration
+	Jitter   time.Duration
+	// Backoff is how long later runs of the same Updater skip an instance after its first failed
+	// update, doubled after each further consecutive failure up to MaxBackoff; 0 disables it.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// EMF, if set, receives the results of each run as CloudWatch Embedded Metric Format log
+	// lines in EMFNamespace.
+	EMF          io.Writer
+	EMFNamespace string
+	// EventBus is the name or ARN of an EventBridge event bus to which instance state
+	// transitions are published.
+	EventBus string
+	// SNSTopic and WebhookURL receive run summaries and fatal errors, rendered with the
+	// templates and sent as allowed by the filters.
+	SNSTopic        string
+	SNSTemplate     string
+	SNSFilter       string
+	WebhookURL      string
+	WebhookTemplate string
+	WebhookFilter   string
+	// HTTPAddress is the address on which Serve serves the status, control and metrics
+	// endpoints.
+	HTTPAddress string
+	// OTLPEndpoint is the OTLP/HTTP endpoint to which traces of each run are exported.
+	OTLPEndpoint string
+
+	// RemoteConfigPath is the SSM Parameter Store path whose parameters override settings.
+	RemoteConfigPath string
+	// KillSwitch is a comma-separated list of kill switches that pause the rollout.
+	KillSwitch string
+	// Approval is a comma-separated list of approval sources each run's plan waits for, for up
+	// to ApprovalTimeout. ApprovalSecret signs HTTP approvals and control requests.
+	Approval        string
+	ApprovalTimeout time.Duration
+	ApprovalSecret  string
+
+	// Plan, if set, is carried out by Run instead of updating every candidate. It names the
+	// clusters, so Clusters, ClusterPattern, ClusterTag and Targets must be empty.
+	Plan *Plan
+	// Instances limits Run to the instances with these EC2 instance IDs; the run fails without
+	// updating anything if any of them has no update available. Instances are named within a
+	// single cluster, given with Clusters or Targets.
+	Instances []string
+
+	Hooks Hooks
+}
+
+// Hooks are called as the updater works. Each hook is optional and is called from the
+// goroutine updating the cluster, so hooks must be safe for concurrent use when clusters are
+// updated in parallel.
+type Hooks struct {
+	// Transition is called for every instance state transition, with the detail of the event
+	// published to EventBridge.
+	Transition func(t EventType, detail EventDetail)
+	// BeforeDrain is called before an instance is drained. If it returns an error the instance
+	// is skipped.
+	BeforeDrain func(ctx context.Context, inst Instance) error
+	// RunFinished is called at the end of the run in each cluster with its summary and error.
+	RunFinished func(summary RunSummary, err error)
+}
+
+// Instance identifies an instance passed to hooks.
+type Instance struct {
+	Cluster              string
+	InstanceID           string
+	ContainerInstanceARN string
+	Variant              string
+	// Version is the Bottlerocket version the instance runs, and TargetVersion the version it
+	// will be updated to.
+	Version       string
+	TargetVersion string
+}
+
+func (i instance) hookInstance(cluster string) Instance {
+	return Instance{
+		Cluster:              cluster,
+		InstanceID:           i.instanceID,
+		ContainerInstanceARN: i.containerInstanceID,
+		Variant:              i.variant,
+		Version:              i.bottlerocketVersion,
+		TargetVersion:        i.targetVersion,
+	}
+}
+
+// ConfigError lists every problem found in the configuration.
+type ConfigError struct {
+	Problems []error
+}
+
+func (e *ConfigError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, err := range e.Problems {
+		lines = append(lines, "  - "+err.Error())
+	}
+	return fmt.Sprintf("invalid configuration, %d problem(s) found:\n%s", len(e.Problems), strings.Join(lines, "\n"))
+}
+
+// Updater updates the Bottlerocket instances of the clusters it manages.
+type Updater struct {
+	opts   Options
+	fleet  *fleet
+	status *status
+	// metrics and approval are served by Serve.
+	metrics  *metrics
+	approval *approvalGate
+	// tracer exports the spans of every cluster's runs.
+	tracer *tracer
+}
+
+// New validates the options and returns an Updater. The error is a *ConfigError listing every
+// problem found.
+func New(opts Options) (*Updater, error) {
+	errs := make([]error, 0)
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if opts.Region == "" && opts.Session == nil {
+		check(errors.New("region is required"))
+	}
+	if opts.CheckDocument == "" {
+		check(errors.New("check-document is required"))
+	}
+	if opts.ApplyDocument == "" {
+		check(errors.New("apply-document is required"))
+	}
+	if opts.RebootDocument == "" {
+		check(errors.New("reboot-document is required"))
+	}
+	var clusters clusterSelector
+	var err error
+	if opts.Plan != nil {
+		// The plan names the clusters it applies to.
+		if len(opts.Clusters) != 0 || opts.ClusterPattern != "" || opts.ClusterTag != "" || opts.Targets != "" {
+			check(errors.New("cluster, cluster-pattern, cluster-tag and targets cannot be used with a plan, the plan names the clusters"))
+		}
+		clusters = clusterSelector{targets: opts.Plan.targets()}
+	} else {
+		clusters, err = parseClusterSelector(opts.Clusters, opts.ClusterPattern, opts.ClusterTag, opts.Targets)
+		check(err)
+	}
+	// Instances are named within a single cluster.
+	if len(opts.Instances) != 0 && (len(clusters.targets) != 1 || clusters.discovers()) {
+		check(errors.New("instances are named within a single cluster, given with cluster or targets"))
+	}
+	if opts.ParallelClusters < 1 {
+		check(errors.New("parallel-clusters must be at least 1"))
+	}
+	variantDocuments, err := parseVariantDocuments(opts.VariantDocuments)
+	check(err)
+	if len(opts.Alarms) > maxAlarmNames {
+		check(fmt.Errorf("at most %d alarms may be specified", maxAlarmNames))
+	}
+	budget, err := parseFailureBudget(opts.FailureBudget)
+	check(err)
+	maintenance, err := parseSchedule(opts.MaintenanceWindows, opts.BlackoutDates, opts.MaintenanceTimezone)
+	check(err)
+	selection, err := parseSelection(opts.Include, opts.Exclude)
+	check(err)
+	killSwitch, err := parseKillSwitch(opts.KillSwitch)
+	check(err)
+	approval, err := parseApprovalSources(opts.Approval)
+	check(err)
+	for _, source := range approval {
+		if source.kind != "http" {
+			continue
+		}
+		if opts.ApprovalSecret == "" {
+			check(errors.New("approval-secret is required for http approval"))
+		}
+		if opts.HTTPAddress == "" {
+			check(errors.New("http-address is required for http approval"))
+		}
+	}
+	if len(approval) != 0 && opts.ApprovalTimeout <= 0 {
+		check(errors.New("approval-timeout must be positive"))
+	}
+	if opts.QuarantineAfter < 0 {
+		check(errors.New("quarantine-after must not be negative"))
+	}
+	if opts.Backoff < 0 || opts.MaxBackoff < 0 {
+		check(errors.New("backoff and max-backoff must not be negative"))
+	}
+	if opts.RemoteConfigPath != "" && !strings.HasPrefix(opts.RemoteConfigPath, "/") {
+		check(fmt.Errorf("remote-config-path %q must start with /", opts.RemoteConfigPath))
+	}
+	if opts.OTLPEndpoint != "" {
+		_, err := parseOTLPEndpoint(opts.OTLPEndpoint)
+		check(err)
+	}
+	sess := opts.Session
+	if sess == nil {
+		sess, err = session.NewSession(&aws.Config{Region: aws.String(opts.Region)})
+		check(err)
+	}
+	var targets []*notificationTarget
+	if sess != nil {
+		targets, err = notificationTargets(opts, sns.New(sess, aws.NewConfig()))
+		check(err)
+	}
+	if len(errs) != 0 {
+		return nil, &ConfigError{Problems: errs}
+	}
+
+	u := &Updater{opts: opts}
+	if opts.OTLPEndpoint != "" {
+		if u.tracer, err = newTracer(opts.OTLPEndpoint); err != nil {
+			return nil, &ConfigError{Problems: []error{err}}
+		}
+	}
+	base := clusterUpdater{
+		checkDocument:    opts.CheckDocument,
+		applyDocument:    opts.ApplyDocument,
+		rebootDocument:   opts.RebootDocument,
+		variantDocuments: variantDocuments,
+		alarms:           opts.Alarms,
+		budget:           budget,
+		maintenance:      maintenance,
+		quarantineAfter:  opts.QuarantineAfter,
+		optOutAttribute:  opts.OptOutAttribute,
+		optOutTag:        opts.OptOutTag,
+		selection:        selection,
+		only:             opts.Instances,
+		hooks:            opts.Hooks,
+		tracer:           u.tracer,
+	}
+	// Remote configuration, kill switch and approval parameters are read with the updater's own
+	// credentials.
+	baseSSM := ssm.New(sess, aws.NewConfig())
+	if opts.RemoteConfigPath != "" {
+		base.remote = newRemoteConfig(baseSSM, opts.RemoteConfigPath)
+	}
+	base.approval = newApprovalGate(approval, baseSSM, opts.ApprovalSecret, opts.ApprovalTimeout)
+	u.approval = base.approval
+	if opts.EMF != nil {
+		namespace := opts.EMFNamespace
+		if namespace == "" {
+			namespace = DefaultEMFNamespace
+		}
+		base.emf = newEMFEmitter(opts.EMF, namespace)
+	}
+	if opts.HTTPAddress != "" {
+		base.status = newStatus()
+		base.metrics = newMetrics()
+	}
+	u.status = base.status
+	u.metrics = base.metrics
+
+	// newUpdater returns the updater for a target. Each updater has its own clients, which use
+	// the target's region and the credentials of the target's role. The tracer is shared: spans
+	// follow the context they are started with, so clusters updated in parallel each get their
+	// own trace.
+	newUpdater := func(t target) (*clusterUpdater, error) {
+		cu := base
+		cu.cluster = t.cluster
+		cu.region = aws.StringValue(sess.Config.Region)
+		if t.region != "" {
+			cu.region = t.region
+		}
+		config := aws.NewConfig().WithRegion(cu.region)
+		if t.roleARN != "" {
+			config = config.WithCredentials(stscreds.NewCredentials(sess, t.roleARN))
+		}
+		ecsClient := ecs.New(sess, config)
+		ssmClient := ssm.New(sess, config)
+		ec2Client := ec2.New(sess, config)
+		cloudwatchClient := cloudwatch.New(sess, config)
+		// Events are published with the updater's own credentials, to the bus in its account.
+		eventsClient := eventbridge.New(sess, aws.NewConfig())
+		for _, handlers := range []*request.Handlers{
+			&ecsClient.Handlers, &ssmClient.Handlers, &ec2Client.Handlers,
+			&cloudwatchClient.Handlers, &eventsClient.Handlers,
+		} {
+			cu.tracer.instrument(handlers)
+		}
+		cu.ecs = ecsClient
+		cu.ssm = ssmClient
+		cu.ec2 = ec2Client
+		cu.cloudwatch = cloudwatchClient
+		cu.killSwitch = newKillSwitch(killSwitch, baseSSM, ecsClient, t.cluster)
+
+		if opts.EventBus != "" || opts.Hooks.Transition != nil {
+			cu.events = &eventPublisher{
+				cluster: t.cluster,
+				hook:    opts.Hooks.Transition,
+			}
+			if opts.EventBus != "" {
+				cu.events.events = eventsClient
+				cu.events.busName = opts.EventBus
+			}
+		}
+		if len(targets) != 0 {
+			cu.notifier = &notifier{cluster: t.cluster, targets: targets}
+		}
+		if opts.Backoff > 0 {
+			cu.backoff = newBackoffTracker(opts.Backoff, opts.MaxBackoff)
+		}
+		if opts.Plan != nil {
+			cu.planned = opts.Plan.cluster(t)
+		}
+		return &cu, nil
+	}
+	u.fleet = newFleet(ecs.New(sess, aws.NewConfig()), clusters, opts.ParallelClusters, newUpdater)
+	u.fleet.remote = base.remote
+	return u, nil
+}
+
+// Run performs an update cycle in every cluster and returns the results. The error summarizes
+// the clusters whose cycle failed. Cancelling ctx stops each cycle before its next instance is
+// started and interrupts draining.
+func (u *Updater) Run(ctx context.Context) (*Result, error) {
+	u.status.setReady()
+	report, err := u.fleet.run(ctx)
+	u.tracer.flush()
+	return report.result(), err
+}
+
+// RunDaemon repeats the update cycle every Interval, plus a random delay of up to Jitter, until
+// ctx is cancelled. Errors from a cycle are logged and do not stop the daemon.
+func (u *Updater) RunDaemon(ctx context.Context) error {
+	if u.opts.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	return runDaemon(ctx, func(ctx aws.Context) error {
+		_, err := u.fleet.run(ctx)
+		u.tracer.flush()
+		return err
+	}, u.status, u.opts.Interval, u.opts.Jitter)
+}
+
+// Serve serves the HTTP status, control and metrics endpoints on HTTPAddress until ctx is
+// cancelled. It returns immediately if HTTPAddress is empty.
+func (u *Updater) Serve(ctx context.Context) {
+	if u.opts.HTTPAddress == "" {
+		return
+	}
+	serveHTTP(ctx, u.opts.HTTPAddress, newStatusHandler(u.status, u.metrics, u.approval, u.opts.ApprovalSecret))
+}
+
+// Plan checks every cluster for updates and returns the instances a run would update, without
+// changing anything. Set Options.Plan to carry it out.
+func (u *Updater) Plan() (*Plan, error) {
+	return u.fleet.plan()
+}
+
+// Inventory runs the check document on every Bottlerocket instance of every cluster and returns
+// the version inventory, flagging instances more than threshold releases behind.
+func (u *Updater) Inventory(threshold int) (*Inventory, error) {
+	instances := make([]InventoryInstance, 0)
+	err := u.fleet.each(func(cu *clusterUpdater) error {
+		found, err := cu.inventory()
+		instances = append(instances, found...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newInventory(instances, threshold, time.Now()), nil
+}
+
+// Status writes the Bottlerocket version, update state and update history of the instances
+// with the given EC2 instance IDs, or of every instance, of every cluster as a table.
+func (u *Updater) Status(w io.Writer, ids []string) error {
+	return u.fleet.each(func(cu *clusterUpdater) error { return cu.printStatus(w, ids) })
+}
+
+// Check runs the check document on the instances with the given EC2 instance IDs, or on every
+// active instance, of every cluster and writes its output for each of them.
+func (u *Updater) Check(w io.Writer, ids []string) error {
+	return u.fleet.each(func(cu *clusterUpdater) error { return cu.printCheck(w, ids) })
+}
+
+// Drain drains the instances with the given EC2 instance IDs one at a time and leaves them
+// DRAINING. It refuses to drain instances running non-service tasks.
+func (u *Updater) Drain(ctx context.Context, ids []string) error {
+	return u.fleet.each(func(cu *clusterUpdater) error { return cu.drainOnly(ctx, ids) })
+}
+
+// Reactivate returns the DRAINING Bottlerocket instances with the given EC2 instance IDs, or
+// all of them, to ACTIVE.
+func (u *Updater) Reactivate(ids []string) error {
+	return u.fleet.each(func(cu *clusterUpdater) error { return cu.reactivate(ids) })
+}
+
+// clusterUpdater updates the instances of a single cluster.
+type clusterUpdater struct {
+	cluster string
+	// region is the region of the cluster.
+	region         string
+	checkDocument  string
+	applyDocument  string
+	rebootDocument string
+	// variantDocuments overrides the documents above for instances of a Bottlerocket variant.
+	variantDocuments map[string]documentSet
+	alarms           []string
+	budget           failureBudget
+	maintenance      *schedule
+	// quarantineAfter is the number of consecutive failures after which an instance is quarantined.
+	quarantineAfter int
+	// optOutAttribute and optOutTag name the ECS attribute and EC2 tag operators use to keep an
+	// instance off the updater.
+	optOutAttribute string
+	optOutTag       string
+	// selection chooses the instances managed by the updater.
+	selection selection
+	// backoff holds per-instance retry state kept between cycles.
+	backoff *backoffTracker
+	// status holds the live state exposed by the HTTP server.
+	status *status
+	// metrics holds the Prometheus metrics exposed by the HTTP server.
+	metrics *metrics
+	// emf writes CloudWatch Embedded Metric Format metrics at the end of each run.
+	emf *emfEmitter
+	// events publishes instance state transitions to EventBridge and the transition hook.
+	events *eventPublisher
+	// notifier sends run summaries and fatal errors to SNS and webhooks.
+	notifier *notifier
+	// killSwitch pauses the rollout when an operator engages it.
+	killSwitch *killSwitch
+	// approval holds each run's plan until an operator approves it, shared by every cluster.
+	approval *approvalGate
+	// planned is the plan being applied to the cluster, if any.
+	planned *ClusterPlan
+	// only holds the EC2 instance IDs of the instances to update, when only those are updated.
+	only []string
+	// remote holds the settings loaded from SSM Parameter Store, shared by every cluster.
+	remote *remoteConfig
+	// tracer records OpenTelemetry spans for each run.
+	tracer *tracer
+	// hooks are called as the updater works.
+	hooks      Hooks
+	ecs        ECSAPI
+	ssm        SSMAPI
+	ec2        EC2API
+	cloudwatch CloudWatchAPI
+}
+
+// notificationTargets returns the notification targets configured in the options.
+func notificationTargets(opts Options, snsClient SNSAPI) ([]*notificationTarget, error) {
+	targets := make([]*notificationTarget, 0)
+	if opts.SNSTopic != "" {
+		filter, err := parseNotificationFilter(opts.SNSFilter)
+		if err != nil {
+			return nil, err
+		}
+		target, err := newNotificationTarget(&snsBackend{sns: snsClient, topicARN: opts.SNSTopic}, opts.SNSTemplate, filter)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	if opts.WebhookURL != "" {
+		filter, err := parseNotificationFilter(opts.WebhookFilter)
+		if err != nil {
+			return nil, err
+		}
+		backend, err := newWebhookBackend(opts.WebhookURL)
+		if err != nil {
+			return nil, err
+		}
+		target, err := newNotificationTarget(backend, opts.WebhookTemplate, filter)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// SplitList splits a comma-separated value, such as the value of a list option, into its
+// non-empty, trimmed elements.
+func SplitList(value string) []string {
+	list := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,171 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConfigError(t *testing.T) {
+	_, err := New(Options{
+		Region:           "us-west-2",
+		ParallelClusters: 0,
+		FailureBudget:    "lots",
+		Approval:         "http",
+	})
+	var configErr *ConfigError
+	require.True(t, errors.As(err, &configErr))
+	messages := make([]string, 0, len(configErr.Problems))
+	for _, problem := range configErr.Problems {
+		messages = append(messages, problem.Error())
+	}
+	assert.Contains(t, messages, "check-document is required")
+	assert.Contains(t, messages, "apply-document is required")
+	assert.Contains(t, messages, "reboot-document is required")
+	assert.Contains(t, messages, "cluster, cluster-pattern, cluster-tag or targets is required")
+	assert.Contains(t, messages, "parallel-clusters must be at least 1")
+	assert.Contains(t, messages, "approval-secret is required for http approval")
+	assert.Contains(t, messages, "http-address is required for http approval")
+	assert.Len(t, messages, 9, "every problem is listed: %q", messages)
+	assert.Contains(t, err.Error(), "invalid configuration, 9 problem(s) found:\n  - ")
+}
+
+func TestNewOptions(t *testing.T) {
+	valid := Options{
+		Region:           "us-west-2",
+		Clusters:         []string{"prod"},
+		ParallelClusters: 1,
+		CheckDocument:    "check-doc",
+		ApplyDocument:    "apply-doc",
+		RebootDocument:   "reboot-doc",
+	}
+	u, err := New(valid)
+	require.NoError(t, err)
+	require.NotNil(t, u)
+
+	opts := valid
+	opts.Instances = []string{"inst-id-1"}
+	_, err = New(opts)
+	assert.NoError(t, err)
+
+	opts.Clusters = []string{"prod", "staging"}
+	_, err = New(opts)
+	assert.Error(t, err, "instances are named within a single cluster")
+
+	opts = valid
+	opts.Plan = &Plan{Version: planVersion, Clusters: []ClusterPlan{{Cluster: "prod"}}}
+	_, err = New(opts)
+	assert.Error(t, err, "the plan names the clusters")
+
+	opts.Clusters = nil
+	_, err = New(opts)
+	assert.NoError(t, err)
+}
+
+func TestNewSharedTracer(t *testing.T) {
+	u, err := New(Options{
+		Region:           "us-west-2",
+		Clusters:         []string{"prod"},
+		ParallelClusters: 1,
+		CheckDocument:    "check-doc",
+		ApplyDocument:    "apply-doc",
+		RebootDocument:   "reboot-doc",
+		OTLPEndpoint:     "http://localhost:4318",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, u.tracer)
+	prod, err := u.fleet.updater(target{cluster: "prod"})
+	require.NoError(t, err)
+	staging, err := u.fleet.updater(target{cluster: "staging", region: "us-east-1"})
+	require.NoError(t, err)
+	assert.Same(t, u.tracer, prod.tracer, "every cluster exports its spans with the same tracer")
+	assert.Same(t, u.tracer, staging.tracer)
+	assert.Equal(t, "us-west-2", prod.region)
+	assert.Equal(t, "us-east-1", staging.region)
+}
+
+func TestHooks(t *testing.T) {
+	u := planTestUpdater(t, []planTestInstance{
+		{id: "inst-id-1", version: "1.0.5", target: "1.0.6"},
+		{id: "inst-id-2", version: "1.0.6"},
+	})
+	var refused []Instance
+	var transitions []EventType
+	var summaries []RunSummary
+	u.hooks = Hooks{
+		BeforeDrain: func(ctx context.Context, inst Instance) error {
+			refused = append(refused, inst)
+			return errors.New("change freeze")
+		},
+		RunFinished: func(summary RunSummary, err error) {
+			assert.NoError(t, err)
+			summaries = append(summaries, summary)
+		},
+	}
+	u.events = &eventPublisher{
+		cluster: u.cluster,
+		hook: func(t EventType, detail EventDetail) {
+			transitions = append(transitions, t)
+		},
+	}
+
+	_, err := u.run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Instance{{
+		Cluster:              "test-cluster",
+		InstanceID:           "inst-id-1",
+		ContainerInstanceARN: "cont-inst-id-1",
+		Variant:              "aws-ecs-1",
+		Version:              "1.0.5",
+		TargetVersion:        "1.0.6",
+	}}, refused)
+	assert.Equal(t, []EventType{EventCandidateFound}, transitions, "instances refused by the hook are not drained")
+	require.Len(t, summaries, 1)
+	assert.Equal(t, 1, summaries[0].Skipped)
+	assert.Equal(t, skipHook, summaries[0].Results[0].Reason)
+}
+
+func TestFleetReportResult(t *testing.T) {
+	start := time.Date(2021, 3, 4, 13, 6, 7, 0, time.UTC)
+	failure := errors.New("failed to assume role")
+	report := newRunReport("cluster-a")
+	report.record(instance{instanceID: "inst-id-1"}, OutcomeUpdated, "", "")
+	report.record(instance{instanceID: "inst-id-2"}, OutcomeFailed, PhaseDrain, "timed out")
+	fr := &fleetReport{
+		start: start,
+		end:   start.Add(time.Minute),
+		results: []clusterResult{
+			{target: target{cluster: "cluster-a"}, report: report},
+			{target: target{cluster: "cluster-b"}, err: failure},
+		},
+	}
+	result := fr.result()
+	assert.Equal(t, start, result.Start)
+	assert.Equal(t, start.Add(time.Minute), result.End)
+	require.Len(t, result.Clusters, 2)
+	assert.Equal(t, "cluster-a", result.Clusters[0].Cluster)
+	require.NotNil(t, result.Clusters[0].Summary)
+	assert.Equal(t, PhaseDrain, result.Clusters[0].Summary.Results[1].Phase)
+	assert.Nil(t, result.Clusters[1].Summary)
+	assert.Equal(t, failure, result.Clusters[1].Err)
+	assert.Equal(t, 1, result.Count(OutcomeUpdated))
+	assert.Equal(t, 1, result.Count(OutcomeFailed))
+	assert.Equal(t, 0, result.Count(OutcomeSkipped))
+	assert.False(t, result.Paused())
+	assert.Empty(t, result.AwaitingApproval())
+
+	paused := newRunReport("cluster-c")
+	paused.pause("kill switch ssm:/updater/paused")
+	awaiting := newRunReport("cluster-d")
+	awaiting.awaitApproval("3f2a9c0d41be")
+	fr.results = append(fr.results,
+		clusterResult{target: target{cluster: "cluster-c"}, report: paused},
+		clusterResult{target: target{cluster: "cluster-d"}, report: awaiting})
+	result = fr.result()
+	assert.True(t, result.Paused())
+	assert.Equal(t, []string{"3f2a9c0d41be"}, result.AwaitingApproval())
+}
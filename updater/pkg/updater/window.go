@@ -0,0 +1,362 @@
+package updater
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxCronWindowDuration bounds the length of a cron window so that checking whether it is
+	// open only has to look back a limited number of minutes.
+	maxCronWindowDuration = 7 * 24 * time.Hour
+	dateLayout            = "2006-01-02"
+)
+
+var weekdayNames = map[string]int{
+	"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+}
+
+var monthNames = map[string]int{
+	"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+	"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+}
+
+// window is a recurring period of time during which updates are allowed.
+type window interface {
+	// contains reports whether t, expressed in the schedule's time zone, is inside the window.
+	contains(t time.Time) bool
+}
+
+// dateRange is an inclusive range of calendar dates formatted as YYYY-MM-DD.
+type dateRange struct {
+	from string
+	to   string
+}
+
+// schedule restricts when the updater may start updating an instance. A nil schedule
+// is always open.
+type schedule struct {
+	location  *time.Location
+	windows   []window
+	blackouts []dateRange
+}
+
+// parseSchedule parses the maintenance windows and blackout dates in the given time zone.
+// Windows are separated by semicolons and are either day/time ranges such as
+// "Mon-Fri 22:00-06:00" or cron expressions with a duration such as "cron(0 2 * * SAT) 4h".
+// Blackout dates are separated by commas and are either single dates such as "2021-12-24"
+// or inclusive ranges such as "2021-12-24..2022-01-02". Returns nil when neither windows
+// nor blackout dates are configured.
+func parseSchedule(windows, blackouts, zone string) (*schedule, error) {
+	if strings.TrimSpace(windows) == "" && strings.TrimSpace(blackouts) == "" {
+		return nil, nil
+	}
+	if zone == "" {
+		zone = "UTC"
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance time zone %q: %w", zone, err)
+	}
+	s := &schedule{location: loc}
+	for _, w := range strings.Split(windows, ";") {
+		w = strings.TrimSpace(w)
+		if w == "" {
+			continue
+		}
+		var parsed window
+		if strings.HasPrefix(w, "cron(") {
+			parsed, err = parseCronWindow(w)
+		} else {
+			parsed, err = parseDayTimeWindow(w)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %w", w, err)
+		}
+		s.windows = append(s.windows, parsed)
+	}
+	for _, b := range SplitList(blackouts) {
+		r, err := parseDateRange(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blackout date %q: %w", b, err)
+		}
+		s.blackouts = append(s.blackouts, r)
+	}
+	return s, nil
+}
+
+// open reports whether updates may start at time t.
+func (s *schedule) open(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	t = t.In(s.location)
+	date := t.Format(dateLayout)
+	for _, b := range s.blackouts {
+		if date >= b.from && date <= b.to {
+			return false
+		}
+	}
+	if len(s.windows) == 0 {
+		return true
+	}
+	for _, w := range s.windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseDateRange(value string) (dateRange, error) {
+	from, to := value, value
+	if i := strings.Index(value, ".."); i >= 0 {
+		from, to = value[:i], value[i+2:]
+	}
+	for _, d := range []string{from, to} {
+		if _, err := time.Parse(dateLayout, d); err != nil {
+			return dateRange{}, fmt.Errorf("dates must be formatted as YYYY-MM-DD: %w", err)
+		}
+	}
+	if from > to {
+		return dateRange{}, fmt.Errorf("range start %s is after its end %s", from, to)
+	}
+	return dateRange{from: from, to: to}, nil
+}
+
+// dayTimeWindow is open between two times of day on a set of weekdays. When the end is
+// not after the start, the window runs past midnight into the following day.
+type dayTimeWindow struct {
+	days  [7]bool
+	start int // minutes since midnight
+	end   int // minutes since midnight
+}
+
+func parseDayTimeWindow(value string) (*dayTimeWindow, error) {
+	fields := strings.Fields(value)
+	w := &dayTimeWindow{}
+	var times string
+	switch len(fields) {
+	case 1:
+		for i := range w.days {
+			w.days[i] = true
+		}
+		times = fields[0]
+	case 2:
+		if err := parseDays(fields[0], &w.days); err != nil {
+			return nil, err
+		}
+		times = fields[1]
+	default:
+		return nil, fmt.Errorf("expected optional days followed by a time range such as \"Mon-Fri 22:00-06:00\"")
+	}
+	parts := strings.Split(times, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("expected a time range such as \"22:00-06:00\", got %q", times)
+	}
+	var err error
+	if w.start, err = parseTimeOfDay(parts[0]); err != nil {
+		return nil, err
+	}
+	if w.end, err = parseTimeOfDay(parts[1]); err != nil {
+		return nil, err
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("start and end of time range %q must differ", times)
+	}
+	if w.start == 24*60 {
+		return nil, fmt.Errorf("time range %q cannot start at 24:00", times)
+	}
+	return w, nil
+}
+
+func (w *dayTimeWindow) contains(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+	today := int(t.Weekday())
+	if w.start < w.end {
+		return w.days[today] && minutes >= w.start && minutes < w.end
+	}
+	yesterday := (today + 6) % 7
+	return (w.days[today] && minutes >= w.start) || (w.days[yesterday] && minutes < w.end)
+}
+
+// parseDays parses a comma-separated list of weekday names or ranges such as "Mon-Fri,Sun".
+func parseDays(value string, days *[7]bool) error {
+	for _, part := range strings.Split(value, ",") {
+		bounds := strings.Split(part, "-")
+		if len(bounds) > 2 {
+			return fmt.Errorf("invalid day range %q", part)
+		}
+		first, ok := weekdayNames[strings.ToUpper(bounds[0])]
+		if !ok {
+			return fmt.Errorf("unknown day %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdayNames[strings.ToUpper(bounds[1])]; !ok {
+				return fmt.Errorf("unknown day %q", bounds[1])
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseTimeOfDay parses HH:MM into minutes since midnight. "24:00" is accepted as the end of the day.
+func parseTimeOfDay(value string) (int, error) {
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// cronWindow is open for a fixed duration after each minute matched by a cron expression.
+type cronWindow struct {
+	minutes  [60]bool
+	hours    [24]bool
+	dom      [32]bool
+	months   [13]bool
+	dow      [7]bool
+	domStar  bool
+	dowStar  bool
+	duration time.Duration
+}
+
+// parseCronWindow parses a window such as "cron(0 22 * * MON-FRI) 8h". The expression uses
+// the five standard cron fields: minute, hour, day of month, month and day of week.
+func parseCronWindow(value string) (*cronWindow, error) {
+	end := strings.Index(value, ")")
+	if end < 0 {
+		return nil, fmt.Errorf("missing closing parenthesis")
+	}
+	fields := strings.Fields(value[len("cron("):end])
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 cron fields, got %d", len(fields))
+	}
+	rest := strings.TrimSpace(value[end+1:])
+	if rest == "" {
+		return nil, fmt.Errorf("missing window duration after cron expression")
+	}
+	duration, err := time.ParseDuration(rest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid window duration: %w", err)
+	}
+	if duration < time.Minute || duration > maxCronWindowDuration {
+		return nil, fmt.Errorf("window duration must be between 1m and %s", maxCronWindowDuration)
+	}
+	w := &cronWindow{
+		duration: duration,
+		domStar:  fields[2] == "*" || fields[2] == "?",
+		dowStar:  fields[4] == "*" || fields[4] == "?",
+	}
+	if err := parseCronField(fields[0], 0, 59, nil, w.minutes[:]); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if err := parseCronField(fields[1], 0, 23, nil, w.hours[:]); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if err := parseCronField(fields[2], 1, 31, nil, w.dom[:]); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if err := parseCronField(fields[3], 1, 12, monthNames, w.months[:]); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	// 7 is accepted as Sunday, as in most cron implementations.
+	var dow [8]bool
+	if err := parseCronField(fields[4], 0, 7, weekdayNames, dow[:]); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	copy(w.dow[:], dow[:7])
+	w.dow[0] = w.dow[0] || dow[7]
+	return w, nil
+}
+
+// parseCronField parses a single cron field made of comma-separated values, ranges and
+// steps (for example "*/15", "1-5" or "MON,WED") and sets the matching entries of set.
+func parseCronField(field string, min, max int, names map[string]int, set []bool) error {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+		first, last := min, max
+		if part != "*" && part != "?" {
+			bounds := strings.Split(part, "-")
+			if len(bounds) > 2 {
+				return fmt.Errorf("invalid range %q", part)
+			}
+			var err error
+			if first, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return err
+			}
+			last = first
+			if len(bounds) == 2 {
+				if last, err = parseCronValue(bounds[1], min, max, names); err != nil {
+					return err
+				}
+			} else if step != 1 {
+				last = max
+			}
+			if first > last {
+				return fmt.Errorf("invalid range %q", part)
+			}
+		}
+		for v := first; v <= last; v += step {
+			set[v] = true
+		}
+	}
+	return nil
+}
+
+func parseCronValue(value string, min, max int, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToUpper(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", n, min, max)
+	}
+	return n, nil
+}
+
+// matches reports whether the minute containing t is matched by the cron expression.
+func (w *cronWindow) matches(t time.Time) bool {
+	if !w.minutes[t.Minute()] || !w.hours[t.Hour()] || !w.months[t.Month()] {
+		return false
+	}
+	domMatch := w.dom[t.Day()]
+	dowMatch := w.dow[t.Weekday()]
+	// As in standard cron, when both day fields are restricted either one may match.
+	if !w.domStar && !w.dowStar {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+func (w *cronWindow) contains(t time.Time) bool {
+	start := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+	for m := time.Duration(0); m < w.duration; m += time.Minute {
+		if w.matches(start.Add(-m)) {
+			return true
+		}
+	}
+	return false
+}
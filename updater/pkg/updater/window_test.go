@@ -0,0 +1,175 @@
+package updater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleOpen(t *testing.T) {
+	cases := []struct {
+		name      string
+		windows   string
+		blackouts string
+		zone      string
+		open      []string
+		closed    []string
+	}{
+		{
+			name:    "day time window",
+			windows: "Mon-Fri 09:00-17:00",
+			open:    []string{"2021-06-07T09:00:00Z", "2021-06-11T16:59:00Z"},
+			closed:  []string{"2021-06-07T08:59:00Z", "2021-06-07T17:00:00Z", "2021-06-12T10:00:00Z"},
+		},
+		{
+			name:    "overnight window",
+			windows: "Fri 22:00-06:00",
+			open:    []string{"2021-06-11T22:00:00Z", "2021-06-12T05:59:00Z"},
+			closed:  []string{"2021-06-11T05:00:00Z", "2021-06-12T22:30:00Z", "2021-06-12T06:00:00Z"},
+		},
+		{
+			name:    "every day until end of day",
+			windows: "20:00-24:00",
+			open:    []string{"2021-06-13T23:59:00Z"},
+			closed:  []string{"2021-06-14T00:00:00Z"},
+		},
+		{
+			name:    "wrapping day range",
+			windows: "Sat-Sun 00:00-24:00",
+			open:    []string{"2021-06-12T12:00:00Z", "2021-06-13T12:00:00Z"},
+			closed:  []string{"2021-06-14T12:00:00Z"},
+		},
+		{
+			name:    "time zone",
+			windows: "Mon 01:00-02:00",
+			zone:    "America/New_York",
+			open:    []string{"2021-06-07T05:30:00Z"},
+			closed:  []string{"2021-06-07T01:30:00Z"},
+		},
+		{
+			name:    "cron window",
+			windows: "cron(30 22 * * MON-FRI) 2h",
+			open:    []string{"2021-06-07T22:30:00Z", "2021-06-08T00:29:00Z"},
+			closed:  []string{"2021-06-07T22:29:00Z", "2021-06-08T00:30:00Z", "2021-06-12T23:00:00Z"},
+		},
+		{
+			name:    "cron day of month or day of week",
+			windows: "cron(0 0 1 * SUN) 1h",
+			open:    []string{"2021-06-01T00:10:00Z", "2021-06-06T00:10:00Z"},
+			closed:  []string{"2021-06-02T00:10:00Z"},
+		},
+		{
+			name:    "multiple windows",
+			windows: "Mon 01:00-02:00; cron(0 3 * * TUE) 1h",
+			open:    []string{"2021-06-07T01:30:00Z", "2021-06-08T03:30:00Z"},
+			closed:  []string{"2021-06-08T01:30:00Z"},
+		},
+		{
+			name:      "blackout only",
+			blackouts: "2021-06-08, 2021-12-24..2022-01-02",
+			open:      []string{"2021-06-07T12:00:00Z", "2022-01-03T00:00:00Z"},
+			closed:    []string{"2021-06-08T12:00:00Z", "2021-12-31T12:00:00Z"},
+		},
+		{
+			name:      "blackout within window",
+			windows:   "Mon-Fri 09:00-17:00",
+			blackouts: "2021-06-08",
+			open:      []string{"2021-06-07T10:00:00Z"},
+			closed:    []string{"2021-06-08T10:00:00Z"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := parseSchedule(tc.windows, tc.blackouts, tc.zone)
+			require.NoError(t, err)
+			for _, ts := range tc.open {
+				at, err := time.Parse(time.RFC3339, ts)
+				require.NoError(t, err)
+				assert.True(t, s.open(at), "expected open at %s", ts)
+			}
+			for _, ts := range tc.closed {
+				at, err := time.Parse(time.RFC3339, ts)
+				require.NoError(t, err)
+				assert.False(t, s.open(at), "expected closed at %s", ts)
+			}
+		})
+	}
+}
+
+func TestScheduleUnconfigured(t *testing.T) {
+	s, err := parseSchedule("", "", "Europe/Berlin")
+	require.NoError(t, err)
+	assert.Nil(t, s)
+	assert.True(t, s.open(time.Now()))
+}
+
+func TestParseScheduleErr(t *testing.T) {
+	cases := []struct {
+		name          string
+		windows       string
+		blackouts     string
+		zone          string
+		expectedError string
+	}{
+		{
+			name:          "unknown zone",
+			windows:       "09:00-17:00",
+			zone:          "Mars/Olympus_Mons",
+			expectedError: "invalid maintenance time zone",
+		},
+		{
+			name:          "unknown day",
+			windows:       "Someday 09:00-17:00",
+			expectedError: "unknown day",
+		},
+		{
+			name:          "bad time",
+			windows:       "Mon 9am-5pm",
+			expectedError: "invalid time of day",
+		},
+		{
+			name:          "empty range",
+			windows:       "Mon 09:00-09:00",
+			expectedError: "must differ",
+		},
+		{
+			name:          "cron field count",
+			windows:       "cron(0 22 * *) 1h",
+			expectedError: "expected 5 cron fields",
+		},
+		{
+			name:          "cron out of range",
+			windows:       "cron(0 25 * * *) 1h",
+			expectedError: "hour: value 25 out of range",
+		},
+		{
+			name:          "cron missing duration",
+			windows:       "cron(0 22 * * *)",
+			expectedError: "missing window duration",
+		},
+		{
+			name:          "cron duration too long",
+			windows:       "cron(0 22 * * *) 200h",
+			expectedError: "window duration must be between",
+		},
+		{
+			name:          "bad blackout",
+			blackouts:     "24/12/2021",
+			expectedError: "invalid blackout date",
+		},
+		{
+			name:          "reversed blackout",
+			blackouts:     "2022-01-02..2021-12-24",
+			expectedError: "is after its end",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseSchedule(tc.windows, tc.blackouts, tc.zone)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedError)
+		})
+	}
+}